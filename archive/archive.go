@@ -0,0 +1,332 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yonraz/gochat_messages/models"
+)
+
+const (
+	fileSuffix  = ".jsonl.gz"
+	indexSuffix = ".index.json"
+)
+
+// Writer dumps messages into a gzipped JSONL file, the file only shows up in
+// the archive directory once Commit succeeds. Next to it goes an index of how
+// many messages every conversation has in the file
+type Writer struct {
+	path    string
+	tmp     *os.File
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+	count   int
+	counts  map[uint]int
+}
+
+func NewWriter(dir, name string) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, ".archive-*")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(tmp)
+	return &Writer{
+		path:    filepath.Join(dir, name+fileSuffix),
+		tmp:     tmp,
+		gzip:    gz,
+		encoder: json.NewEncoder(gz),
+		counts:  map[uint]int{},
+	}, nil
+}
+
+func (w *Writer) Write(msg *models.Message) error {
+	w.count++
+	w.counts[msg.ConversationID]++
+	return w.encoder.Encode(msg)
+}
+
+func (w *Writer) Count() int {
+	return w.count
+}
+
+func (w *Writer) Commit() (string, error) {
+	if err := w.gzip.Close(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.tmp.Sync(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.tmp.Close(); err != nil {
+		os.Remove(w.tmp.Name())
+		return "", err
+	}
+	// the index goes first, so an archive is never missing the index of its content
+	if err := w.writeIndex(); err != nil {
+		os.Remove(w.tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(w.tmp.Name(), w.path); err != nil {
+		os.Remove(w.tmp.Name())
+		return "", err
+	}
+	return w.path, nil
+}
+
+func (w *Writer) Abort() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+func (w *Writer) writeIndex() error {
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), ".index-*")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(tmp).Encode(w.counts); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), indexPath(w.path)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Reader serves conversation history out of the archived partitions
+type Reader struct {
+	dir string
+}
+
+func NewReader(dir string) *Reader {
+	return &Reader{
+		dir: dir,
+	}
+}
+
+// ReadMessages pages through the archived messages of a conversation newest first,
+// the offset only counts archived messages that keep accepts. A nil keep accepts all
+func (r *Reader) ReadMessages(conversationID uint, offset, limit int, keep func(*models.Message) bool) ([]models.Message, error) {
+	files, err := r.files()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	skipped := 0
+	for _, file := range files {
+		count, indexed, err := conversationCount(file, conversationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive index %v: %w", file, err)
+		}
+		if indexed && count == 0 {
+			continue
+		}
+		if indexed && keep == nil && skipped+count <= offset {
+			// the whole file is before the page
+			skipped += count
+			continue
+		}
+
+		// every archive holds one month, so the newest file goes first
+		found, err := readConversation(file, conversationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %v: %w", file, err)
+		}
+		for _, msg := range found {
+			if keep != nil && !keep(&msg) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			messages = append(messages, msg)
+			if len(messages) == limit {
+				return messages, nil
+			}
+		}
+	}
+
+	return messages, nil
+}
+
+// EachMessage walks the archived messages of a conversation oldest first, only one
+// month of the conversation is held in memory at a time
+func (r *Reader) EachMessage(conversationID uint, fn func(*models.Message) error) error {
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		count, indexed, err := conversationCount(files[i], conversationID)
+		if err != nil {
+			return fmt.Errorf("failed to read archive index %v: %w", files[i], err)
+		}
+		if indexed && count == 0 {
+			continue
+		}
+		found, err := readConversation(files[i], conversationID)
+		if err != nil {
+			return fmt.Errorf("failed to read archive %v: %w", files[i], err)
+		}
+		for j := len(found) - 1; j >= 0; j-- {
+			if err := fn(&found[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Walk streams every archived message, oldest archive first
+func (r *Reader) Walk(fn func(*models.Message) error) error {
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := eachInFile(files[i], fn); err != nil {
+			return fmt.Errorf("failed to read archive %v: %w", files[i], err)
+		}
+	}
+	return nil
+}
+
+// Rewrite passes every archived message to fn and replaces the archives where fn
+// changed a message, a file is only swapped once its new version is complete
+func (r *Reader) Rewrite(fn func(*models.Message) bool) (int, error) {
+	files, err := r.files()
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, file := range files {
+		writer, err := NewWriter(r.dir, strings.TrimSuffix(filepath.Base(file), fileSuffix))
+		if err != nil {
+			return rewritten, err
+		}
+		changed := 0
+		err = eachInFile(file, func(msg *models.Message) error {
+			if fn(msg) {
+				changed++
+			}
+			return writer.Write(msg)
+		})
+		if err != nil || changed == 0 {
+			writer.Abort()
+			if err != nil {
+				return rewritten, fmt.Errorf("failed to rewrite archive %v: %w", file, err)
+			}
+			continue
+		}
+		if _, err := writer.Commit(); err != nil {
+			return rewritten, fmt.Errorf("failed to rewrite archive %v: %w", file, err)
+		}
+		rewritten += changed
+	}
+	return rewritten, nil
+}
+
+func (r *Reader) files() ([]string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), fileSuffix) {
+			files = append(files, filepath.Join(r.dir, entry.Name()))
+		}
+	}
+	// partition names sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+func indexPath(file string) string {
+	return strings.TrimSuffix(file, fileSuffix) + indexSuffix
+}
+
+// conversationCount looks the conversation up in the index of an archive, archives
+// written before there was an index report indexed false and have to be scanned
+func conversationCount(file string, conversationID uint) (count int, indexed bool, err error) {
+	data, err := os.ReadFile(indexPath(file))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	var counts map[uint]int
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return 0, false, err
+	}
+	return counts[conversationID], true, nil
+}
+
+func readConversation(path string, conversationID uint) ([]models.Message, error) {
+	var messages []models.Message
+	err := eachInFile(path, func(msg *models.Message) error {
+		if msg.ConversationID == conversationID {
+			messages = append(messages, *msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+func eachInFile(path string, fn func(*models.Message) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg models.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+		if err := fn(&msg); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
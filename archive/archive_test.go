@@ -0,0 +1,101 @@
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/archive"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+func writePartition(t *testing.T, dir, name string, messages []models.Message) {
+	writer, err := archive.NewWriter(dir, name)
+	require.NoError(t, err)
+	for i := range messages {
+		require.NoError(t, writer.Write(&messages[i]))
+	}
+	_, err = writer.Commit()
+	require.NoError(t, err)
+}
+
+func TestReaderPagesAcrossPartitions(t *testing.T) {
+	dir := t.TempDir()
+	june := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	july := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+
+	writePartition(t, dir, "messages_p2024_06", []models.Message{
+		{ID: "a", ConversationID: 1, CreatedAt: june},
+		{ID: "b", ConversationID: 2, CreatedAt: june},
+		{ID: "c", ConversationID: 1, CreatedAt: june.Add(time.Hour)},
+	})
+	writePartition(t, dir, "messages_p2024_07", []models.Message{
+		{ID: "d", ConversationID: 1, CreatedAt: july},
+	})
+
+	reader := archive.NewReader(dir)
+
+	messages, err := reader.ReadMessages(1, 0, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "d", messages[0].ID)
+	assert.Equal(t, "c", messages[1].ID)
+
+	messages, err = reader.ReadMessages(1, 2, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a", messages[0].ID)
+
+	// the offset only counts the messages that are kept
+	notC := func(msg *models.Message) bool { return msg.ID != "c" }
+	messages, err = reader.ReadMessages(1, 1, 10, notC)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a", messages[0].ID)
+
+	messages, err = archive.NewReader(t.TempDir()+"/missing").ReadMessages(1, 0, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestReaderSkipsArchivesByIndex(t *testing.T) {
+	dir := t.TempDir()
+	june := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	july := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+	august := time.Date(2024, 8, 10, 0, 0, 0, 0, time.UTC)
+
+	writePartition(t, dir, "messages_p2024_06", []models.Message{
+		{ID: "a", ConversationID: 1, CreatedAt: june},
+	})
+	writePartition(t, dir, "messages_p2024_07", []models.Message{
+		{ID: "b", ConversationID: 2, CreatedAt: july},
+	})
+	writePartition(t, dir, "messages_p2024_08", []models.Message{
+		{ID: "c", ConversationID: 1, CreatedAt: august},
+	})
+
+	// the index says july has nothing of conversation 1 and august is before the
+	// page, so neither file should be opened
+	corrupt := []byte("not gzip")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages_p2024_07.jsonl.gz"), corrupt, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages_p2024_08.jsonl.gz"), corrupt, 0o644))
+
+	reader := archive.NewReader(dir)
+	messages, err := reader.ReadMessages(1, 1, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a", messages[0].ID)
+
+	// archives without an index are scanned
+	require.NoError(t, os.Remove(filepath.Join(dir, "messages_p2024_06.index.json")))
+	messages, err = reader.ReadMessages(1, 1, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a", messages[0].ID)
+
+	_, err = reader.ReadMessages(2, 0, 10, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+	"gorm.io/gorm"
+)
+
+// lockKey keeps replicas from managing partitions at the same time
+const lockKey int64 = 4127730194
+
+var partitionName = regexp.MustCompile(`^messages_p(\d{4})_(\d{2})$`)
+
+// PartitionManager pre-creates the monthly partitions of the messages table, moves
+// rows that landed in the default partition into their month, and moves partitions
+// older than the retention into the archive directory
+type PartitionManager struct {
+	db           *gorm.DB
+	dir          string
+	futureMonths int
+	retainMonths int
+}
+
+func NewPartitionManager(db *gorm.DB, dir string, futureMonths, retainMonths int) *PartitionManager {
+	return &PartitionManager{
+		db:           db,
+		dir:          dir,
+		futureMonths: futureMonths,
+		retainMonths: retainMonths,
+	}
+}
+
+func (m *PartitionManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.RunOnce(ctx, time.Now()); err != nil {
+			log.Printf("error managing message partitions: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *PartitionManager) RunOnce(ctx context.Context, now time.Time) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			// another replica is on it
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey)
+
+		if err := m.createPartitions(conn, now); err != nil {
+			return err
+		}
+		if err := m.drainDefault(conn); err != nil {
+			return fmt.Errorf("failed to drain messages_default: %w", err)
+		}
+		return m.archivePartitions(conn, now)
+	})
+}
+
+func (m *PartitionManager) createPartitions(conn *gorm.DB, now time.Time) error {
+	month := repository.StartOfMonth(now)
+	for i := 0; i <= m.futureMonths; i++ {
+		if err := repository.CreateMonthPartition(conn, month.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainDefault moves the rows of messages_default into monthly partitions. Postgres
+// won't create a partition while the default holds rows for its range, so the
+// default is detached while its months are created and its rows moved over
+func (m *PartitionManager) drainDefault(conn *gorm.DB) error {
+	var months []time.Time
+	err := conn.Raw(`SELECT DISTINCT date_trunc('month', created_at AT TIME ZONE 'UTC')
+		FROM messages_default`).Scan(&months).Error
+	if err != nil || len(months) == 0 {
+		return err
+	}
+
+	var moved int64
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE messages DETACH PARTITION messages_default").Error; err != nil {
+			return err
+		}
+		for _, month := range months {
+			if err := repository.CreateMonthPartition(tx, repository.StartOfMonth(month)); err != nil {
+				return err
+			}
+		}
+		insert := tx.Exec("INSERT INTO messages SELECT * FROM messages_default")
+		if insert.Error != nil {
+			return insert.Error
+		}
+		moved = insert.RowsAffected
+		if err := tx.Exec("DELETE FROM messages_default").Error; err != nil {
+			return err
+		}
+		return tx.Exec("ALTER TABLE messages ATTACH PARTITION messages_default DEFAULT").Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("moved %d messages out of messages_default into %d monthly partitions\n", moved, len(months))
+	return nil
+}
+
+func (m *PartitionManager) archivePartitions(conn *gorm.DB, now time.Time) error {
+	if m.retainMonths <= 0 {
+		return nil
+	}
+	cutoff := repository.StartOfMonth(now).AddDate(0, -m.retainMonths, 0)
+
+	var partitions []string
+	err := conn.Raw(`SELECT child.relname FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = 'messages'
+		ORDER BY child.relname`).Scan(&partitions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		match := partitionName.FindStringSubmatch(partition)
+		if match == nil {
+			continue
+		}
+		month, err := time.Parse("2006-01", match[1]+"-"+match[2])
+		if err != nil || !month.Before(cutoff) {
+			continue
+		}
+		if err := m.archivePartition(conn, partition); err != nil {
+			return fmt.Errorf("failed to archive partition %v: %w", partition, err)
+		}
+	}
+	return nil
+}
+
+// archivePartition writes the file before touching the table, so a failure
+// leaves the partition attached and it is retried on the next run
+func (m *PartitionManager) archivePartition(conn *gorm.DB, partition string) error {
+	writer, err := NewWriter(m.dir, partition)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.Table(partition).Order("conversation_id, created_at desc").Rows()
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	for rows.Next() {
+		var msg models.Message
+		if err := conn.ScanRows(rows, &msg); err != nil {
+			rows.Close()
+			writer.Abort()
+			return err
+		}
+		if err := writer.Write(&msg); err != nil {
+			rows.Close()
+			writer.Abort()
+			return err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writer.Abort()
+		return err
+	}
+
+	path, err := writer.Commit()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE messages DETACH PARTITION %s", partition)).Error; err != nil {
+			return err
+		}
+		return tx.Exec(fmt.Sprintf("DROP TABLE %s", partition)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("archived %d messages of partition %v to %v\n", writer.Count(), partition, path)
+	return nil
+}
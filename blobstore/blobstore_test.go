@@ -0,0 +1,93 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/blobstore"
+)
+
+// fakeS3 is a tiny in-memory stand-in for an S3 compatible server
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testStore(t *testing.T, store blobstore.BlobStore) {
+	ctx := context.Background()
+	content := []byte("hello attachment")
+
+	err := store.Put(ctx, "attachments/file 1.txt", bytes.NewReader(content), int64(len(content)), "text/plain")
+	require.NoError(t, err)
+
+	reader, err := store.Get(ctx, "attachments/file 1.txt")
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	require.NoError(t, store.Delete(ctx, "attachments/file 1.txt"))
+	_, err = store.Get(ctx, "attachments/file 1.txt")
+	assert.ErrorIs(t, err, blobstore.ErrNotFound)
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := blobstore.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	testStore(t, store)
+
+	err = store.Put(context.Background(), "../escape", strings.NewReader("x"), 1, "")
+	assert.Error(t, err)
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := blobstore.NewS3Store(blobstore.S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "gochat",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	require.NoError(t, err)
+
+	testStore(t, store)
+}
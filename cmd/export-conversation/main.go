@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/yonraz/gochat_messages/archive"
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// export-conversation writes the transcript of any conversation for support staff,
+// unlike the API it is not limited to participants and keeps blocked users' messages
+func main() {
+	id := flag.Uint("id", 0, "conversation to export")
+	format := flag.String("format", string(services.ExportJSON), "json, csv or html")
+	out := flag.String("out", "", "file to write, stdout when empty")
+	flag.Parse()
+
+	if *id == 0 {
+		log.Fatal("missing -id")
+	}
+	exportFormat, err := services.ParseExportFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+	initializers.LoadKeyRing()
+
+	archiveDir := os.Getenv("MESSAGE_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "./data/archive"
+	}
+	srv := services.NewMessagesService(initializers.Store)
+	srv.Archive = services.NewDecryptingArchive(archive.NewReader(archiveDir), initializers.Cipher)
+
+	file := os.Stdout
+	if *out != "" {
+		file, err = os.Create(*out)
+		if err != nil {
+			log.Fatalf("failed to create %v: %v", *out, err)
+		}
+		defer file.Close()
+	}
+	w := bufio.NewWriter(file)
+
+	if err := srv.ExportConversation(*id, "", exportFormat, w); err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/yonraz/gochat_messages/archive"
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// export-user-data assembles everything held about a user for a subject access request
+func main() {
+	username := flag.String("username", "", "user to export")
+	out := flag.String("out", "", "file to write, stdout when empty")
+	flag.Parse()
+
+	if *username == "" {
+		log.Fatal("missing -username")
+	}
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+	initializers.LoadKeyRing()
+
+	archiveDir := os.Getenv("MESSAGE_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "./data/archive"
+	}
+	srv := services.NewErasureService(initializers.Store, nil, nil)
+	srv.Archive = services.NewDecryptingArchive(archive.NewReader(archiveDir), initializers.Cipher)
+
+	file := os.Stdout
+	if *out != "" {
+		var err error
+		file, err = os.Create(*out)
+		if err != nil {
+			log.Fatalf("failed to create %v: %v", *out, err)
+		}
+		defer file.Close()
+	}
+	w := bufio.NewWriter(file)
+
+	if err := srv.ExportUserData(*username, w); err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+}
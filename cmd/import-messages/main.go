@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// import-messages loads a JSONL archive from the legacy chat. The last saved line is
+// kept in the checkpoint file, running the command again resumes after it
+func main() {
+	file := flag.String("file", "", "JSONL archive to import")
+	checkpoint := flag.String("checkpoint", "", "checkpoint file, defaults to the archive path with .checkpoint")
+	rejected := flag.String("rejected", "", "file to write the rejected lines to, stderr when empty")
+	flag.Parse()
+
+	if *file == "" {
+		log.Fatal("missing -file")
+	}
+	if *checkpoint == "" {
+		*checkpoint = *file + ".checkpoint"
+	}
+	startLine, err := readCheckpoint(*checkpoint)
+	if err != nil {
+		log.Fatalf("failed to read checkpoint: %v", err)
+	}
+
+	input, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("failed to open %v: %v", *file, err)
+	}
+	defer input.Close()
+
+	rejections := os.Stderr
+	if *rejected != "" {
+		rejections, err = os.OpenFile(*rejected, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatalf("failed to open %v: %v", *rejected, err)
+		}
+		defer rejections.Close()
+	}
+	encoder := json.NewEncoder(rejections)
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+	initializers.LoadKeyRing()
+
+	importer := services.NewImporter(services.NewMessagesService(initializers.Store))
+	report, err := importer.Import(input, services.ImportOptions{
+		StartLine: startLine,
+		Checkpoint: func(line int) error {
+			return os.WriteFile(*checkpoint, []byte(strconv.Itoa(line)), 0o644)
+		},
+		OnReject: func(rejection services.ImportRejection) {
+			if err := encoder.Encode(rejection); err != nil {
+				log.Printf("failed to report rejected line %v: %v\n", rejection.Line, err)
+			}
+		},
+	})
+	fmt.Printf("imported %d messages, skipped %d existing, rejected %d, last line %d\n",
+		report.Imported, report.Skipped, report.Rejected, report.LastLine)
+	if err != nil {
+		log.Fatalf("import stopped, run again to resume: %v", err)
+	}
+}
+
+func readCheckpoint(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/migrations"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [steps]  revert the latest migrations (default 1)
+  status        list migrations and whether they were applied
+  create <name> add a new migration pair to migrations/sql`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	ctx := context.Background()
+
+	if os.Args[1] == "create" {
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		up, down, err := migrations.Create("migrations/sql", os.Args[2])
+		if err != nil {
+			log.Fatalf("could not create migration: %v", err)
+		}
+		fmt.Printf("created %v\ncreated %v\n", up, down)
+		return
+	}
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+	sqlDB, err := initializers.DB.DB()
+	if err != nil {
+		log.Fatalf("could not get database handle: %v", err)
+	}
+	migrator, err := migrations.NewMigrator(sqlDB)
+	if err != nil {
+		log.Fatalf("could not load migrations: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatalf("invalid number of steps %q", os.Args[2])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %v\n", status.Version, status.Name, state)
+		}
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
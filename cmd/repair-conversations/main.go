@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// repair-conversations merges conversations created twice for the same participants,
+// it has to run before the 0002 migration can add the unique canonical key
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the duplicates")
+	flag.Parse()
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+
+	srv := services.NewMessagesService(initializers.Store)
+	duplicates, err := srv.RepairDuplicateConversations(*dryRun)
+	for _, group := range duplicates {
+		fmt.Printf("conversation %d <- %v\n", group[0], group[1:])
+	}
+	if err != nil {
+		log.Fatalf("repair failed: %v", err)
+	}
+
+	if *dryRun {
+		fmt.Printf("found %d duplicated conversations\n", len(duplicates))
+	} else {
+		fmt.Printf("merged %d duplicated conversations\n", len(duplicates))
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/yonraz/gochat_messages/encryption"
+	"github.com/yonraz/gochat_messages/initializers"
+)
+
+// rotate-keys rewraps the conversation data keys with the current master key. Put the
+// new key first and keep the old ones as previous keys until it has run
+func main() {
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDb()
+	initializers.LoadKeyRing()
+	if initializers.KeyRing == nil {
+		log.Fatal("no master key configured")
+	}
+
+	rotated, failed, err := encryption.RotateDataKeys(initializers.Store, initializers.KeyRing)
+	fmt.Printf("rewrapped %d data keys with master key %v, %d failed\n", rotated, initializers.KeyRing.CurrentID(), failed)
+	if err != nil {
+		log.Fatalf("rotation failed: %v", err)
+	}
+	if failed > 0 {
+		log.Fatal("some data keys use a master key that is not configured")
+	}
+}
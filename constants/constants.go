@@ -9,6 +9,10 @@ type MessageType string
 
 const (
 	UserRegisteredKey RoutingKey = "user.registered"
+	UserDeletedKey    RoutingKey = "user.deleted"
+	UserErasedKey     RoutingKey = "user.erased"
+	UserFlaggedKey    RoutingKey = "user.flagged"
+	UserWarnedKey     RoutingKey = "user.warned"
 )
 
 const (
@@ -23,6 +27,7 @@ const (
 const (
 	MessageUpdate MessageType = "message.update"
 	MessageCreate MessageType = "message.create"
+	MessageSystem MessageType = "message.system"
 )
 
 const (
@@ -36,6 +41,16 @@ const (
 	MessageSentKey      RoutingKey = "message.sent"
 	MessageDeliveredKey RoutingKey = "message.delivered"
 	MessageReadKey      RoutingKey = "message.read"
+	MessageReactionKey  RoutingKey = "message.reaction"
+	MessageExpiredKey   RoutingKey = "message.expired"
+	MessagePinnedKey    RoutingKey = "message.pinned"
+	MessageUnpinnedKey  RoutingKey = "message.unpinned"
+	MessageMentionedKey RoutingKey = "message.mentioned"
+	MessageRejectedKey  RoutingKey = "message.rejected"
+	// MessageQuarantinedKey holds a message back from delivery until it is reviewed
+	MessageQuarantinedKey RoutingKey = "message.quarantined"
+	// MessageDeletedKey removes a message a moderator deleted from clients
+	MessageDeletedKey RoutingKey = "message.deleted"
 )
 
 const (
@@ -44,8 +59,100 @@ const (
 	MessageSentQueue      Queues = "MESSAGES_SRV_MessageSentQueue"
 	MessageDeliveredQueue Queues = "MESSAGES_SRV_MessageDeliveredQueue"
 	MessageReadQueue      Queues = "MESSAGES_SRV_MessageReadQueue"
+	MessageReactionQueue  Queues = "MESSAGES_SRV_MessageReactionQueue"
+	UserDeletedQueue      Queues = "MESSAGES_SRV_UserDeletedQueue"
 )
 
 const (
 	UserSentMessage Notification = "user.sent.message"
 )
+
+type ReactionAction string
+
+const (
+	ReactionAdded   ReactionAction = "add"
+	ReactionRemoved ReactionAction = "remove"
+)
+
+type RetentionPolicy string
+
+const (
+	RetentionOff     RetentionPolicy = "off"
+	Retention24Hours RetentionPolicy = "24h"
+	Retention7Days   RetentionPolicy = "7d"
+	Retention90Days  RetentionPolicy = "90d"
+)
+
+type ScheduledStatus string
+
+const (
+	ScheduledPending   ScheduledStatus = "pending"
+	ScheduledSent      ScheduledStatus = "sent"
+	ScheduledCancelled ScheduledStatus = "cancelled"
+	ScheduledFailed    ScheduledStatus = "failed"
+)
+
+// Role is granted through the roles claim of the auth token
+type Role string
+
+const (
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+)
+
+type ErasureStatus string
+
+const (
+	ErasurePending   ErasureStatus = "pending"
+	ErasureCompleted ErasureStatus = "completed"
+	ErasureFailed    ErasureStatus = "failed"
+)
+
+// ModerationAction is what a moderation filter does with a message, ordered by severity
+type ModerationAction string
+
+const (
+	ModerationAllow      ModerationAction = "allow"
+	ModerationMask       ModerationAction = "mask"
+	ModerationQuarantine ModerationAction = "quarantine"
+	ModerationReject     ModerationAction = "reject"
+)
+
+type ConversationType string
+
+const (
+	DirectConversation ConversationType = "direct"
+	GroupConversation  ConversationType = "group"
+)
+
+// SpamAction is what the spam detector does once a sender's score reaches its threshold
+type SpamAction string
+
+const (
+	// SpamThrottle rejects the message and tells the sender to slow down
+	SpamThrottle SpamAction = "throttle"
+	// SpamHide saves the message quarantined without telling the sender
+	SpamHide SpamAction = "hide"
+	// SpamFlag publishes user.flagged, at most once per cooldown
+	SpamFlag SpamAction = "flag"
+)
+
+// ReportStatus moves from open to claimed by a moderator, and from claimed to resolved
+type ReportStatus string
+
+const (
+	ReportOpen     ReportStatus = "open"
+	ReportClaimed  ReportStatus = "claimed"
+	ReportResolved ReportStatus = "resolved"
+)
+
+// ReportAction is what the moderator did when resolving a report
+type ReportAction string
+
+const (
+	ReportDismiss       ReportAction = "dismiss"
+	ReportDeleteMessage ReportAction = "delete"
+	ReportWarnSender    ReportAction = "warn"
+	// ReportBlockSender blocks the sender for the reporter
+	ReportBlockSender ReportAction = "block"
+)
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type AdminController struct {
+	adminSrv services.AdminServiceInterface
+}
+
+func NewAdminController(srv services.AdminServiceInterface) *AdminController {
+	return &AdminController{
+		adminSrv: srv,
+	}
+}
+
+type CorrectStatusReqBody struct {
+	Status constants.RoutingKey `json:"status"`
+}
+
+type MergeConversationsReqBody struct {
+	DuplicateIDs []uint `json:"duplicateIds"`
+}
+
+type ReemitReqBody struct {
+	Event constants.RoutingKey `json:"event"`
+}
+
+func (c *AdminController) FindConversations(ctx *gin.Context) {
+	participant := ctx.Query("participant")
+	if participant == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "participant is required"})
+		return
+	}
+	middlewares.SetAuditTarget(ctx, "user:"+participant)
+
+	conversations, err := c.adminSrv.FindConversations(participant, offsetQuery(ctx))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"conversations": conversations,
+	})
+}
+
+func (c *AdminController) GetConversation(ctx *gin.Context) {
+	id, ok := conversationID(ctx)
+	if !ok {
+		return
+	}
+
+	conv, err := c.adminSrv.GetConversation(id)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"conversation": conv,
+	})
+}
+
+func (c *AdminController) ListHistory(ctx *gin.Context) {
+	id, ok := conversationID(ctx)
+	if !ok {
+		return
+	}
+
+	history, err := c.adminSrv.ListHistory(id, offsetQuery(ctx))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"messages": history,
+	})
+}
+
+func (c *AdminController) GetMessageHistory(ctx *gin.Context) {
+	history, err := c.adminSrv.GetMessageHistory(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"history": history,
+	})
+}
+
+func (c *AdminController) CorrectStatus(ctx *gin.Context) {
+	var body CorrectStatusReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid status",
+		})
+		return
+	}
+	middlewares.AddAuditDetail(ctx, "status", body.Status)
+
+	msg, previous, err := c.adminSrv.CorrectStatus(ctx.Param("id"), body.Status)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+	middlewares.AddAuditDetail(ctx, "previousStatus", previous)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": msg,
+	})
+}
+
+func (c *AdminController) ListDuplicates(ctx *gin.Context) {
+	duplicates, err := c.adminSrv.ListDuplicates()
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"duplicates": duplicates,
+	})
+}
+
+func (c *AdminController) MergeConversations(ctx *gin.Context) {
+	id, ok := conversationID(ctx)
+	if !ok {
+		return
+	}
+	var body MergeConversationsReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid duplicate ids",
+		})
+		return
+	}
+	middlewares.AddAuditDetail(ctx, "duplicateIds", body.DuplicateIDs)
+
+	conv, err := c.adminSrv.MergeConversations(id, body.DuplicateIDs)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"conversation": conv,
+	})
+}
+
+func (c *AdminController) Reemit(ctx *gin.Context) {
+	var body ReemitReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid event",
+		})
+		return
+	}
+	middlewares.AddAuditDetail(ctx, "event", body.Event)
+
+	if err := c.adminSrv.Reemit(ctx.Param("id"), body.Event); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
+
+func (c *AdminController) ListAudit(ctx *gin.Context) {
+	filter := repository.AuditFilter{
+		Actor:  ctx.Query("actor"),
+		Target: ctx.Query("target"),
+	}
+
+	entries, err := c.adminSrv.ListAudit(filter, offsetQuery(ctx))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+func offsetQuery(ctx *gin.Context) int {
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		return 0
+	}
+	return offset
+}
+
+// conversationID reads the :id param, writing the error response otherwise
+func conversationID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return 0, false
+	}
+	return uint(id), true
+}
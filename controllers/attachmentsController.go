@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+var MAX_ATTACHMENT_SIZE int64 = 25 << 20
+
+type AttachmentsController struct {
+	attSrv services.AttachmentsServiceInterface
+	msgSrv services.MessagesServiceInterface
+}
+
+func NewAttachmentsController(attSrv services.AttachmentsServiceInterface, msgSrv services.MessagesServiceInterface) *AttachmentsController {
+	return &AttachmentsController{
+		attSrv: attSrv,
+		msgSrv: msgSrv,
+	}
+}
+
+func (c *AttachmentsController) Upload(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MAX_ATTACHMENT_SIZE+1<<20)
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing file",
+		})
+		return
+	}
+	if header.Size > MAX_ATTACHMENT_SIZE {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "file is too large",
+		})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "could not read file",
+		})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := c.attSrv.Upload(ctx.Request.Context(), user, header.Filename, file, header.Size)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "could not perform operation",
+			"details": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"attachment": attachment,
+		"url":        c.attSrv.SignedURL(attachment),
+	})
+}
+
+func (c *AttachmentsController) GetAttachment(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	attachment, err := c.attSrv.GetAttachment(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if !c.canAccess(attachment, user) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"attachment": attachment,
+		"url":        c.attSrv.SignedURL(attachment),
+	})
+}
+
+// Download serves the attachment content, the signed url is the authorization
+func (c *AttachmentsController) Download(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if err := c.attSrv.VerifySignature(id, ctx.Query("expires"), ctx.Query("signature")); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, err := c.attSrv.GetAttachment(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	content, err := c.attSrv.Open(ctx.Request.Context(), attachment)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "could not perform operation",
+			"details": err,
+		})
+		return
+	}
+	defer content.Close()
+
+	ctx.Header("Content-Type", attachment.MimeType)
+	ctx.Header("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	ctx.Header("Content-Disposition", "attachment; filename="+strconv.Quote(attachment.Filename))
+	ctx.Status(http.StatusOK)
+	if _, err := io.Copy(ctx.Writer, content); err != nil {
+		log.Printf("error streaming attachment %v: %v\n", id, err)
+	}
+}
+
+// canAccess allows the uploader, and once linked, every participant of the message's
+// conversation who can see the message
+func (c *AttachmentsController) canAccess(attachment *models.Attachment, user string) bool {
+	if attachment.Uploader == user {
+		return true
+	}
+	if attachment.MessageID == nil {
+		return false
+	}
+	_, err := c.msgSrv.GetVisibleMessage(*attachment.MessageID, user)
+	return err == nil
+}
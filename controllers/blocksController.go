@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type BlocksController struct {
+	blockSrv services.BlocksServiceInterface
+}
+
+func NewBlocksController(srv services.BlocksServiceInterface) *BlocksController {
+	return &BlocksController{
+		blockSrv: srv,
+	}
+}
+
+func (c *BlocksController) ListBlocks(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	blocks, err := c.blockSrv.ListBlocks(user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+	})
+}
+
+func (c *BlocksController) Block(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	block, err := c.blockSrv.Block(user, ctx.Param("username"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"block": block,
+	})
+}
+
+func (c *BlocksController) Unblock(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := c.blockSrv.Unblock(user, ctx.Param("username")); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ConversationsController struct {
+	convSrv services.ConversationsServiceInterface
+}
+
+type SetRetentionReqBody struct {
+	Retention constants.RetentionPolicy `json:"retention" binding:"required"`
+}
+
+type PinMessageReqBody struct {
+	MessageID string `json:"messageId" binding:"required"`
+}
+
+type SaveDraftReqBody struct {
+	Content   string  `json:"content"`
+	ReplyToID *string `json:"replyToId"`
+	// Version is the version of the draft the client edited, 0 for a new draft
+	Version uint `json:"version"`
+}
+
+type SaveSettingsReqBody struct {
+	MutedUntil  *time.Time `json:"mutedUntil"`
+	Archived    bool       `json:"archived"`
+	PinnedToTop bool       `json:"pinnedToTop"`
+	Labels      []string   `json:"labels"`
+}
+
+func NewConversationsController(srv services.ConversationsServiceInterface) *ConversationsController {
+	return &ConversationsController{
+		convSrv: srv,
+	}
+}
+
+func (c *ConversationsController) SetRetention(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	var body SetRetentionReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing retention",
+		})
+		return
+	}
+
+	msg, err := c.convSrv.SetRetention(id, user, body.Retention)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"retention": body.Retention,
+		"message":   msg,
+	})
+}
+
+func (c *ConversationsController) GetPins(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	pins, err := c.convSrv.GetPins(id, user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"pins": pins,
+	})
+}
+
+func (c *ConversationsController) PinMessage(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	var body PinMessageReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing messageId",
+		})
+		return
+	}
+
+	pin, err := c.convSrv.PinMessage(id, user, body.MessageID)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"pin": pin,
+	})
+}
+
+func (c *ConversationsController) UnpinMessage(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.convSrv.UnpinMessage(id, user, ctx.Param("messageId")); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *ConversationsController) ListInbox(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		offset = 0
+	}
+
+	filter, ok := inboxFilter(ctx)
+	if !ok {
+		return
+	}
+
+	inbox, err := c.convSrv.ListInbox(user, filter, offset)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"conversations": inbox,
+	})
+}
+
+func (c *ConversationsController) GetDraft(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	draft, err := c.convSrv.GetDraft(id, user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"draft": draft,
+	})
+}
+
+func (c *ConversationsController) SaveDraft(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	var body SaveDraftReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid draft",
+		})
+		return
+	}
+
+	draft := &models.Draft{
+		ConversationID: id,
+		Username:       user,
+		Content:        body.Content,
+		ReplyToID:      body.ReplyToID,
+	}
+	if err := c.convSrv.SaveDraft(draft, body.Version); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"draft": draft,
+	})
+}
+
+func (c *ConversationsController) GetSettings(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	settings, err := c.convSrv.GetSettings(id, user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+	})
+}
+
+func (c *ConversationsController) SaveSettings(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+
+	var body SaveSettingsReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid settings",
+		})
+		return
+	}
+
+	settings := &models.ParticipantSettings{
+		ConversationID: id,
+		Username:       user,
+		MutedUntil:     body.MutedUntil,
+		Archived:       body.Archived,
+		PinnedToTop:    body.PinnedToTop,
+		Labels:         body.Labels,
+	}
+	if err := c.convSrv.SaveSettings(settings); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+	})
+}
+
+// inboxFilter reads ?archived, ?muted and ?label. Archived conversations are hidden
+// unless asked for, archived=all lists both
+func inboxFilter(ctx *gin.Context) (repository.InboxFilter, bool) {
+	filter := repository.InboxFilter{Label: ctx.Query("label")}
+
+	archived := false
+	filter.Archived = &archived
+	switch value := ctx.Query("archived"); value {
+	case "", "false":
+	case "true":
+		archived = true
+	case "all":
+		filter.Archived = nil
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid archived filter"})
+		return filter, false
+	}
+
+	if value := ctx.Query("muted"); value != "" {
+		muted, err := strconv.ParseBool(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid muted filter"})
+			return filter, false
+		}
+		filter.Muted = &muted
+	}
+
+	return filter, true
+}
+
+// conversationRequest reads the current user and the :id param, writing the error response otherwise
+func conversationRequest(ctx *gin.Context) (string, uint, bool) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return "", 0, false
+	}
+	return user, uint(id), true
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ErasureController struct {
+	erasureSrv services.ErasureServiceInterface
+}
+
+func NewErasureController(srv services.ErasureServiceInterface) *ErasureController {
+	return &ErasureController{
+		erasureSrv: srv,
+	}
+}
+
+func (c *ErasureController) EraseUser(ctx *gin.Context) {
+	admin, _ := middlewares.GetCurrentUser(ctx)
+
+	record, err := c.erasureSrv.EraseUser(ctx.Param("username"), services.ErasureSourceAdmin, admin)
+	if record != nil {
+		// the audit log names the erasure rather than the user it erased
+		middlewares.SetAuditTarget(ctx, "erasure:"+record.ID)
+	}
+	if err != nil && record == nil {
+		respondWithError(ctx, err)
+		return
+	}
+	if err != nil {
+		log.Printf("erasure %v failed: %v\n", record.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "erasure failed, run it again to retry",
+			"erasure": record,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"erasure": record,
+	})
+}
+
+func (c *ErasureController) GetErasure(ctx *gin.Context) {
+	record, err := c.erasureSrv.GetErasure(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"erasure": record,
+	})
+}
+
+// ExportUserData streams the subject access export, see MessagesController.ExportConversation
+// for why the headers wait for the first write
+func (c *ErasureController) ExportUserData(ctx *gin.Context) {
+	out := &exportResponse{
+		ctx:         ctx,
+		format:      services.ExportJSON,
+		disposition: "attachment; filename=\"user-data.json\"",
+	}
+	err := c.erasureSrv.ExportUserData(ctx.Param("username"), out)
+	if err != nil && !out.started {
+		respondWithError(ctx, err)
+		return
+	}
+	if err != nil {
+		log.Printf("error exporting the data of a user: %v\n", err)
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// respondWithError maps the service errors to their status codes
+func respondWithError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, services.ErrForbidden), errors.Is(err, services.ErrBlocked),
+		errors.Is(err, services.ErrNotSender):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, services.ErrInvalidRetention), errors.Is(err, services.ErrInvalidSchedule),
+		errors.Is(err, services.ErrInvalidBlock), errors.Is(err, services.ErrInvalidExportFormat),
+		errors.Is(err, services.ErrInvalidErasure), errors.Is(err, services.ErrRejected),
+		errors.Is(err, services.ErrInvalidReport), errors.Is(err, services.ErrInvalidReportAction),
+		errors.Is(err, services.ErrInvalidStatus), errors.Is(err, services.ErrInvalidMerge),
+		errors.Is(err, services.ErrInvalidEvent), errors.Is(err, services.ErrInvalidReply):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrThrottled):
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPinLimitReached), errors.Is(err, repository.ErrVersionConflict),
+		errors.Is(err, repository.ErrDuplicate), errors.Is(err, services.ErrRedacted):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "could not perform operation",
+			"details": err,
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ImportController struct {
+	importer services.ImporterInterface
+}
+
+func NewImportController(importer services.ImporterInterface) *ImportController {
+	return &ImportController{
+		importer: importer,
+	}
+}
+
+// Import reads a JSONL archive from the request body. The report's lastLine is the
+// checkpoint, a failed import is resumed by posting the same file with ?from=lastLine
+func (c *ImportController) Import(ctx *gin.Context) {
+	from, err := strconv.Atoi(ctx.DefaultQuery("from", "0"))
+	if err != nil || from < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid from line"})
+		return
+	}
+
+	report, err := c.importer.Import(ctx.Request.Body, services.ImportOptions{StartLine: from})
+	if err != nil {
+		log.Printf("error importing messages after line %v: %v\n", report.LastLine, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":  "import stopped",
+			"report": report,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+)
+
+func (c *MessagesController) GetMentions(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		offset = 0
+	}
+
+	mentions, err := c.msgSrv.GetMentions(user, offset)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"mentions": mentions,
+	})
+}
+
+func (c *MessagesController) CountUnreadMentions(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	counts, err := c.msgSrv.CountUnreadMentions(user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"unread": counts,
+	})
+}
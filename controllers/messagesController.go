@@ -1,11 +1,14 @@
 package controllers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/models"
 	"github.com/yonraz/gochat_messages/services"
 )
 
@@ -23,8 +26,10 @@ func NewMessagesController(srv services.MessagesServiceInterface) *MessagesContr
 	}
 }
 
+// GetMessages reads the conversation of the current user with the receiver, blocks
+// and quarantines are applied for the current user
 func (c *MessagesController) GetMessages(ctx *gin.Context) {
-	sender, senderExists := ctx.GetQuery("sender")
+	sender, _ := middlewares.GetCurrentUser(ctx)
 	receiver, recExists := ctx.GetQuery("receiver")
 	offsetQuery := ctx.DefaultQuery("offset", "0")
 	offset, queryErr := strconv.Atoi(offsetQuery)
@@ -35,15 +40,21 @@ func (c *MessagesController) GetMessages(ctx *gin.Context) {
 	}
 	
 
-	if !senderExists || !recExists {
+	if !recExists {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "missing sender and receiver query params",
+			"error": "missing receiver query param",
 		})
 		return
 	}
 	log.Printf("request to get messages with sender %v and receiver %v\n", sender, receiver)
 
-	conversation, err := c.msgSrv.GetConversationWithMessages(sender, receiver, offset)
+	var conversation *models.Conversation
+	var err error
+	if ctx.Query("archived") == "true" {
+		conversation, err = c.msgSrv.GetConversationWithArchivedMessages(sender, receiver, offset)
+	} else {
+		conversation, err = c.msgSrv.GetConversationWithMessages(sender, receiver, offset)
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": "could not perform operation",
@@ -55,4 +66,183 @@ func (c *MessagesController) GetMessages(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
 		"messages" : conversation.Messages,
 	})
-}
\ No newline at end of file
+}
+
+func (c *MessagesController) GetMessageRevisions(ctx *gin.Context) {
+	msg, ok := c.loadMessageForParticipant(ctx)
+	if !ok {
+		return
+	}
+
+	user, _ := middlewares.GetCurrentUser(ctx)
+	revisions, err := c.msgSrv.GetMessageRevisions(msg.ID, user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+	})
+}
+
+func (c *MessagesController) GetReplies(ctx *gin.Context) {
+	msg, ok := c.loadMessageForParticipant(ctx)
+	if !ok {
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		offset = 0
+	}
+
+	user, _ := middlewares.GetCurrentUser(ctx)
+	replies, err := c.msgSrv.GetReplies(msg.ID, user, offset)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"replies": replies,
+	})
+}
+
+func (c *MessagesController) GetReceipts(ctx *gin.Context) {
+	msg, ok := c.loadMessageForParticipant(ctx)
+	if !ok {
+		return
+	}
+
+	user, _ := middlewares.GetCurrentUser(ctx)
+	receipts, err := c.msgSrv.GetReceipts(msg.ID, user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	seenBy := []models.Receipt{}
+	for _, receipt := range receipts {
+		if receipt.ReadAt != nil {
+			seenBy = append(seenBy, receipt)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"receipts": receipts,
+		"seenBy":   seenBy,
+	})
+}
+
+type ForwardMessageReqBody struct {
+	ConversationID uint `json:"conversationId" binding:"required"`
+}
+
+func (c *MessagesController) ForwardMessage(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var body ForwardMessageReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing conversationId",
+		})
+		return
+	}
+
+	msg, err := c.msgSrv.ForwardMessage(ctx.Param("id"), user, body.ConversationID)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": msg,
+	})
+}
+
+// ExportConversation streams the transcript as it is read, the headers are only sent
+// once the first bytes are written so that failed checks still get a JSON error
+func (c *MessagesController) ExportConversation(ctx *gin.Context) {
+	user, id, ok := conversationRequest(ctx)
+	if !ok {
+		return
+	}
+	format, err := services.ParseExportFormat(ctx.DefaultQuery("format", string(services.ExportJSON)))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	out := &exportResponse{
+		ctx:         ctx,
+		format:      format,
+		disposition: fmt.Sprintf("attachment; filename=\"conversation-%d.%s\"", id, format),
+	}
+	err = c.msgSrv.ExportConversation(id, user, format, out)
+	if err != nil && !out.started {
+		respondWithError(ctx, err)
+		return
+	}
+	if err != nil {
+		// the status is already sent, cut the transcript short
+		log.Printf("error exporting conversation %v: %v\n", id, err)
+		ctx.Abort()
+	}
+}
+
+type exportResponse struct {
+	ctx         *gin.Context
+	format      services.ExportFormat
+	disposition string
+	started     bool
+}
+
+func (r *exportResponse) Write(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.ctx.Header("Content-Type", r.format.ContentType())
+		r.ctx.Header("Content-Disposition", r.disposition)
+		r.ctx.Status(http.StatusOK)
+	}
+	n, err := r.ctx.Writer.Write(p)
+	r.ctx.Writer.Flush()
+	return n, err
+}
+
+// loadMessageForParticipant fetches the message in the :id param and makes sure
+// the current user takes part in its conversation, writing the error response otherwise
+func (c *MessagesController) loadMessageForParticipant(ctx *gin.Context) (*models.Message, bool) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	msg, err := c.msgSrv.GetMessageByID(ctx.Param("id"))
+	if err != nil || msg == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return nil, false
+	}
+
+	conv, err := c.msgSrv.GetConversationByID(msg.ConversationID)
+	if err != nil || conv == nil || !isParticipant(conv, user) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+
+	return msg, true
+}
+
+func isParticipant(conv *models.Conversation, user string) bool {
+	for _, participant := range conv.Participants {
+		if participant == user {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package controllers_test
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/yonraz/gochat_messages/constants"
 	"github.com/yonraz/gochat_messages/controllers"
 	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/services"
 	"gorm.io/gorm"
 )
 
@@ -23,8 +25,6 @@ var result = &models.Conversation{
     Participants: []string{sender, receiver},
     Messages: generateMockMessages(sender, receiver, 50),
 }
-var expected, err = json.Marshal(result)
-var expectedStr = string(expected)
 
 type MockService struct {
     DB *gorm.DB
@@ -40,9 +40,9 @@ func (s *MockService) GetConversation(sender, receiver string) (*models.Conversa
     return nil, nil
 }
 
-func (s *MockService) GetConversationWithMessages(sender, receiver string, page int) (*models.Conversation, error) {
+func (s *MockService) GetConversationWithMessages(sender, receiver string, offset int) (*models.Conversation, error) {
     pageSize := 25
-    start := (page - 1) * pageSize
+    start := offset
     end := start + pageSize
     
     if start >= len(result.Messages) {
@@ -64,6 +64,9 @@ func (s *MockService) GetConversationWithMessages(sender, receiver string, page
     return ret, nil
 }
 
+func (s *MockService) GetConversationWithArchivedMessages(sender, receiver string, offset int) (*models.Conversation, error) {
+    return s.GetConversationWithMessages(sender, receiver, offset)
+}
 
 func (s *MockService) AddMessage(msg *models.Message) error {
     return nil
@@ -81,33 +84,94 @@ func (s *MockService) GetMessageByID(id string) (*models.Message, error) {
     return nil, nil
 }
 
+func (s *MockService) GetConversationByID(id uint) (*models.Conversation, error) {
+    return nil, nil
+}
+
+func (s *MockService) GetMessageRevisions(messageID, user string) ([]models.MessageRevision, error) {
+    return nil, nil
+}
+
+func (s *MockService) AddReaction(reaction *models.Reaction) error {
+    return nil
+}
+
+func (s *MockService) RemoveReaction(messageID, username, emoji string) error {
+    return nil
+}
+
+func (s *MockService) GetVisibleMessage(id, user string) (*models.Message, error) {
+    return nil, nil
+}
+
+func (s *MockService) GetReplies(messageID, viewer string, offset int) ([]models.Message, error) {
+    return nil, nil
+}
+
+func (s *MockService) GetReceipts(messageID, user string) ([]models.Receipt, error) {
+    return nil, nil
+}
+
+func (s *MockService) GetMentions(username string, offset int) ([]models.Mention, error) {
+    return nil, nil
+}
+
+func (s *MockService) CountUnreadMentions(username string) ([]models.MentionCount, error) {
+    return nil, nil
+}
+
+func (s *MockService) ForwardMessage(messageID, user string, conversationID uint) (*models.Message, error) {
+    return nil, nil
+}
+
+func (s *MockService) ExportConversation(conversationID uint, viewer string, format services.ExportFormat, w io.Writer) error {
+    return nil
+}
+
 func TestGetMessages(t *testing.T) {
     mockService := newMockMessagesService()
     controller := controllers.NewMessagesController(mockService)
 
     r := gin.Default()
-    r.GET("/api/messages", controller.GetMessages)
+    r.GET("/api/messages", func(ctx *gin.Context) { ctx.Set("currentUser", "foo") }, controller.GetMessages)
 
     testCases := []struct {
-        name               string
-        queryParams        string
-        expectedStatusCode int
-        expectedBody       string
-        expectConvResponse      bool
+        name                   string
+        queryParams            string
+        expectedStatusCode     int
+        expectMessagesResponse bool
+        firstID                string
+        lastID                 string
     }{
         {
-            name:               "Missing query params",
-            queryParams:        "",
-            expectedStatusCode: http.StatusBadRequest,
-            expectedBody:       `{"error":"missing sender and receiver query params"}`,
-            expectConvResponse:      false,
+            name:                   "Missing receiver",
+            queryParams:            "",
+            expectedStatusCode:     http.StatusBadRequest,
+            expectMessagesResponse: false,
+        },
+        {
+            name:                   "Valid query params without offset",
+            queryParams:            "?receiver=bar",
+            expectedStatusCode:     http.StatusOK,
+            expectMessagesResponse: true,
+            firstID:                "msg-1",
+            lastID:                 "msg-25",
         },
         {
-            name:               "Valid query params without pagination",
-            queryParams:        "?sender=foo&receiver=bar",
-            expectedStatusCode: http.StatusOK,
-            expectedBody:       expectedStr,
-            expectConvResponse:      true,
+            name:                   "Return 25 messages after an offset of 25",
+            queryParams:            "?receiver=bar&offset=25",
+            expectedStatusCode:     http.StatusOK,
+            expectMessagesResponse: true,
+            firstID:                "msg-26",
+            lastID:                 "msg-50",
+        },
+        {
+            name:                   "Invalid offset defaults to 0",
+            queryParams:            "?receiver=bar&offset=abc",
+            expectedStatusCode:     http.StatusOK,
+            expectMessagesResponse: true,
+            firstID:                "msg-1",
+            lastID:                 "msg-25",
         },
     }
 
@@ -120,43 +184,24 @@ func TestGetMessages(t *testing.T) {
 
             assert.Equal(t, tc.expectedStatusCode, w.Code)
 
-            var responseBody map[string]interface{}
+            var responseBody map[string]json.RawMessage
             err := json.Unmarshal(w.Body.Bytes(), &responseBody)
             require.NoError(t, err, "Failed to unmarshal response body")
 
-            _, exists := responseBody["conv"]
-            if tc.expectConvResponse {
-				var res map[string]models.Conversation
-        		err := json.Unmarshal(w.Body.Bytes(), &res)
-				require.NoError(t, err)
-                assert.True(t, exists, "The 'conv' key should be present")
-                assert.Equal(t, len(res["conv"].Messages), 25)
-
-				assert.Equal(t, "msg-1", res["conv"].Messages[0].ID)
-				assert.Equal(t, "msg-25", res["conv"].Messages[24].ID)
-            } else {
-                assert.False(t, exists, "The 'conv' key should not be present")
+            raw, exists := responseBody["messages"]
+            if !tc.expectMessagesResponse {
+                assert.False(t, exists, "The 'messages' key should not be present")
+                return
             }
+            require.True(t, exists, "The 'messages' key should be present")
+
+            var messages []models.Message
+            require.NoError(t, json.Unmarshal(raw, &messages))
+            require.Len(t, messages, 25)
+            assert.Equal(t, tc.firstID, messages[0].ID)
+            assert.Equal(t, tc.lastID, messages[24].ID)
         })
     }
-	t.Run("Return 25 messages with pagination 2 value", func(t *testing.T) {
-        req, _ := http.NewRequest("GET", "/api/messages?sender=foo&receiver=bar&page=2", nil)
-        w := httptest.NewRecorder()
-
-        r.ServeHTTP(w, req)
-
-        assert.Equal(t, http.StatusOK, w.Code)
-
-       var responseBody map[string]models.Conversation
-        err := json.Unmarshal(w.Body.Bytes(), &responseBody)
-        require.NoError(t, err, "Failed to unmarshal response body")
-
-        assert.Equal(t, len(responseBody["conv"].Messages), 25)
-
-        // Additional checks for pagination correctness
-        assert.Equal(t, "msg-26", responseBody["conv"].Messages[0].ID)
-        assert.Equal(t, "msg-50", responseBody["conv"].Messages[24].ID)
-    })
 }
 
 func generateMockMessages(sender, receiver string, amount int) []models.Message {
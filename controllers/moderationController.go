@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ModerationController struct {
+	moderationSrv services.ModerationServiceInterface
+}
+
+func NewModerationController(srv services.ModerationServiceInterface) *ModerationController {
+	return &ModerationController{
+		moderationSrv: srv,
+	}
+}
+
+type RejectQuarantinedReqBody struct {
+	Reason string `json:"reason"`
+}
+
+func (c *ModerationController) ListQuarantined(ctx *gin.Context) {
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		offset = 0
+	}
+
+	messages, err := c.moderationSrv.ListQuarantined(offset)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"messages": messages,
+	})
+}
+
+func (c *ModerationController) GetDecisions(ctx *gin.Context) {
+	decisions, err := c.moderationSrv.GetDecisions(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"decisions": decisions,
+	})
+}
+
+func (c *ModerationController) Release(ctx *gin.Context) {
+	msg, err := c.moderationSrv.Release(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": msg,
+	})
+}
+
+func (c *ModerationController) Reject(ctx *gin.Context) {
+	reviewer, _ := middlewares.GetCurrentUser(ctx)
+
+	// the reason is optional, an empty body is fine
+	var body RejectQuarantinedReqBody
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid reason",
+			})
+			return
+		}
+	}
+
+	if err := c.moderationSrv.Reject(ctx.Param("id"), reviewer, body.Reason); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+type AddReactionReqBody struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+func (c *MessagesController) AddReaction(ctx *gin.Context) {
+	msg, ok := c.loadMessageForParticipant(ctx)
+	if !ok {
+		return
+	}
+	user, _ := middlewares.GetCurrentUser(ctx)
+
+	var body AddReactionReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing emoji",
+		})
+		return
+	}
+
+	reaction := &models.Reaction{
+		MessageID: msg.ID,
+		Username:  user,
+		Emoji:     body.Emoji,
+	}
+	if err := c.msgSrv.AddReaction(reaction); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"reaction": reaction,
+	})
+}
+
+func (c *MessagesController) RemoveReaction(ctx *gin.Context) {
+	msg, ok := c.loadMessageForParticipant(ctx)
+	if !ok {
+		return
+	}
+	user, _ := middlewares.GetCurrentUser(ctx)
+
+	if err := c.msgSrv.RemoveReaction(msg.ID, user, ctx.Param("emoji")); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "could not perform operation",
+			"details": err,
+		})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ReportsController struct {
+	reportSrv services.ReportsServiceInterface
+}
+
+func NewReportsController(srv services.ReportsServiceInterface) *ReportsController {
+	return &ReportsController{
+		reportSrv: srv,
+	}
+}
+
+type ReportMessageReqBody struct {
+	Reason string `json:"reason"`
+}
+
+type ResolveReportReqBody struct {
+	Action constants.ReportAction `json:"action"`
+	Note   string                 `json:"note"`
+}
+
+func (c *ReportsController) ReportMessage(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var body ReportMessageReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid report",
+		})
+		return
+	}
+
+	report, err := c.reportSrv.Report(ctx.Param("id"), user, body.Reason)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"report": report,
+	})
+}
+
+func (c *ReportsController) ListReports(ctx *gin.Context) {
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		log.Println("offset query invalid, defaulting to 0.")
+		offset = 0
+	}
+
+	reports, err := c.reportSrv.ListReports(constants.ReportStatus(ctx.Query("status")), offset)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+	})
+}
+
+func (c *ReportsController) GetReport(ctx *gin.Context) {
+	report, err := c.reportSrv.GetReport(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+func (c *ReportsController) Claim(ctx *gin.Context) {
+	moderator, _ := middlewares.GetCurrentUser(ctx)
+
+	report, err := c.reportSrv.Claim(ctx.Param("id"), moderator)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+func (c *ReportsController) Resolve(ctx *gin.Context) {
+	moderator, _ := middlewares.GetCurrentUser(ctx)
+
+	var body ResolveReportReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid resolution",
+		})
+		return
+	}
+
+	report, err := c.reportSrv.Resolve(ctx.Param("id"), moderator, body.Action, body.Note)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/middlewares"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+type ScheduledMessagesController struct {
+	schedSrv services.ScheduledMessagesServiceInterface
+}
+
+type ScheduleMessageReqBody struct {
+	Receiver    string    `json:"receiver" binding:"required"`
+	Content     string    `json:"content"`
+	ReplyToID   *string   `json:"replyToId"`
+	Attachments []string  `json:"attachments"`
+	SendAt      time.Time `json:"sendAt" binding:"required"`
+}
+
+func NewScheduledMessagesController(srv services.ScheduledMessagesServiceInterface) *ScheduledMessagesController {
+	return &ScheduledMessagesController{
+		schedSrv: srv,
+	}
+}
+
+func (c *ScheduledMessagesController) Create(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	scheduled, ok := bindScheduledMessage(ctx, user)
+	if !ok {
+		return
+	}
+
+	if err := c.schedSrv.Schedule(scheduled); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"scheduled": scheduled,
+	})
+}
+
+func (c *ScheduledMessagesController) List(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	scheduled, err := c.schedSrv.ListPending(user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"scheduled": scheduled,
+	})
+}
+
+func (c *ScheduledMessagesController) Get(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	scheduled, err := c.schedSrv.Get(ctx.Param("id"), user)
+	if err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"scheduled": scheduled,
+	})
+}
+
+func (c *ScheduledMessagesController) Update(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	scheduled, ok := bindScheduledMessage(ctx, user)
+	if !ok {
+		return
+	}
+	scheduled.ID = ctx.Param("id")
+
+	if err := c.schedSrv.Update(scheduled); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"scheduled": scheduled,
+	})
+}
+
+func (c *ScheduledMessagesController) Cancel(ctx *gin.Context) {
+	user, ok := middlewares.GetCurrentUser(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := c.schedSrv.Cancel(ctx.Param("id"), user); err != nil {
+		respondWithError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func bindScheduledMessage(ctx *gin.Context, user string) (*models.ScheduledMessage, bool) {
+	var body ScheduleMessageReqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing receiver or sendAt",
+		})
+		return nil, false
+	}
+
+	return &models.ScheduledMessage{
+		Sender:      user,
+		Receiver:    body.Receiver,
+		Content:     body.Content,
+		ReplyToID:   body.ReplyToID,
+		Attachments: body.Attachments,
+		SendAt:      body.SendAt,
+	}, true
+}
@@ -0,0 +1,152 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yonraz/gochat_messages/models"
+)
+
+// contentPrefix marks encrypted content, the format is enc:v1:<data key id>:<base64 nonce+ciphertext>
+const contentPrefix = "enc:v1:"
+
+var ErrMalformedContent = errors.New("malformed encrypted content")
+
+// KeyStore persists the wrapped data keys, implemented by repository.Store
+type KeyStore interface {
+	// EnsureDataKey saves the key unless the conversation already has one, and
+	// returns the key the conversation ends up with
+	EnsureDataKey(key *models.DataKey) (*models.DataKey, error)
+	GetDataKey(id uint) (*models.DataKey, error)
+}
+
+// ContentCipher encrypts message content with the data key of its conversation.
+// Unwrapped data keys are cached for the life of the process
+type ContentCipher struct {
+	ring *KeyRing
+	keys KeyStore
+
+	mu             sync.RWMutex
+	byID           map[uint][]byte
+	byConversation map[uint]uint
+}
+
+func NewContentCipher(ring *KeyRing, keys KeyStore) *ContentCipher {
+	return &ContentCipher{
+		ring:           ring,
+		keys:           keys,
+		byID:           map[uint][]byte{},
+		byConversation: map[uint]uint{},
+	}
+}
+
+func IsEncrypted(content string) bool {
+	return strings.HasPrefix(content, contentPrefix)
+}
+
+// Encrypt binds the ciphertext to the message ID so it can't be moved to another message
+func (c *ContentCipher) Encrypt(conversationID uint, messageID, plaintext string) (string, error) {
+	keyID, key, err := c.conversationKey(conversationID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, []byte(plaintext), []byte(messageID))
+	if err != nil {
+		return "", err
+	}
+	return contentPrefix + strconv.FormatUint(uint64(keyID), 10) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns content written before encryption was enabled as is
+func (c *ContentCipher) Decrypt(messageID, content string) (string, error) {
+	if !IsEncrypted(content) {
+		return content, nil
+	}
+	keyPart, payload, found := strings.Cut(strings.TrimPrefix(content, contentPrefix), ":")
+	if !found {
+		return "", ErrMalformedContent
+	}
+	keyID, err := strconv.ParseUint(keyPart, 10, 64)
+	if err != nil {
+		return "", ErrMalformedContent
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrMalformedContent
+	}
+
+	key, err := c.dataKey(uint(keyID))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, sealed, []byte(messageID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting message %v: %w", messageID, err)
+	}
+	return string(plaintext), nil
+}
+
+func (c *ContentCipher) conversationKey(conversationID uint) (uint, []byte, error) {
+	c.mu.RLock()
+	keyID, ok := c.byConversation[conversationID]
+	key := c.byID[keyID]
+	c.mu.RUnlock()
+	if ok {
+		return keyID, key, nil
+	}
+
+	key, err := NewDataKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	wrapped, masterKeyID, err := c.ring.Wrap(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	// another replica may have created the key first, theirs wins
+	stored, err := c.keys.EnsureDataKey(&models.DataKey{
+		ConversationID: conversationID,
+		WrappedKey:     wrapped,
+		MasterKeyID:    masterKeyID,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	key, err = c.ring.Unwrap(stored.WrappedKey, stored.MasterKeyID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c.mu.Lock()
+	c.byID[stored.ID] = key
+	c.byConversation[conversationID] = stored.ID
+	c.mu.Unlock()
+	return stored.ID, key, nil
+}
+
+func (c *ContentCipher) dataKey(id uint) ([]byte, error) {
+	c.mu.RLock()
+	key, ok := c.byID[id]
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	stored, err := c.keys.GetDataKey(id)
+	if err != nil {
+		return nil, err
+	}
+	key, err = c.ring.Unwrap(stored.WrappedKey, stored.MasterKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.byID[id] = key
+	c.mu.Unlock()
+	return key, nil
+}
@@ -0,0 +1,91 @@
+package encryption_test
+
+import (
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/encryption"
+	"github.com/yonraz/gochat_messages/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, encryption.KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func newStore(t *testing.T) repository.Store {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "messages.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	store, err := repository.NewSQLiteStore(db)
+	require.NoError(t, err)
+	return store
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	ring, err := encryption.NewKeyRing(newKey(t))
+	require.NoError(t, err)
+	store := newStore(t)
+	cipher := encryption.NewContentCipher(ring, store)
+
+	encrypted, err := cipher.Encrypt(1, "message-1", "hello")
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(encrypted))
+	assert.NotContains(t, encrypted, "hello")
+
+	// a fresh cipher has to load the key from the store
+	plaintext, err := encryption.NewContentCipher(ring, store).Decrypt("message-1", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", plaintext)
+
+	_, err = cipher.Decrypt("message-2", encrypted)
+	assert.Error(t, err, "ciphertext is bound to its message")
+
+	legacy, err := cipher.Decrypt("message-3", "written before encryption")
+	require.NoError(t, err)
+	assert.Equal(t, "written before encryption", legacy)
+}
+
+func TestRotateDataKeys(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	oldRing, err := encryption.NewKeyRing(oldKey)
+	require.NoError(t, err)
+	store := newStore(t)
+
+	var encrypted []string
+	for conversationID := uint(1); conversationID <= 3; conversationID++ {
+		content, err := encryption.NewContentCipher(oldRing, store).Encrypt(conversationID, "message", "hello")
+		require.NoError(t, err)
+		encrypted = append(encrypted, content)
+	}
+
+	newRing, err := encryption.NewKeyRing(newKey, oldKey)
+	require.NoError(t, err)
+	rotated, failed, err := encryption.RotateDataKeys(store, newRing)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rotated)
+	assert.Zero(t, failed)
+
+	// the old master key is not needed anymore
+	onlyNew, err := encryption.NewKeyRing(newKey)
+	require.NoError(t, err)
+	cipher := encryption.NewContentCipher(onlyNew, store)
+	for _, content := range encrypted {
+		plaintext, err := cipher.Decrypt("message", content)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", plaintext)
+	}
+
+	rotated, _, err = encryption.RotateDataKeys(store, onlyNew)
+	require.NoError(t, err)
+	assert.Zero(t, rotated)
+}
@@ -0,0 +1,167 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yonraz/gochat_messages/models"
+)
+
+const KeySize = 32
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// KeyRing holds the master keys that wrap the data keys. New data keys are wrapped
+// with the current one, previous ones are only kept to unwrap until keys are rotated
+type KeyRing struct {
+	current string
+	keys    map[string][]byte
+}
+
+func NewKeyRing(current []byte, previous ...[]byte) (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string][]byte{}}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("master key %d is %d bytes, expected %d", i, len(key), KeySize)
+		}
+		ring.keys[keyID(key)] = key
+	}
+	ring.current = keyID(current)
+	return ring, nil
+}
+
+// LoadKeyRingFile reads base64 master keys one per line, the first one is current.
+// Empty lines and lines starting with # are skipped
+func LoadKeyRingFile(path string) (*KeyRing, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key in %v: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no master key in %v", path)
+	}
+	return NewKeyRing(keys[0], keys[1:]...)
+}
+
+// ParseKeyRing reads the current key and a comma separated list of previous keys, all base64
+func ParseKeyRing(current, previous string) (*KeyRing, error) {
+	currentKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(current))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	var previousKeys [][]byte
+	for _, value := range strings.Split(previous, ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key: %w", err)
+		}
+		previousKeys = append(previousKeys, key)
+	}
+	return NewKeyRing(currentKey, previousKeys...)
+}
+
+// CurrentID identifies the master key new data keys are wrapped with
+func (r *KeyRing) CurrentID() string {
+	return r.current
+}
+
+func (r *KeyRing) Wrap(dataKey []byte) ([]byte, string, error) {
+	wrapped, err := seal(r.keys[r.current], dataKey, []byte(r.current))
+	return wrapped, r.current, err
+}
+
+func (r *KeyRing) Unwrap(wrapped []byte, masterKeyID string) ([]byte, error) {
+	key, ok := r.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %v", ErrUnknownMasterKey, masterKeyID)
+	}
+	return open(key, wrapped, []byte(masterKeyID))
+}
+
+// Rewrap wraps the data key again with the current master key, the data key itself
+// and the messages it encrypts are unchanged
+func (r *KeyRing) Rewrap(key *models.DataKey) error {
+	dataKey, err := r.Unwrap(key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return err
+	}
+	key.WrappedKey, key.MasterKeyID, err = r.Wrap(dataKey)
+	return err
+}
+
+// NewDataKey generates a random key for a conversation
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
+
+// keyID is public, it only tells keys apart without revealing them
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// seal encrypts with AES-256-GCM and prepends the nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
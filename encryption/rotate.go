@@ -0,0 +1,51 @@
+package encryption
+
+import (
+	"errors"
+	"log"
+
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var ROTATION_BATCH_SIZE = 100
+
+// RotationStore is the part of repository.Store that rotation needs
+type RotationStore interface {
+	ListDataKeysToRewrap(masterKeyID string, afterID uint, limit int) ([]models.DataKey, error)
+	RewrapDataKey(key *models.DataKey, previousMasterKeyID string) error
+}
+
+// RotateDataKeys rewraps every data key with the current master key of the ring.
+// Keys whose master key is not in the ring are reported as failed and left as they are
+func RotateDataKeys(store RotationStore, ring *KeyRing) (rotated, failed int, err error) {
+	var afterID uint
+	for {
+		keys, err := store.ListDataKeysToRewrap(ring.CurrentID(), afterID, ROTATION_BATCH_SIZE)
+		if err != nil {
+			return rotated, failed, err
+		}
+		if len(keys) == 0 {
+			return rotated, failed, nil
+		}
+
+		for i := range keys {
+			key := &keys[i]
+			afterID = key.ID
+			previous := key.MasterKeyID
+			if err := ring.Rewrap(key); err != nil {
+				log.Printf("error rewrapping data key %v: %v\n", key.ID, err)
+				failed++
+				continue
+			}
+			err := store.RewrapDataKey(key, previous)
+			if errors.Is(err, repository.ErrVersionConflict) {
+				// rewrapped by a concurrent rotation
+				continue
+			} else if err != nil {
+				return rotated, failed, err
+			}
+			rotated++
+		}
+	}
+}
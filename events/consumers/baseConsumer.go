@@ -21,7 +21,7 @@ type Consumer struct {
 func NewConsumer(channel *amqp.Channel, queueName constants.Queues, routingKey constants.RoutingKey, exchange constants.Exchange, handlerFunc func(*services.MessagesService, amqp.Delivery) error) *Consumer {
 	return &Consumer {
 		channel: channel,
-		srv: services.NewMessagesService(initializers.DB),
+		srv: services.NewMessagesService(initializers.Store),
 		queueName: string(queueName),
 		routingKey: string(routingKey),
 		exchange: string(exchange),
@@ -46,7 +46,8 @@ func (c *Consumer) Consume() error {
 	go func () {
 		for msg := range msgs {
 			if err := c.handlerFunc(c.srv, msg); err != nil {
-				fmt.Printf("error consuming message %v: %v\n", msg, err)
+				// the delivery body holds the message content, only its routing key is logged
+				fmt.Printf("error consuming message %v with routing key %v: %v\n", msg.MessageId, msg.RoutingKey, err)
 				msg.Nack(false, false)		
 			} else {
 				msg.Ack(false)
@@ -0,0 +1,71 @@
+package consumers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/streadway/amqp"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+func NewMessageReactionConsumer(channel *amqp.Channel) *Consumer {
+	return &Consumer{
+		channel:     channel,
+		srv:         services.NewMessagesService(initializers.Store),
+		queueName:   string(constants.MessageReactionQueue),
+		routingKey:  string(constants.MessageReactionKey),
+		exchange:    string(constants.MessageEventsExchange),
+		handlerFunc: MessageReactionHandler,
+	}
+}
+
+func MessageReactionHandler(srv *services.MessagesService, msg amqp.Delivery) error {
+	var parsed models.WsReaction
+
+	if err := json.Unmarshal(msg.Body, &parsed); err != nil {
+		log.Printf("error unmarshalling reaction: %v\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("reaction %v consumed on exchange %v with routing key %v\n", parsed, constants.MessageEventsExchange, constants.MessageReactionKey)
+
+	if parsed.MessageID == "" || parsed.Username == "" || parsed.Emoji == "" {
+		err := fmt.Errorf("error processing: reaction is missing messageId, username or emoji")
+		log.Printf("%v\n", err)
+		return err
+	}
+
+	var err error
+	switch parsed.Action {
+	case constants.ReactionAdded:
+		err = srv.AddReaction(&models.Reaction{
+			MessageID: parsed.MessageID,
+			Username:  parsed.Username,
+			Emoji:     parsed.Emoji,
+		})
+		if errors.Is(err, services.ErrForbidden) || errors.Is(err, repository.ErrNotFound) {
+			// retrying would not make the message visible to the user
+			log.Printf("dropping reaction of %v to message %v: %v\n", parsed.Username, parsed.MessageID, err)
+			return nil
+		}
+	case constants.ReactionRemoved:
+		err = srv.RemoveReaction(parsed.MessageID, parsed.Username, parsed.Emoji)
+	default:
+		err = fmt.Errorf("error processing: unknown reaction action: %v", parsed.Action)
+		log.Printf("%v\n", err)
+		return err
+	}
+	if err != nil {
+		log.Printf("error saving reaction to db: %v\n", err)
+		return err
+	}
+
+	log.Printf("messages service applied reaction %v", parsed)
+	return nil
+}
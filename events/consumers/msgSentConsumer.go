@@ -2,20 +2,27 @@ package consumers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/streadway/amqp"
 	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/events/publishers"
 	"github.com/yonraz/gochat_messages/initializers"
 	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
 	"github.com/yonraz/gochat_messages/services"
 )
 
 func NewMessageSentConsumer(channel *amqp.Channel) *Consumer {
+	srv := services.NewMessagesService(initializers.Store)
+	srv.Publisher = publishers.NewPublisher(channel)
+	srv.Moderator = initializers.Moderator
+	srv.Spam = initializers.SpamDetector
 	return &Consumer{
 		channel: channel,
-		srv: services.NewMessagesService(initializers.DB),
+		srv: srv,
 		queueName: string(constants.MessageSentQueue),
 		routingKey: string(constants.MessageSentKey),
 		exchange: string(constants.MessageEventsExchange),
@@ -33,6 +40,16 @@ func MessageSentHanlder(srv *services.MessagesService, msg amqp.Delivery) error
 
 	fmt.Printf("message %v consumed on exchange %v with routing key %v\n", parsed, constants.MessageEventsExchange, constants.MessageSentKey)
 
+	// scheduled messages are saved before they are published, and redeliveries of
+	// an event must not be saved twice
+	if _, err := srv.GetMessageByID(parsed.ID); err == nil {
+		log.Printf("message %v already exists, skipping\n", parsed.ID)
+		return nil
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("error fetching message %v: %v\n", parsed.ID, err)
+		return err
+	}
+
 	conv, err := srv.GetConversation(parsed.Sender, parsed.Receiver)
 	if err != nil {
 		log.Printf("error fetching conversation: %v\n", err)
@@ -57,23 +74,33 @@ func MessageSentHanlder(srv *services.MessagesService, msg amqp.Delivery) error
 		Receiver:       parsed.Receiver,
 		Read:           parsed.Read,
 		Status:         parsed.Status,
+		ReplyToID:      parsed.ReplyToID,
 		CreatedAt: parsed.CreatedAt,
 		UpdatedAt: parsed.UpdatedAt,
 	}
 
 	// Add or update the message
 	if parsed.Type == constants.MessageCreate {
-		err = srv.AddMessage(message)
+		err = srv.AddMessageWithAttachments(message, parsed.Attachments)
 	} else {
 		err = fmt.Errorf("error processing: expected message type to be message.create, instead was: %v", parsed.Type)
 		log.Printf("%v\n", err)
 		return err
 	}
-	if err != nil {
+	if errors.Is(err, services.ErrBlocked) || errors.Is(err, services.ErrRejected) || errors.Is(err, services.ErrThrottled) ||
+		errors.Is(err, services.ErrInvalidReply) {
+		log.Printf("rejected message %v: %v\n", message.ID, err)
+		srv.PublishRejection(message, err.Error())
+		return nil
+	} else if err != nil {
 		log.Printf("error inserting message to db: %v\n", err)
 		return err
 	}
 
-	log.Printf("messages service added message %v", message)
+	if err := srv.ClearDraft(conv.ID, message.Sender, message.CreatedAt); err != nil {
+		log.Printf("error clearing draft of %v in conversation %v: %v\n", message.Sender, conv.ID, err)
+	}
+
+	log.Printf("messages service added message %v", message.ID)
 	return nil
 }
@@ -2,11 +2,14 @@ package consumers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/streadway/amqp"
 	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/events/publishers"
 	"github.com/yonraz/gochat_messages/initializers"
 	"github.com/yonraz/gochat_messages/models"
 	"github.com/yonraz/gochat_messages/services"
@@ -15,13 +18,33 @@ import (
 func NewMessageUpdatedConsumer(channel *amqp.Channel) *Consumer {
 	return &Consumer{
 		channel:     channel,
-		srv:         services.NewMessagesService(initializers.DB),
+		srv:         newUpdatesService(channel),
 		queueName:   string(constants.MessageReadQueue),
 		routingKey:  string(constants.MessageReadKey),
 		exchange:    string(constants.MessageEventsExchange),
 		handlerFunc: MessageUpdatedHandler,
 	}
 }
+func NewMessageDeliveredConsumer(channel *amqp.Channel) *Consumer {
+	return &Consumer{
+		channel:     channel,
+		srv:         newUpdatesService(channel),
+		queueName:   string(constants.MessageDeliveredQueue),
+		routingKey:  string(constants.MessageDeliveredKey),
+		exchange:    string(constants.MessageEventsExchange),
+		handlerFunc: MessageUpdatedHandler,
+	}
+}
+
+// newUpdatesService moderates edits the same way new messages are
+func newUpdatesService(channel *amqp.Channel) *services.MessagesService {
+	srv := services.NewMessagesService(initializers.Store)
+	srv.Publisher = publishers.NewPublisher(channel)
+	srv.Moderator = initializers.Moderator
+	srv.Spam = initializers.SpamDetector
+	return srv
+}
+
 func MessageUpdatedHandler(srv *services.MessagesService, msg amqp.Delivery) error {
 	var parsed models.WsMessage
 
@@ -30,7 +53,7 @@ func MessageUpdatedHandler(srv *services.MessagesService, msg amqp.Delivery) err
 		return err
 	}
 
-	fmt.Printf("message %v consumed on exchange %v with routing key %v\n", parsed, constants.MessageEventsExchange, constants.MessageReadKey)
+	fmt.Printf("message %v consumed on exchange %v with routing key %v\n", parsed, constants.MessageEventsExchange, msg.RoutingKey)
 
 	conv, err := srv.GetConversation(parsed.Sender, parsed.Receiver)
 	if err != nil || conv == nil {
@@ -48,30 +71,58 @@ func MessageUpdatedHandler(srv *services.MessagesService, msg amqp.Delivery) err
 		return nil
 	}
 	isRead := string(parsed.Status) == string(constants.MessageReadKey)
+	status := parsed.Status
+	// Read is derived from the receipts, a late delivered event must not unread the message
+	if existingMessage.Read && !isRead {
+		isRead = true
+		status = constants.MessageReadKey
+	}
+
+	// delivered and read events only change the status, the client's copy of the
+	// content may be stale or forged
+	content := parsed.Content
+	if isStatusEvent(parsed.Status) {
+		content = existingMessage.Content
+	} else if existingMessage.Redacted {
+		log.Printf("dropping edit of redacted message %v\n", existingMessage.ID)
+		return nil
+	}
 
 	// Create a new message
 	message := &models.Message{
 		ID:             parsed.ID,
-		Content:        parsed.Content,
+		Content:        content,
 		Sender:         parsed.Sender,
 		Type:           parsed.Type,
 		ConversationID: conv.ID,
 		Receiver:       parsed.Receiver,
 		Read:           isRead,
-		Status:         parsed.Status,
+		Status:         status,
 		CreatedAt:      parsed.CreatedAt,
 		UpdatedAt:      parsed.UpdatedAt,
 		Version:        existingMessage.Version,
 	}
 
 	if parsed.Type == constants.MessageUpdate {
+		err = recordReceipt(srv, &parsed)
+		if err != nil {
+			log.Printf("error recording receipt: %v\n", err)
+			return err
+		}
 		_, err = srv.UpdateMessage(message)
 	} else {
 		err = fmt.Errorf("error processing: expected message type to be message.update, instead was: %v", parsed.Type)
 		log.Printf("%v\n", err)
 		return err
 	}
-	if err != nil {
+	if errors.Is(err, services.ErrNotSender) {
+		log.Printf("dropping edit of message %v by %v who did not send it\n", message.ID, message.Sender)
+		return nil
+	} else if errors.Is(err, services.ErrRejected) || errors.Is(err, services.ErrThrottled) {
+		log.Printf("rejected edit of message %v: %v\n", message.ID, err)
+		srv.PublishRejection(existingMessage, err.Error())
+		return nil
+	} else if err != nil {
 		log.Printf("error updating message in db: %v\n", err)
 		
 		return err
@@ -80,6 +131,26 @@ func MessageUpdatedHandler(srv *services.MessagesService, msg amqp.Delivery) err
 	
 
 
-	log.Printf("messages service updated message %v", message)
+	log.Printf("messages service updated message %v", message.ID)
 	return nil
-}
\ No newline at end of file
+}
+
+func isStatusEvent(status constants.RoutingKey) bool {
+	return status == constants.MessageDeliveredKey || status == constants.MessageReadKey
+}
+
+func recordReceipt(srv *services.MessagesService, parsed *models.WsMessage) error {
+	if !isStatusEvent(parsed.Status) {
+		return nil
+	}
+	recipient := parsed.UpdatedBy
+	if recipient == "" {
+		recipient = parsed.Receiver
+	}
+	at := parsed.UpdatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	return srv.RecordReceipt(parsed.ID, recipient, parsed.Status, at)
+}
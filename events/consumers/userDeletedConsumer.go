@@ -0,0 +1,51 @@
+package consumers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/streadway/amqp"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+// NewUserDeletedConsumer erases the data of the accounts deleted by the users service
+func NewUserDeletedConsumer(channel *amqp.Channel, erasure *services.ErasureService) *Consumer {
+	return &Consumer{
+		channel:    channel,
+		srv:        services.NewMessagesService(initializers.Store),
+		queueName:  string(constants.UserDeletedQueue),
+		routingKey: string(constants.UserDeletedKey),
+		exchange:   string(constants.UserEventsExchange),
+		handlerFunc: func(_ *services.MessagesService, msg amqp.Delivery) error {
+			return UserDeletedHandler(erasure, msg)
+		},
+	}
+}
+
+// UserDeletedHandler leaves failed erasures in the erasure records, they are retried
+// through the admin API
+func UserDeletedHandler(erasure services.ErasureServiceInterface, msg amqp.Delivery) error {
+	var parsed models.UserDeletedEvent
+	if err := json.Unmarshal(msg.Body, &parsed); err != nil {
+		log.Printf("error unmarshalling user deleted event: %v\n", err)
+		return err
+	}
+	if parsed.Username == "" {
+		return errors.New("user deleted event without a username")
+	}
+
+	record, err := erasure.EraseUser(parsed.Username, services.ErasureSourceEvent, "")
+	if err != nil {
+		if record != nil {
+			log.Printf("erasure %v failed: %v\n", record.ID, err)
+		}
+		return err
+	}
+
+	log.Printf("erasure %v completed, %d messages redacted\n", record.ID, record.MessagesRedacted)
+	return nil
+}
@@ -0,0 +1,43 @@
+package publishers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/streadway/amqp"
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+type Publisher struct {
+	channel *amqp.Channel
+}
+
+func NewPublisher(channel *amqp.Channel) *Publisher {
+	return &Publisher{
+		channel: channel,
+	}
+}
+
+func (p *Publisher) Publish(exchange constants.Exchange, routingKey constants.RoutingKey, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	err = p.channel.Publish(
+		string(exchange),
+		string(routingKey),
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish %v on %v: %w", routingKey, exchange, err)
+	}
+
+	return nil
+}
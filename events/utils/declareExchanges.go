@@ -7,19 +7,24 @@ import (
 	"github.com/yonraz/gochat_messages/constants"
 )
 
+// DeclareExchanges declares the exchanges this service publishes to or binds queues on,
+// declaring an existing exchange with the same settings is a no-op
 func DeclareExchanges(channel *amqp.Channel) error {
-	err := channel.ExchangeDeclare(
-		string(constants.MessageEventsExchange),
-		"topic",             
-		true,
-		false,
-		false,
-		false,              
-		nil,   
-	)
-	if err != nil {
-		return err
+	exchanges := []constants.Exchange{constants.MessageEventsExchange, constants.UserEventsExchange}
+	for _, exchange := range exchanges {
+		err := channel.ExchangeDeclare(
+			string(exchange),
+			"topic",
+			true,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exchanges %v created!\n", exchange)
 	}
-	fmt.Printf("Exchanges %v created!\n", constants.MessageEventsExchange)
 	return nil
-}
\ No newline at end of file
+}
@@ -53,6 +53,9 @@ func DeclareQueues(channel *amqp.Channel) error {
 	queues := []queueConstructor{
 		{Queue: constants.MessageSentQueue, Key: constants.MessageSentKey, Exchange: constants.MessageEventsExchange},
 		{Queue: constants.MessageReadQueue, Key: constants.MessageReadKey, Exchange: constants.MessageEventsExchange},
+		{Queue: constants.MessageDeliveredQueue, Key: constants.MessageDeliveredKey, Exchange: constants.MessageEventsExchange},
+		{Queue: constants.MessageReactionQueue, Key: constants.MessageReactionKey, Exchange: constants.MessageEventsExchange},
+		{Queue: constants.UserDeletedQueue, Key: constants.UserDeletedKey, Exchange: constants.UserEventsExchange},
 	}
 
 	for _, q := range queues {
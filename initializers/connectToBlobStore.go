@@ -0,0 +1,35 @@
+package initializers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yonraz/gochat_messages/blobstore"
+)
+
+var BlobStore blobstore.BlobStore
+
+func ConnectToBlobStore() {
+	var err error
+	switch os.Getenv("BLOB_STORE") {
+	case "s3":
+		BlobStore, err = blobstore.NewS3Store(blobstore.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		dir := os.Getenv("BLOB_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data/blobs"
+		}
+		BlobStore, err = blobstore.NewLocalStore(dir)
+	}
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	fmt.Println("Blob store ready")
+}
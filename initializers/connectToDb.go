@@ -2,14 +2,17 @@ package initializers
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"time"
 
+	"github.com/yonraz/gochat_messages/repository"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
+var Store repository.Store
 
 
 func ConnectToDb() {
@@ -21,7 +24,8 @@ func ConnectToDb() {
 	var err error
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
         host, port, user, password, dbname)
-	fmt.Println(dsn)
+	// the dsn carries the password, only say where we connect
+	log.Printf("connecting to postgres at %s:%s, database %s\n", host, port, dbname)
 
 	maxRetries := 3
     initialDelay := time.Second
@@ -30,6 +34,7 @@ func ConnectToDb() {
         DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
         if err == nil {
             fmt.Println("Connected to postgres!")
+            Store = repository.NewPostgresStore(DB)
             return
         }
 
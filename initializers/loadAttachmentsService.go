@@ -0,0 +1,23 @@
+package initializers
+
+import (
+	"log"
+	"os"
+
+	"github.com/yonraz/gochat_messages/services"
+)
+
+var AttachmentsService *services.AttachmentsService
+
+// LoadAttachmentsService signs the download urls with ATTACHMENT_URL_KEY, starting
+// without a usable key would let anyone forge them. It has to run after LoadKeyRing
+// and ConnectToBlobStore
+func LoadAttachmentsService() {
+	var err error
+	AttachmentsService, err = services.NewAttachmentsService(Store, BlobStore, os.Getenv("ATTACHMENT_URL_KEY"))
+	if err != nil {
+		log.Printf("error loading the attachments service: %v\n", err)
+		panic(err)
+	}
+	log.Printf("attachment url signing ready\n")
+}
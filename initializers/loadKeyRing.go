@@ -0,0 +1,36 @@
+package initializers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yonraz/gochat_messages/encryption"
+	"github.com/yonraz/gochat_messages/services"
+)
+
+var KeyRing *encryption.KeyRing
+var Cipher *encryption.ContentCipher
+
+// LoadKeyRing reads the master keys from MESSAGE_MASTER_KEY_FILE, or from
+// MESSAGE_MASTER_KEY and MESSAGE_PREVIOUS_MASTER_KEYS, and wraps Store so that
+// message content is encrypted at rest. It has to run after ConnectToDb
+func LoadKeyRing() {
+	var err error
+	switch {
+	case os.Getenv("MESSAGE_MASTER_KEY_FILE") != "":
+		KeyRing, err = encryption.LoadKeyRingFile(os.Getenv("MESSAGE_MASTER_KEY_FILE"))
+	case os.Getenv("MESSAGE_MASTER_KEY") != "":
+		KeyRing, err = encryption.ParseKeyRing(os.Getenv("MESSAGE_MASTER_KEY"), os.Getenv("MESSAGE_PREVIOUS_MASTER_KEYS"))
+	default:
+		fmt.Println("No master key configured, message content is stored in plaintext")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+
+	Cipher = encryption.NewContentCipher(KeyRing, Store)
+	Store = services.NewEncryptingStore(Store, Cipher)
+	fmt.Printf("Message encryption enabled with master key %v\n", KeyRing.CurrentID())
+}
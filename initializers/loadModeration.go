@@ -0,0 +1,28 @@
+package initializers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yonraz/gochat_messages/moderation"
+)
+
+var Moderator *moderation.Moderator
+
+// LoadModeration reads the moderation rules from MODERATION_CONFIG_FILE, new messages
+// are not moderated without it
+func LoadModeration() {
+	path := os.Getenv("MODERATION_CONFIG_FILE")
+	if path == "" {
+		fmt.Println("No moderation config, messages are not moderated")
+		return
+	}
+
+	var err error
+	Moderator, err = moderation.LoadConfigFile(path)
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	fmt.Printf("Moderation rules loaded from %v\n", path)
+}
@@ -0,0 +1,35 @@
+package initializers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yonraz/gochat_messages/spam"
+)
+
+var SpamDetector *spam.Detector
+
+// LoadSpamDetector counts messages in Redis with the defaults, or with the limits in
+// SPAM_CONFIG_FILE. SPAM_DETECTION=off turns it off. It has to run after ConnectToRedis
+func LoadSpamDetector() {
+	if os.Getenv("SPAM_DETECTION") == "off" {
+		fmt.Println("Spam detection is off")
+		return
+	}
+
+	config := spam.DefaultConfig()
+	if path := os.Getenv("SPAM_CONFIG_FILE"); path != "" {
+		var err error
+		if config, err = spam.LoadConfigFile(path); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+	}
+	detector, err := spam.NewDetector(config, spam.NewRedisCounters(RedisClient))
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	SpamDetector = detector
+	fmt.Println("Spam detection enabled")
+}
@@ -0,0 +1,33 @@
+package initializers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yonraz/gochat_messages/migrations"
+)
+
+// VerifySchema refuses to start the service while migrations are pending,
+// they are applied with the migrate command
+func VerifySchema() {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		panic(err)
+	}
+	migrator, err := migrations.NewMigrator(sqlDB)
+	if err != nil {
+		panic(err)
+	}
+
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	if len(pending) > 0 {
+		for _, migration := range pending {
+			fmt.Printf("pending migration %04d_%s\n", migration.Version, migration.Name)
+		}
+		panic(fmt.Sprintf("database schema is behind by %d migrations, run `migrate up` first", len(pending)))
+	}
+	fmt.Println("Database schema is up to date")
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/archive"
+	"github.com/yonraz/gochat_messages/constants"
 	"github.com/yonraz/gochat_messages/controllers"
 	"github.com/yonraz/gochat_messages/events/consumers"
+	"github.com/yonraz/gochat_messages/events/publishers"
 	"github.com/yonraz/gochat_messages/initializers"
+	"github.com/yonraz/gochat_messages/middlewares"
 	"github.com/yonraz/gochat_messages/services"
 )
 
@@ -17,9 +24,14 @@ func init () {
 	time.Sleep(1 * time.Minute)
 	initializers.LoadEnvVariables()
 	initializers.ConnectToDb()
-	initializers.SyncDatabase()
+	initializers.VerifySchema()
+	initializers.LoadKeyRing()
+	initializers.LoadModeration()
 	initializers.ConnectToRabbitmq()
 	initializers.ConnectToRedis()
+	initializers.LoadSpamDetector()
+	initializers.ConnectToBlobStore()
+	initializers.LoadAttachmentsService()
 }
 
 func main() {
@@ -35,11 +47,36 @@ func main() {
 			log.Printf("Failed to close RabbitMQ connection: %v", err)
 		}
 	}()
-	srv := services.NewMessagesService(initializers.DB)
+	archiveDir := os.Getenv("MESSAGE_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "./data/archive"
+	}
+	srv := services.NewMessagesService(initializers.Store)
+	publisher := publishers.NewPublisher(initializers.RmqChannel)
+	srv.Archive = services.NewDecryptingArchive(archive.NewReader(archiveDir), initializers.Cipher)
+	srv.Publisher = publisher
+	srv.Moderator = initializers.Moderator
+	srv.Spam = initializers.SpamDetector
 	c := controllers.NewMessagesController(srv)
+	convSrv := services.NewConversationsService(initializers.Store, publisher)
+	conversations := controllers.NewConversationsController(convSrv)
+	attachments := controllers.NewAttachmentsController(initializers.AttachmentsService, srv)
+	scheduled := controllers.NewScheduledMessagesController(services.NewScheduledMessagesService(initializers.Store))
+	blocks := controllers.NewBlocksController(services.NewBlocksService(initializers.Store))
+	imports := controllers.NewImportController(services.NewImporter(srv))
+	erasureSrv := services.NewErasureService(initializers.Store, initializers.BlobStore, publisher)
+	erasureSrv.Archive = srv.Archive
+	erasureSrv.ArchiveRewriter = archive.NewReader(archiveDir)
+	erasure := controllers.NewErasureController(erasureSrv)
+	moderation := controllers.NewModerationController(services.NewModerationService(srv, initializers.BlobStore))
+	reports := controllers.NewReportsController(services.NewReportsService(srv, initializers.BlobStore))
+	adminTools := controllers.NewAdminController(services.NewAdminService(srv))
 
 	messageSentConsumer := consumers.NewMessageSentConsumer(initializers.RmqChannel)
 	messageUpdatedConsumer := consumers.NewMessageUpdatedConsumer(initializers.RmqChannel)
+	messageDeliveredConsumer := consumers.NewMessageDeliveredConsumer(initializers.RmqChannel)
+	messageReactionConsumer := consumers.NewMessageReactionConsumer(initializers.RmqChannel)
+	userDeletedConsumer := consumers.NewUserDeletedConsumer(initializers.RmqChannel, erasureSrv)
 	go func() {
 		if err := messageSentConsumer.Consume(); err != nil {
 			log.Fatalf("MessageSentConsumer failed: %v", err)
@@ -50,7 +87,98 @@ func main() {
 			log.Fatalf("MessageUpdatedConsumer failed: %v", err)
 		}
 	}()
+	go func() {
+		if err := messageDeliveredConsumer.Consume(); err != nil {
+			log.Fatalf("MessageDeliveredConsumer failed: %v", err)
+		}
+	}()
+	go func() {
+		if err := messageReactionConsumer.Consume(); err != nil {
+			log.Fatalf("MessageReactionConsumer failed: %v", err)
+		}
+	}()
+	go func() {
+		if err := userDeletedConsumer.Consume(); err != nil {
+			log.Fatalf("UserDeletedConsumer failed: %v", err)
+		}
+	}()
+
+	partitionManager := archive.NewPartitionManager(initializers.DB, archiveDir, 3, envInt("MESSAGE_RETENTION_MONTHS", 12))
+	go partitionManager.Run(context.Background(), 6*time.Hour)
+	retentionSweeper := services.NewRetentionSweeper(initializers.Store, initializers.BlobStore, publisher)
+	go retentionSweeper.Run(context.Background(), time.Minute)
+	scheduler := services.NewMessageScheduler(srv, publisher)
+	go scheduler.Run(context.Background(), 5*time.Second)
+
+	router.GET("/api/attachments/:id/content", attachments.Download)
+
+	authorized := router.Group("/api", middlewares.CurrentUser, middlewares.RequireAuth)
+	authorized.GET("/messages", c.GetMessages)
+	authorized.GET("/messages/:id/revisions", c.GetMessageRevisions)
+	authorized.GET("/messages/:id/replies", c.GetReplies)
+	authorized.GET("/messages/:id/receipts", c.GetReceipts)
+	authorized.GET("/mentions", c.GetMentions)
+	authorized.GET("/mentions/unread", c.CountUnreadMentions)
+	authorized.POST("/messages/:id/forward", c.ForwardMessage)
+	authorized.POST("/messages/:id/reactions", c.AddReaction)
+	authorized.POST("/messages/:id/report", reports.ReportMessage)
+	authorized.DELETE("/messages/:id/reactions/:emoji", c.RemoveReaction)
+	authorized.POST("/attachments", attachments.Upload)
+	authorized.GET("/attachments/:id", attachments.GetAttachment)
+	authorized.GET("/conversations", conversations.ListInbox)
+	authorized.GET("/conversations/:id/export", c.ExportConversation)
+	authorized.PUT("/conversations/:id/retention", conversations.SetRetention)
+	authorized.GET("/conversations/:id/pins", conversations.GetPins)
+	authorized.POST("/conversations/:id/pins", conversations.PinMessage)
+	authorized.DELETE("/conversations/:id/pins/:messageId", conversations.UnpinMessage)
+	authorized.GET("/conversations/:id/draft", conversations.GetDraft)
+	authorized.PUT("/conversations/:id/draft", conversations.SaveDraft)
+	authorized.GET("/conversations/:id/settings", conversations.GetSettings)
+	authorized.PUT("/conversations/:id/settings", conversations.SaveSettings)
+	authorized.GET("/scheduled", scheduled.List)
+	authorized.POST("/scheduled", scheduled.Create)
+	authorized.GET("/scheduled/:id", scheduled.Get)
+	authorized.PUT("/scheduled/:id", scheduled.Update)
+	authorized.DELETE("/scheduled/:id", scheduled.Cancel)
+	authorized.GET("/blocks", blocks.ListBlocks)
+	authorized.PUT("/blocks/:username", blocks.Block)
+	authorized.DELETE("/blocks/:username", blocks.Unblock)
+
+	moderators := router.Group("/api/moderation", middlewares.CurrentUser, middlewares.RequireAuth, middlewares.RequireRole(constants.RoleModerator, constants.RoleAdmin))
+	moderators.GET("/reports", reports.ListReports)
+	moderators.GET("/reports/:id", reports.GetReport)
+	moderators.POST("/reports/:id/claim", reports.Claim)
+	moderators.POST("/reports/:id/resolve", reports.Resolve)
+
+	// denied attempts are audited too
+	admin := router.Group("/api/admin", middlewares.CurrentUser, middlewares.RequireAuth, middlewares.Audit(initializers.Store), middlewares.RequireRole(constants.RoleAdmin))
+	messageTarget := middlewares.AuditTarget("message", "id")
+	conversationTarget := middlewares.AuditTarget("conversation", "id")
+	admin.POST("/import", imports.Import)
+	admin.POST("/users/:username/erasure", erasure.EraseUser)
+	admin.GET("/users/:username/export", middlewares.AuditTarget("user", "username"), erasure.ExportUserData)
+	admin.GET("/erasures/:id", middlewares.AuditTarget("erasure", "id"), erasure.GetErasure)
+	admin.GET("/quarantine", moderation.ListQuarantined)
+	admin.POST("/quarantine/:id/release", messageTarget, moderation.Release)
+	admin.POST("/quarantine/:id/reject", messageTarget, moderation.Reject)
+	admin.GET("/messages/:id/moderation", messageTarget, moderation.GetDecisions)
+	admin.GET("/messages/:id/history", messageTarget, adminTools.GetMessageHistory)
+	admin.PUT("/messages/:id/status", messageTarget, adminTools.CorrectStatus)
+	admin.POST("/messages/:id/reemit", messageTarget, adminTools.Reemit)
+	admin.GET("/conversations", adminTools.FindConversations)
+	admin.GET("/conversations/duplicates", adminTools.ListDuplicates)
+	admin.GET("/conversations/:id", conversationTarget, adminTools.GetConversation)
+	admin.GET("/conversations/:id/messages", conversationTarget, adminTools.ListHistory)
+	admin.POST("/conversations/:id/merge", conversationTarget, adminTools.MergeConversations)
+	admin.GET("/audit", adminTools.ListAudit)
 
-	router.GET("/api/messages", c.GetMessages)
 	router.Run()
-}
\ No newline at end of file
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
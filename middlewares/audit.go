@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+// AuditRecorder saves the audit entries, the store implements it
+type AuditRecorder interface {
+	CreateAuditEntry(entry *models.AuditEntry) error
+}
+
+// Audit records every request of the group once it is handled, along with the target
+// and details the route and its handler set. It runs after RequireAuth
+func Audit(recorder AuditRecorder) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		actor, _ := GetCurrentUser(ctx)
+		entry := &models.AuditEntry{
+			Actor:     actor,
+			Action:    ctx.Request.Method + " " + ctx.FullPath(),
+			Target:    ctx.GetString("auditTarget"),
+			Status:    ctx.Writer.Status(),
+			CreatedAt: time.Now(),
+		}
+		if details, ok := ctx.Get("auditDetails"); ok {
+			entry.Details, _ = details.(map[string]interface{})
+		}
+		// the response is already written, a failure can only be logged
+		if err := recorder.CreateAuditEntry(entry); err != nil {
+			log.Printf("error recording audit entry %v %v by %v: %v\n", entry.Action, entry.Target, actor, err)
+		}
+	}
+}
+
+// AuditTarget names the route's target after one of its params, such as message:<id>
+func AuditTarget(kind, param string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		SetAuditTarget(ctx, kind+":"+ctx.Param(param))
+		ctx.Next()
+	}
+}
+
+// SetAuditTarget is for handlers whose target is only known once they ran
+func SetAuditTarget(ctx *gin.Context, target string) {
+	ctx.Set("auditTarget", target)
+}
+
+// AddAuditDetail adds to the details recorded with the request
+func AddAuditDetail(ctx *gin.Context, key string, value interface{}) {
+	details, _ := ctx.Get("auditDetails")
+	list, ok := details.(map[string]interface{})
+	if !ok {
+		list = map[string]interface{}{}
+		ctx.Set("auditDetails", list)
+	}
+	list[key] = value
+}
@@ -2,20 +2,20 @@ package middlewares
 
 import (
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/yonraz/gochat_messages/constants"
 )
 
 func RequireAuth(ctx *gin.Context) {
 	cookie, exists := ctx.Get("currentUserToken")
 	if !exists || cookie == nil {
-		fmt.Println("no user found")
-		ctx.JSON(http.StatusOK, gin.H{})
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		ctx.Abort()
 		return
 	}
@@ -27,22 +27,85 @@ func RequireAuth(ctx *gin.Context) {
 		ctx.Abort()
 		return
 	}
-	if err := validateToken(tokenstring); err != nil {
-		fmt.Println(err)
+	claims, err := validateToken(tokenstring)
+	if err != nil {
+		log.Printf("rejecting request to %v with an invalid token: %v\n", ctx.Request.URL.Path, err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		ctx.Abort()
 		return
 	}
 
+	username, ok := claims["username"].(string)
+	if !ok || username == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		ctx.Abort()
+		return
+	}
+	ctx.Set("currentUser", username)
+	ctx.Set("currentUserRoles", rolesFromClaims(claims))
 
 	ctx.Next()
 }
 
-func validateToken(tokenString string) (error) {
+// RequireRole only lets through users whose token carries one of the roles in its roles
+// claim, it runs after RequireAuth
+func RequireRole(roles ...constants.Role) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, role := range roles {
+			if HasRole(ctx, role) {
+				ctx.Next()
+				return
+			}
+		}
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		ctx.Abort()
+	}
+}
+
+// HasRole tells whether the current user's token carries the role
+func HasRole(ctx *gin.Context, role constants.Role) bool {
+	roles, exists := ctx.Get("currentUserRoles")
+	if !exists {
+		return false
+	}
+	list, _ := roles.([]constants.Role)
+	for _, r := range list {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func rolesFromClaims(claims jwt.MapClaims) []constants.Role {
+	raw, ok := claims["roles"].([]interface{})
+	if !ok {
+		return nil
+	}
+	roles := make([]constants.Role, 0, len(raw))
+	for _, value := range raw {
+		if role, ok := value.(string); ok {
+			roles = append(roles, constants.Role(role))
+		}
+	}
+	return roles
+}
+
+// GetCurrentUser returns the username stored by RequireAuth
+func GetCurrentUser(ctx *gin.Context) (string, bool) {
+	user, exists := ctx.Get("currentUser")
+	if !exists {
+		return "", false
+	}
+	username, ok := user.(string)
+	return username, ok && username != ""
+}
+
+func validateToken(tokenString string) (jwt.MapClaims, error) {
 	// Retrieve the secret key from environment variables or configuration
 	secretKey := os.Getenv("JWT_KEY")
 	if secretKey == "" {
-		return errors.New("missing secret key")
+		return nil, errors.New("missing secret key")
 	}
 
 	// Parse and validate the token
@@ -54,20 +117,22 @@ func validateToken(tokenString string) (error) {
 		return []byte(secretKey), nil
 	})
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			return errors.New("token is expired")
-		}
-	}
-
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check if the token is valid
 	if !token.Valid {
-		return errors.New("invalid token")
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	if exp, ok := claims["exp"].(float64); ok && float64(time.Now().Unix()) > exp {
+		return nil, errors.New("token is expired")
 	}
 
-	return nil
-}
\ No newline at end of file
+	return claims, nil
+}
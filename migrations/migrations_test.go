@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := load(files, "sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "migration versions must not have gaps")
+		assert.NotEmpty(t, migration.Up, "migration %d_%s has no up script", migration.Version, migration.Name)
+		assert.NotEmpty(t, migration.Down, "migration %d_%s has no down script", migration.Version, migration.Name)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := Create(dir, "add things")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0001_add_things.up.sql"), up)
+	assert.Equal(t, filepath.Join(dir, "0001_add_things.down.sql"), down)
+
+	up, _, err = Create(dir, "more_things")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0002_more_things.up.sql"), up)
+
+	_, err = os.Stat(up)
+	assert.NoError(t, err)
+
+	_, _, err = Create(dir, "bad-name!")
+	assert.Error(t, err)
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS receipts;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS message_revisions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,86 @@
+-- Adopts databases that were previously created by gorm's AutoMigrate, so every
+-- statement has to be safe to run against an existing schema.
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id           BIGSERIAL PRIMARY KEY,
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ,
+    deleted_at   TIMESTAMPTZ,
+    participants TEXT[]
+);
+CREATE INDEX IF NOT EXISTS idx_conversations_deleted_at ON conversations (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_conversations_participants ON conversations USING GIN (participants);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id              UUID PRIMARY KEY,
+    conversation_id BIGINT,
+    content         TEXT,
+    sender          TEXT,
+    receiver        TEXT,
+    status          TEXT,
+    type            TEXT,
+    read            BOOLEAN,
+    sent            BOOLEAN,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    version         BIGINT
+);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id UUID;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to_id ON messages (reply_to_id);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_created_at ON messages (conversation_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id UUID,
+    version    BIGINT,
+    content    TEXT,
+    edited_by  TEXT,
+    edited_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message_id ON message_revisions (message_id);
+
+CREATE TABLE IF NOT EXISTS reactions (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id UUID,
+    username   TEXT,
+    emoji      TEXT,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reaction_message_user_emoji ON reactions (message_id, username, emoji);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id          UUID PRIMARY KEY,
+    message_id  UUID,
+    uploader    TEXT,
+    filename    TEXT,
+    mime_type   TEXT,
+    size        BIGINT,
+    checksum    TEXT,
+    width       BIGINT,
+    height      BIGINT,
+    storage_key TEXT,
+    created_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments (message_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_uploader ON attachments (uploader);
+-- uploads that were never sent, used to clean up orphaned blobs
+CREATE INDEX IF NOT EXISTS idx_attachments_unlinked ON attachments (created_at) WHERE message_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS receipts (
+    id           BIGSERIAL PRIMARY KEY,
+    message_id   UUID,
+    username     TEXT,
+    delivered_at TIMESTAMPTZ,
+    read_at      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_receipt_message_user ON receipts (message_id, username);
+
+-- Read used to be the only trace of a receipt, keep it for the recipient
+INSERT INTO receipts (message_id, username, delivered_at, read_at)
+SELECT id, receiver, updated_at, updated_at
+FROM messages
+WHERE read = TRUE AND receiver IS NOT NULL AND receiver <> ''
+ON CONFLICT (message_id, username) DO NOTHING;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_conversations_canonical_key;
+ALTER TABLE conversations DROP COLUMN IF EXISTS canonical_key;
@@ -0,0 +1,20 @@
+-- The key must match models.CanonicalKey: distinct participants in byte order,
+-- joined with the unit separator and hashed with sha256.
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS canonical_key TEXT;
+
+UPDATE conversations
+SET canonical_key = encode(sha256(convert_to(array_to_string(
+    ARRAY(SELECT DISTINCT u COLLATE "C" FROM unnest(participants) AS u ORDER BY 1),
+    chr(31)
+), 'UTF8')), 'hex')
+WHERE canonical_key IS NULL;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM conversations GROUP BY canonical_key HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'duplicate conversations found, run the repair-conversations command before migrating';
+    END IF;
+END $$;
+
+ALTER TABLE conversations ALTER COLUMN canonical_key SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversations_canonical_key ON conversations (canonical_key);
@@ -0,0 +1,32 @@
+-- Archived partitions are not restored, only the rows still in the database are kept.
+ALTER TABLE messages RENAME TO messages_partitioned;
+DROP INDEX IF EXISTS idx_messages_id;
+DROP INDEX IF EXISTS idx_messages_conversation_created_at;
+DROP INDEX IF EXISTS idx_messages_reply_to_id;
+
+CREATE TABLE messages (
+    id              UUID PRIMARY KEY,
+    conversation_id BIGINT,
+    content         TEXT,
+    sender          TEXT,
+    receiver        TEXT,
+    status          TEXT,
+    type            TEXT,
+    read            BOOLEAN,
+    sent            BOOLEAN,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    version         BIGINT,
+    reply_to_id     UUID,
+    edited          BOOLEAN NOT NULL DEFAULT FALSE,
+    edited_at       TIMESTAMPTZ
+);
+CREATE INDEX idx_messages_conversation_id ON messages (conversation_id);
+CREATE INDEX idx_messages_reply_to_id ON messages (reply_to_id);
+CREATE INDEX idx_messages_conversation_created_at ON messages (conversation_id, created_at DESC);
+
+INSERT INTO messages SELECT id, conversation_id, content, sender, receiver, status, type, read, sent,
+                            created_at, updated_at, version, reply_to_id, edited, edited_at
+FROM messages_partitioned;
+
+DROP TABLE messages_partitioned;
@@ -0,0 +1,66 @@
+-- Messages become range partitioned by month on created_at. Postgres requires the
+-- partition key in every unique constraint, so the primary key becomes (id, created_at)
+-- and the foreign keys gorm used to create around messages have to go.
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS fk_messages_attachments;
+ALTER TABLE receipts DROP CONSTRAINT IF EXISTS fk_messages_seen_by;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS fk_conversations_messages;
+
+ALTER TABLE messages RENAME TO messages_unpartitioned;
+ALTER TABLE messages_unpartitioned RENAME CONSTRAINT messages_pkey TO messages_unpartitioned_pkey;
+DROP INDEX IF EXISTS idx_messages_conversation_id;
+DROP INDEX IF EXISTS idx_messages_reply_to_id;
+DROP INDEX IF EXISTS idx_messages_conversation_created_at;
+
+CREATE TABLE messages (
+    id              UUID NOT NULL,
+    conversation_id BIGINT,
+    content         TEXT,
+    sender          TEXT,
+    receiver        TEXT,
+    status          TEXT,
+    type            TEXT,
+    read            BOOLEAN,
+    sent            BOOLEAN,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ,
+    version         BIGINT,
+    reply_to_id     UUID,
+    edited          BOOLEAN NOT NULL DEFAULT FALSE,
+    edited_at       TIMESTAMPTZ,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+CREATE INDEX idx_messages_id ON messages (id);
+CREATE INDEX idx_messages_conversation_created_at ON messages (conversation_id, created_at DESC);
+CREATE INDEX idx_messages_reply_to_id ON messages (reply_to_id);
+
+-- catches rows outside of the managed months, the partition manager moves them into
+-- their monthly partitions on its next run
+CREATE TABLE messages_default PARTITION OF messages DEFAULT;
+
+-- one partition per UTC month, from the oldest message to three months ahead
+DO $$
+DECLARE
+    month      TIMESTAMP;
+    last_month TIMESTAMP := date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '3 months';
+BEGIN
+    SELECT date_trunc('month', COALESCE(MIN(created_at), now()) AT TIME ZONE 'UTC') INTO month
+    FROM messages_unpartitioned;
+
+    WHILE month <= last_month LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF messages FOR VALUES FROM (%L) TO (%L)',
+            'messages_p' || to_char(month, 'YYYY_MM'),
+            to_char(month, 'YYYY-MM-DD') || ' 00:00:00+00',
+            to_char(month + INTERVAL '1 month', 'YYYY-MM-DD') || ' 00:00:00+00'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO messages (id, conversation_id, content, sender, receiver, status, type, read, sent,
+                      created_at, updated_at, version, reply_to_id, edited, edited_at)
+SELECT id, conversation_id, content, sender, receiver, status, type, read, sent,
+       COALESCE(created_at, updated_at, now()), updated_at, version, reply_to_id, edited, edited_at
+FROM messages_unpartitioned;
+
+DROP TABLE messages_unpartitioned;
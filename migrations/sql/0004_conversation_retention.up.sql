@@ -0,0 +1,4 @@
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS retention TEXT NOT NULL DEFAULT 'off';
+
+-- the sweeper only looks at conversations with a policy
+CREATE INDEX IF NOT EXISTS idx_conversations_retention ON conversations (id) WHERE retention <> 'off';
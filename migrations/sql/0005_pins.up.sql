@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS pins (
+    id              BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL,
+    message_id      UUID NOT NULL,
+    pinned_by       TEXT,
+    pinned_at       TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pin_conversation_message ON pins (conversation_id, message_id);
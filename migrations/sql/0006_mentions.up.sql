@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS mentions (
+    id              BIGSERIAL PRIMARY KEY,
+    message_id      UUID NOT NULL,
+    conversation_id BIGINT NOT NULL,
+    username        TEXT NOT NULL,
+    sender          TEXT,
+    created_at      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mention_message_user ON mentions (message_id, username);
+CREATE INDEX IF NOT EXISTS idx_mentions_conversation_id ON mentions (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_mentions_username ON mentions (username, created_at DESC);
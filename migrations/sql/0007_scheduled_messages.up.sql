@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id           UUID PRIMARY KEY,
+    sender       TEXT NOT NULL,
+    receiver     TEXT NOT NULL,
+    content      TEXT,
+    reply_to_id  UUID,
+    attachments  TEXT,
+    send_at      TIMESTAMPTZ NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'pending',
+    error        TEXT,
+    attempts     BIGINT NOT NULL DEFAULT 0,
+    lease_until  TIMESTAMPTZ,
+    published_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_sender ON scheduled_messages (sender);
+-- only rows the scheduler still has work on: pending, or sent but not published yet
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (send_at)
+    WHERE status = 'pending' OR (status = 'sent' AND published_at IS NULL);
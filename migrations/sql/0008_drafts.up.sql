@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS drafts (
+    id              BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL,
+    username        TEXT NOT NULL,
+    content         TEXT,
+    reply_to_id     UUID,
+    version         BIGINT NOT NULL DEFAULT 0,
+    updated_at      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_draft_conversation_user ON drafts (conversation_id, username);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS participant_settings (
+    id              BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL,
+    username        TEXT NOT NULL,
+    muted_until     TIMESTAMPTZ,
+    archived        BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned_to_top   BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_settings_conversation_user ON participant_settings (conversation_id, username);
+-- notification publishing looks up the muted participants of a conversation
+CREATE INDEX IF NOT EXISTS idx_settings_muted ON participant_settings (conversation_id, muted_until) WHERE muted_until IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS conversation_labels (
+    conversation_id BIGINT NOT NULL,
+    username        TEXT NOT NULL,
+    label           TEXT NOT NULL,
+    PRIMARY KEY (conversation_id, username, label)
+);
+CREATE INDEX IF NOT EXISTS idx_conversation_labels_label ON conversation_labels (username, label);
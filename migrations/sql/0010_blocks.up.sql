@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS blocks (
+    id         BIGSERIAL PRIMARY KEY,
+    blocker    TEXT NOT NULL,
+    blocked    TEXT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_block_blocker_blocked ON blocks (blocker, blocked);
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked ON blocks (blocked);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_attachments_storage_key;
+DROP INDEX IF EXISTS idx_messages_forwarded_from_id;
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS frequently_forwarded,
+    DROP COLUMN IF EXISTS forward_count,
+    DROP COLUMN IF EXISTS forwarded_from,
+    DROP COLUMN IF EXISTS forwarded_from_id;
@@ -0,0 +1,9 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS forwarded_from_id UUID,
+    ADD COLUMN IF NOT EXISTS forwarded_from TEXT,
+    ADD COLUMN IF NOT EXISTS forward_count BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS frequently_forwarded BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_messages_forwarded_from_id ON messages (forwarded_from_id);
+
+-- forwarded attachments share the blob of the original
+CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments (storage_key);
@@ -0,0 +1,2 @@
+-- messages encrypted with these keys become unreadable, decrypt them before rolling back
+DROP TABLE IF EXISTS data_keys;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS data_keys (
+    id              BIGSERIAL PRIMARY KEY,
+    conversation_id BIGINT NOT NULL,
+    wrapped_key     BYTEA NOT NULL,
+    master_key_id   TEXT NOT NULL,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_keys_conversation_id ON data_keys (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_key_id ON data_keys (master_key_id);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS erasure_records;
+DROP INDEX IF EXISTS idx_receipts_username;
+DROP INDEX IF EXISTS idx_reactions_username;
+DROP INDEX IF EXISTS idx_messages_receiver;
+DROP INDEX IF EXISTS idx_messages_sender;
+ALTER TABLE messages DROP COLUMN IF EXISTS redacted;
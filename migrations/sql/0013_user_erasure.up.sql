@@ -0,0 +1,25 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS redacted BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- erasure and subject access exports look users up across the tables
+CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages (sender);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages (receiver);
+CREATE INDEX IF NOT EXISTS idx_reactions_username ON reactions (username);
+CREATE INDEX IF NOT EXISTS idx_receipts_username ON receipts (username);
+
+CREATE TABLE IF NOT EXISTS erasure_records (
+    id                     UUID PRIMARY KEY,
+    subject_hash           TEXT NOT NULL,
+    pseudonym              TEXT NOT NULL,
+    source                 TEXT NOT NULL,
+    requested_by           TEXT,
+    status                 TEXT NOT NULL,
+    messages_redacted      BIGINT NOT NULL DEFAULT 0,
+    messages_pseudonymized BIGINT NOT NULL DEFAULT 0,
+    conversations_updated  BIGINT NOT NULL DEFAULT 0,
+    attachments_deleted    BIGINT NOT NULL DEFAULT 0,
+    archived_messages      BIGINT NOT NULL DEFAULT 0,
+    error                  TEXT,
+    requested_at           TIMESTAMPTZ NOT NULL,
+    completed_at           TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_erasure_records_subject_hash ON erasure_records (subject_hash);
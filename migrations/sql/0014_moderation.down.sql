@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS moderation_decisions;
+DROP INDEX IF EXISTS idx_messages_quarantined;
+ALTER TABLE messages DROP COLUMN IF EXISTS quarantined;
@@ -0,0 +1,14 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE;
+-- the review queue only ever looks at the few quarantined messages
+CREATE INDEX IF NOT EXISTS idx_messages_quarantined ON messages (created_at) WHERE quarantined;
+
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id UUID NOT NULL,
+    sender     TEXT,
+    filter     TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    reason     TEXT,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_decisions_message_id ON moderation_decisions (message_id);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id              UUID PRIMARY KEY,
+    message_id      UUID NOT NULL,
+    conversation_id BIGINT NOT NULL,
+    reporter        TEXT NOT NULL,
+    sender          TEXT NOT NULL,
+    reason          TEXT NOT NULL,
+    snapshot        JSONB NOT NULL,
+    context         JSONB,
+    status          TEXT NOT NULL,
+    claimed_by      TEXT,
+    claimed_at      TIMESTAMPTZ,
+    resolved_by     TEXT,
+    resolved_at     TIMESTAMPTZ,
+    resolution      TEXT,
+    note            TEXT,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ
+);
+-- a user reports a message once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_report_message_reporter ON reports (message_id, reporter);
+CREATE INDEX IF NOT EXISTS idx_reports_conversation_id ON reports (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_reports_reporter ON reports (reporter);
+CREATE INDEX IF NOT EXISTS idx_reports_sender ON reports (sender);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS message_tombstones (
+    message_id      UUID PRIMARY KEY,
+    conversation_id BIGINT NOT NULL,
+    sender          TEXT NOT NULL,
+    receiver        TEXT NOT NULL,
+    created_at      TIMESTAMPTZ,
+    deleted_at      TIMESTAMPTZ NOT NULL,
+    deleted_by      TEXT,
+    reason          TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_message_tombstones_conversation_id ON message_tombstones (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_message_tombstones_sender ON message_tombstones (sender);
+CREATE INDEX IF NOT EXISTS idx_message_tombstones_receiver ON message_tombstones (receiver);
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id         BIGSERIAL PRIMARY KEY,
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    target     TEXT,
+    status     INTEGER NOT NULL,
+    details    JSONB,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_actor ON audit_entries (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_target ON audit_entries (target);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_created_at ON audit_entries (created_at);
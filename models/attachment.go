@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Attachment struct {
+	ID         string    `json:"id" gorm:"type:uuid;primary_key"`
+	MessageID  *string   `json:"messageId,omitempty" gorm:"type:uuid;index"`
+	Uploader   string    `json:"uploader" gorm:"index"`
+	Filename   string    `json:"filename"`
+	MimeType   string    `json:"mimeType"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	Width      *int      `json:"width,omitempty"`
+	Height     *int      `json:"height,omitempty"`
+	StorageKey string    `json:"-" gorm:"index"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditEntry records a request made through the admin API. Target names what the
+// request was about, such as "message:<id>" or "user:<username>"
+type AuditEntry struct {
+	ID        uint                   `json:"id" gorm:"primarykey"`
+	Actor     string                 `json:"actor" gorm:"index"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target,omitempty" gorm:"index"`
+	Status    int                    `json:"status"`
+	Details   map[string]interface{} `json:"details,omitempty" gorm:"serializer:json"`
+	CreatedAt time.Time              `json:"createdAt" gorm:"index"`
+}
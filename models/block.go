@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Block hides the messages Blocked sends after CreatedAt from Blocker
+type Block struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	Blocker   string    `json:"-" gorm:"uniqueIndex:idx_block_blocker_blocked"`
+	Blocked   string    `json:"username" gorm:"uniqueIndex:idx_block_blocker_blocked;index"`
+	CreatedAt time.Time `json:"createdAt"`
+}
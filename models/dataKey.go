@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// DataKey encrypts the messages of a conversation, it is stored wrapped by a master key.
+// Messages reference the key by ID so they stay readable after conversations are merged
+type DataKey struct {
+	ID             uint   `gorm:"primarykey"`
+	ConversationID uint   `gorm:"uniqueIndex"`
+	WrappedKey     []byte `gorm:"not null"`
+	MasterKeyID    string `gorm:"index"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
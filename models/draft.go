@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Draft is the unsent message of a user in a conversation, synced between devices.
+// Version goes up on every save so that stale devices can't overwrite newer drafts
+type Draft struct {
+	ID             uint      `json:"-" gorm:"primarykey"`
+	ConversationID uint      `json:"conversationId" gorm:"uniqueIndex:idx_draft_conversation_user"`
+	Username       string    `json:"username" gorm:"uniqueIndex:idx_draft_conversation_user"`
+	Content        string    `json:"content"`
+	ReplyToID      *string   `json:"replyToId,omitempty" gorm:"type:uuid"`
+	Version        uint      `json:"version"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// InboxEntry is a conversation as listed for one of its participants
+type InboxEntry struct {
+	Conversation *Conversation        `json:"conversation"`
+	LastMessage  *Message             `json:"lastMessage,omitempty"`
+	HasDraft     bool                 `json:"hasDraft"`
+	Settings     *ParticipantSettings `json:"settings,omitempty"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// ErasureRecord is the audit trail of a user's data erasure. The username itself is
+// not kept, SubjectHash lets auditors check that a given user was erased
+type ErasureRecord struct {
+	ID          string                  `json:"id" gorm:"type:uuid;primary_key"`
+	SubjectHash string                  `json:"subjectHash" gorm:"index"`
+	Pseudonym   string                  `json:"pseudonym"`
+	Source      string                  `json:"source"`
+	RequestedBy string                  `json:"requestedBy,omitempty"`
+	Status      constants.ErasureStatus `json:"status"`
+	// the counts add up over the attempts
+	MessagesRedacted      int64      `json:"messagesRedacted"`
+	MessagesPseudonymized int64      `json:"messagesPseudonymized"`
+	ConversationsUpdated  int64      `json:"conversationsUpdated"`
+	AttachmentsDeleted    int64      `json:"attachmentsDeleted"`
+	ArchivedMessages      int64      `json:"archivedMessages"`
+	Error                 string     `json:"error,omitempty"`
+	RequestedAt           time.Time  `json:"requestedAt"`
+	CompletedAt           *time.Time `json:"completedAt,omitempty"`
+}
+
+func SubjectHash(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(sum[:])
+}
+
+// UserDeletedEvent is published on the user events exchange when an account is deleted
+type UserDeletedEvent struct {
+	Username string `json:"username"`
+}
+
+// UserErasedEvent tells the other services which pseudonym replaced the user
+type UserErasedEvent struct {
+	ErasureID   string `json:"erasureId"`
+	SubjectHash string `json:"subjectHash"`
+	Pseudonym   string `json:"pseudonym"`
+}
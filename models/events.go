@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/yonraz/gochat_messages/spam"
+)
+
+// MessagesExpiredEvent tells the websocket service which messages to remove from clients
+type MessagesExpiredEvent struct {
+	ConversationID uint     `json:"conversationId"`
+	Participants   []string `json:"participants"`
+	MessageIDs     []string `json:"messageIds"`
+}
+
+type PinEvent struct {
+	ConversationID uint     `json:"conversationId"`
+	Participants   []string `json:"participants"`
+	MessageID      string   `json:"messageId"`
+	Username       string   `json:"username"`
+}
+
+// MentionEvent notifies the mentioned users of a new message
+type MentionEvent struct {
+	ConversationID uint     `json:"conversationId"`
+	MessageID      string   `json:"messageId"`
+	Sender         string   `json:"sender"`
+	Mentioned      []string `json:"mentioned"`
+}
+
+// MessageQuarantinedEvent tells the websocket service to hold a message back until it
+// is reviewed
+type MessageQuarantinedEvent struct {
+	MessageID      string `json:"messageId"`
+	ConversationID uint   `json:"conversationId"`
+	Sender         string `json:"sender"`
+	Receiver       string `json:"receiver"`
+}
+
+// MessageRejectedEvent tells the sender that their message was not delivered
+type MessageRejectedEvent struct {
+	MessageID string `json:"messageId"`
+	Sender    string `json:"sender"`
+	Receiver  string `json:"receiver"`
+	Reason    string `json:"reason"`
+}
+
+// UserFlaggedEvent reports a sender the spam detector caught, for the user service to act on
+type UserFlaggedEvent struct {
+	Username       string        `json:"username"`
+	Score          float64       `json:"score"`
+	Signals        []spam.Signal `json:"signals"`
+	MessageID      string        `json:"messageId"`
+	ConversationID uint          `json:"conversationId"`
+	FlaggedAt      time.Time     `json:"flaggedAt"`
+}
+
+// MessageDeletedEvent tells the websocket service to remove a message a moderator deleted
+type MessageDeletedEvent struct {
+	ConversationID uint     `json:"conversationId"`
+	Participants   []string `json:"participants"`
+	MessageID      string   `json:"messageId"`
+}
+
+// UserWarnedEvent asks the user service to warn a user about a reported message
+type UserWarnedEvent struct {
+	Username  string `json:"username"`
+	ReportID  string `json:"reportId"`
+	MessageID string `json:"messageId"`
+	Note      string `json:"note"`
+}
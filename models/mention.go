@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Mention is a participant named with @username in a message
+type Mention struct {
+	ID             uint      `json:"-" gorm:"primarykey"`
+	MessageID      string    `json:"messageId" gorm:"type:uuid;uniqueIndex:idx_mention_message_user"`
+	ConversationID uint      `json:"conversationId" gorm:"index"`
+	Username       string    `json:"username" gorm:"uniqueIndex:idx_mention_message_user;index"`
+	Sender         string    `json:"sender"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Message        *Message  `json:"message,omitempty" gorm:"-"`
+}
+
+// MentionCount is the number of mentions of a user that are still unread in a conversation
+type MentionCount struct {
+	ConversationID uint  `json:"conversationId"`
+	Count          int64 `json:"count"`
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -11,16 +15,46 @@ type Message struct {
     ID             string                `json:"id" gorm:"type:uuid;primary_key"`
     ConversationID uint                  `json:"conversationId" gorm:"index"`
     Content        string                `json:"content"`
-    Sender         string                `json:"sender"`
-    Receiver       string                `json:"receiver"`
+    Sender         string                `json:"sender" gorm:"index"`
+    Receiver       string                `json:"receiver" gorm:"index"`
     Status         constants.RoutingKey  `json:"status"`
     Type           constants.MessageType `json:"type"`
     Read           bool                  `json:"read"`
     Sent           bool                  `json:"sent"`
+    ReplyToID      *string               `json:"replyToId,omitempty" gorm:"type:uuid;index"`
+    ReplyTo        *MessagePreview       `json:"replyTo,omitempty" gorm:"-"`
+    Edited         bool                  `json:"edited"`
+    EditedAt       *time.Time            `json:"editedAt,omitempty"`
     CreatedAt      time.Time             `json:"createdAt" gorm:"column:created_at"`
     UpdatedAt      time.Time             `json:"updatedAt" gorm:"column:updated_at"`
     Version        uint                  `json:"version" gorm:"version"`
+    Reactions      []ReactionSummary     `json:"reactions,omitempty" gorm:"-"`
+    Attachments    []Attachment          `json:"attachments,omitempty" gorm:"foreignKey:MessageID"`
+    SeenBy         []Receipt             `json:"seenBy,omitempty" gorm:"foreignKey:MessageID"`
+    Mentions       []Mention             `json:"mentions,omitempty" gorm:"foreignKey:MessageID"`
+    // ForwardedFromID and ForwardedFrom point at the original message and its sender,
+    // forwarding a forward keeps pointing at the original
+    ForwardedFromID     *string          `json:"forwardedFromId,omitempty" gorm:"type:uuid;index"`
+    ForwardedFrom       string           `json:"forwardedFrom,omitempty"`
+    ForwardCount        int              `json:"forwardCount"`
+    FrequentlyForwarded bool             `json:"frequentlyForwarded"`
+    // Redacted messages lost their content when their sender's data was erased
+    Redacted            bool             `json:"redacted"`
+    // Quarantined messages are held for review, only their sender sees them
+    Quarantined         bool                 `json:"quarantined"`
+    ModerationDecisions []ModerationDecision `json:"-" gorm:"foreignKey:MessageID"`
 }
+// MessagePreview is the compact form of a message embedded in its replies
+type MessagePreview struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	Deleted   bool      `json:"deleted"`
+	// Unavailable parents exist but are hidden from the viewer, they carry no content
+	Unavailable bool `json:"unavailable"`
+}
+
 type WsMessage struct {
 	ID      	string 					`json:"id" gorm:"primary key"`
 	Content 	string					`json:"content"`	
@@ -30,6 +64,15 @@ type WsMessage struct {
 	Type 		constants.MessageType	`json:"type"`
 	Read 		bool					`json:"read"`
 	Sent 		bool					`json:"sent"`
+	ReplyToID 	*string					`json:"replyToId,omitempty"`
+	Attachments []string				`json:"attachments,omitempty"`
+	// UpdatedBy is the recipient a delivered/read update comes from, defaults to Receiver
+	UpdatedBy 	string					`json:"updatedBy,omitempty"`
+	// ForwardedFromID and ForwardedFrom are only set on events this service publishes
+	ForwardedFromID *string				`json:"forwardedFromId,omitempty"`
+	ForwardedFrom 	string					`json:"forwardedFrom,omitempty"`
+	// MutedBy lists the recipients that muted the conversation and should not get a push notification
+	MutedBy 	[]string				`json:"mutedBy,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -37,5 +80,24 @@ type WsMessage struct {
 type Conversation struct {
 	gorm.Model
 	Participants   pq.StringArray `json:"participants" gorm:"type:text[]"`
+	CanonicalKey   string         `json:"-" gorm:"uniqueIndex"`
+	Retention      constants.RetentionPolicy `json:"retention" gorm:"default:off"`
 	Messages       []Message    `json:"messages" gorm:"foreignKey:ConversationID"`
 }
+
+// CanonicalKey identifies a set of participants regardless of order and duplicates.
+// The 0002 migration computes the same value in SQL, keep them in sync
+func CanonicalKey(participants []string) string {
+	unique := make([]string, 0, len(participants))
+	seen := make(map[string]bool, len(participants))
+	for _, participant := range participants {
+		if !seen[participant] {
+			seen[participant] = true
+			unique = append(unique, participant)
+		}
+	}
+	sort.Strings(unique)
+
+	sum := sha256.Sum256([]byte(strings.Join(unique, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
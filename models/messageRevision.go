@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MessageRevision keeps the content a message had before an edit
+type MessageRevision struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	MessageID string    `json:"messageId" gorm:"type:uuid;index"`
+	Version   uint      `json:"version"`
+	Content   string    `json:"content"`
+	EditedBy  string    `json:"editedBy"`
+	EditedAt  time.Time `json:"editedAt"`
+}
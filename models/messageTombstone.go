@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// MessageTombstone is what is kept of a message a moderator or an admin deleted, the
+// content is gone with the message. Expired messages leave no tombstone behind
+type MessageTombstone struct {
+	MessageID      string    `json:"messageId" gorm:"type:uuid;primaryKey"`
+	ConversationID uint      `json:"conversationId" gorm:"index"`
+	Sender         string    `json:"sender" gorm:"index"`
+	Receiver       string    `json:"receiver" gorm:"index"`
+	CreatedAt      time.Time `json:"createdAt"`
+	DeletedAt      time.Time `json:"deletedAt"`
+	DeletedBy      string    `json:"deletedBy"`
+	Reason         string    `json:"reason"`
+}
+
+// MessageHistory is everything kept about a message, for support. Message is nil once
+// the message is deleted and Tombstone is nil until then
+type MessageHistory struct {
+	Message             *Message             `json:"message,omitempty"`
+	Tombstone           *MessageTombstone    `json:"tombstone,omitempty"`
+	Revisions           []MessageRevision    `json:"revisions"`
+	Receipts            []Receipt            `json:"receipts"`
+	ModerationDecisions []ModerationDecision `json:"moderationDecisions"`
+	Audit               []AuditEntry         `json:"audit"`
+}
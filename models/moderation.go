@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// ModerationDecision is what a moderation filter did with a message, allowed messages
+// leave no decisions behind. Decisions on rejected messages point at a message that
+// was never saved
+type ModerationDecision struct {
+	ID        uint                       `json:"id" gorm:"primarykey"`
+	MessageID string                     `json:"messageId" gorm:"type:uuid;index"`
+	Sender    string                     `json:"sender"`
+	Filter    string                     `json:"filter"`
+	Action    constants.ModerationAction `json:"action"`
+	Reason    string                     `json:"reason"`
+	CreatedAt time.Time                  `json:"createdAt"`
+}
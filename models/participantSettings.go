@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ParticipantSettings is how one participant sees a conversation
+type ParticipantSettings struct {
+	ID             uint       `json:"-" gorm:"primarykey"`
+	ConversationID uint       `json:"conversationId" gorm:"uniqueIndex:idx_settings_conversation_user"`
+	Username       string     `json:"username" gorm:"uniqueIndex:idx_settings_conversation_user"`
+	MutedUntil     *time.Time `json:"mutedUntil,omitempty"`
+	Archived       bool       `json:"archived"`
+	PinnedToTop    bool       `json:"pinnedToTop"`
+	Labels         []string   `json:"labels" gorm:"-"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+func (s *ParticipantSettings) MutedAt(now time.Time) bool {
+	return s.MutedUntil != nil && s.MutedUntil.After(now)
+}
+
+// ConversationLabel files a conversation under a label of the participant
+type ConversationLabel struct {
+	ConversationID uint   `gorm:"primaryKey"`
+	Username       string `gorm:"primaryKey"`
+	Label          string `gorm:"primaryKey;index"`
+}
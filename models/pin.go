@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type Pin struct {
+	ID             uint      `json:"-" gorm:"primarykey"`
+	ConversationID uint      `json:"conversationId" gorm:"uniqueIndex:idx_pin_conversation_message"`
+	MessageID      string    `json:"messageId" gorm:"type:uuid;uniqueIndex:idx_pin_conversation_message"`
+	PinnedBy       string    `json:"pinnedBy"`
+	PinnedAt       time.Time `json:"pinnedAt"`
+	Message        *Message  `json:"message,omitempty" gorm:"-"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+type Reaction struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	MessageID string    `json:"messageId" gorm:"type:uuid;uniqueIndex:idx_reaction_message_user_emoji"`
+	Username  string    `json:"username" gorm:"uniqueIndex:idx_reaction_message_user_emoji;index"`
+	Emoji     string    `json:"emoji" gorm:"uniqueIndex:idx_reaction_message_user_emoji"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReactionSummary is the aggregated view of a single emoji on a message
+type ReactionSummary struct {
+	MessageID   string `json:"-"`
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
+}
+
+type WsReaction struct {
+	MessageID string                   `json:"messageId"`
+	Username  string                   `json:"username"`
+	Emoji     string                   `json:"emoji"`
+	Action    constants.ReactionAction `json:"action"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Receipt tracks when a single recipient got and read a message
+type Receipt struct {
+	ID          uint       `json:"-" gorm:"primarykey"`
+	MessageID   string     `json:"messageId" gorm:"type:uuid;uniqueIndex:idx_receipt_message_user"`
+	Username    string     `json:"username" gorm:"uniqueIndex:idx_receipt_message_user;index"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+}
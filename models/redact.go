@@ -0,0 +1,34 @@
+package models
+
+import "fmt"
+
+// The String methods keep message bodies out of logs, anything formatted with %v
+// or %+v prints the metadata only
+
+func (m Message) String() string {
+	return fmt.Sprintf("{ID:%s ConversationID:%d Sender:%s Receiver:%s Status:%s Type:%s Version:%d Content:[redacted]}",
+		m.ID, m.ConversationID, m.Sender, m.Receiver, m.Status, m.Type, m.Version)
+}
+
+func (m WsMessage) String() string {
+	return fmt.Sprintf("{ID:%s Sender:%s Receiver:%s Status:%s Type:%s Content:[redacted]}",
+		m.ID, m.Sender, m.Receiver, m.Status, m.Type)
+}
+
+func (r MessageRevision) String() string {
+	return fmt.Sprintf("{MessageID:%s Version:%d EditedBy:%s Content:[redacted]}", r.MessageID, r.Version, r.EditedBy)
+}
+
+func (d Draft) String() string {
+	return fmt.Sprintf("{ConversationID:%d Username:%s Version:%d Content:[redacted]}", d.ConversationID, d.Username, d.Version)
+}
+
+func (s ScheduledMessage) String() string {
+	return fmt.Sprintf("{ID:%s Sender:%s Receiver:%s SendAt:%s Status:%s Content:[redacted]}",
+		s.ID, s.Sender, s.Receiver, s.SendAt, s.Status)
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("{ID:%s MessageID:%s Reporter:%s Sender:%s Status:%s Resolution:%s Snapshot:[redacted]}",
+		r.ID, r.MessageID, r.Reporter, r.Sender, r.Status, r.Resolution)
+}
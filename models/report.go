@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// Report is a user's complaint about a message. The message and the ones around it
+// are copied when it is filed, so the report still holds what was said once the
+// message is edited or deleted
+type Report struct {
+	ID             string `json:"id" gorm:"type:uuid;primaryKey"`
+	MessageID      string `json:"messageId" gorm:"type:uuid;uniqueIndex:idx_report_message_reporter"`
+	ConversationID uint   `json:"conversationId" gorm:"index"`
+	Reporter       string `json:"reporter" gorm:"uniqueIndex:idx_report_message_reporter;index"`
+	// Sender is the reported user
+	Sender   string         `json:"sender" gorm:"index"`
+	Reason   string         `json:"reason"`
+	Snapshot MessagePreview `json:"snapshot" gorm:"serializer:json"`
+	// Context holds the messages around the reported one oldest first, as the reporter saw them
+	Context    []MessagePreview       `json:"context" gorm:"serializer:json"`
+	Status     constants.ReportStatus `json:"status" gorm:"index"`
+	ClaimedBy  string                 `json:"claimedBy,omitempty"`
+	ClaimedAt  *time.Time             `json:"claimedAt,omitempty"`
+	ResolvedBy string                 `json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time             `json:"resolvedAt,omitempty"`
+	Resolution constants.ReportAction `json:"resolution,omitempty"`
+	// Note is the moderator's explanation, warnings pass it on to the sender
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
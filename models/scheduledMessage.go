@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// ScheduledMessage is sent by the scheduler at SendAt, the message it creates reuses its ID
+type ScheduledMessage struct {
+	ID          string                    `json:"id" gorm:"type:uuid;primaryKey"`
+	Sender      string                    `json:"sender" gorm:"index"`
+	Receiver    string                    `json:"receiver"`
+	Content     string                    `json:"content"`
+	ReplyToID   *string                   `json:"replyToId,omitempty" gorm:"type:uuid"`
+	Attachments []string                  `json:"attachments,omitempty" gorm:"serializer:json"`
+	SendAt      time.Time                 `json:"sendAt" gorm:"index"`
+	Status      constants.ScheduledStatus `json:"status" gorm:"default:pending"`
+	Error       string                    `json:"error,omitempty"`
+	Attempts    int                       `json:"-"`
+	// LeaseUntil keeps other replicas away while one of them is sending the message
+	LeaseUntil  *time.Time `json:"-"`
+	PublishedAt *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,182 @@
+package moderation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// WordFilter matches a word list and regular expressions, masking replaces every
+// match with asterisks
+type WordFilter struct {
+	patterns []*regexp.Regexp
+	action   constants.ModerationAction
+}
+
+func NewWordFilter(words, patterns []string, action constants.ModerationAction) (*WordFilter, error) {
+	f := &WordFilter{action: action}
+	if len(words) > 0 {
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			quoted[i] = regexp.QuoteMeta(word)
+		}
+		f.patterns = append(f.patterns, regexp.MustCompile(`(?i)\b(?:`+strings.Join(quoted, "|")+`)\b`))
+	}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, compiled)
+	}
+	return f, nil
+}
+
+func (f *WordFilter) Name() string {
+	return "words"
+}
+
+func (f *WordFilter) Check(content string) (Decision, string) {
+	matched := false
+	for _, pattern := range f.patterns {
+		if !pattern.MatchString(content) {
+			continue
+		}
+		matched = true
+		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	if !matched {
+		return Decision{Action: constants.ModerationAllow}, content
+	}
+	return Decision{Action: f.action, Reason: "content matches the word list"}, content
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// LinkFilter catches links to domains that are not allowed, masking replaces them
+type LinkFilter struct {
+	allowed []string
+	action  constants.ModerationAction
+}
+
+func NewLinkFilter(allowed []string, action constants.ModerationAction) *LinkFilter {
+	domains := make([]string, len(allowed))
+	for i, domain := range allowed {
+		domains[i] = strings.ToLower(strings.TrimPrefix(domain, "."))
+	}
+	return &LinkFilter{allowed: domains, action: action}
+}
+
+func (f *LinkFilter) Name() string {
+	return "links"
+}
+
+func (f *LinkFilter) Check(content string) (Decision, string) {
+	blocked := false
+	masked := linkPattern.ReplaceAllStringFunc(content, func(link string) string {
+		if f.isAllowed(link) {
+			return link
+		}
+		blocked = true
+		return "[link removed]"
+	})
+	if !blocked {
+		return Decision{Action: constants.ModerationAllow}, content
+	}
+	return Decision{Action: f.action, Reason: "content links to a domain that is not allowed"}, masked
+}
+
+func (f *LinkFilter) isAllowed(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range f.allowed {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxLengthFilter counts characters rather than bytes, masking truncates the content
+type MaxLengthFilter struct {
+	max    int
+	action constants.ModerationAction
+}
+
+func NewMaxLengthFilter(max int, action constants.ModerationAction) *MaxLengthFilter {
+	return &MaxLengthFilter{max: max, action: action}
+}
+
+func (f *MaxLengthFilter) Name() string {
+	return "maxLength"
+}
+
+func (f *MaxLengthFilter) Check(content string) (Decision, string) {
+	runes := []rune(content)
+	if len(runes) <= f.max {
+		return Decision{Action: constants.ModerationAllow}, content
+	}
+	return Decision{Action: f.action, Reason: fmt.Sprintf("content is longer than %d characters", f.max)}, string(runes[:f.max])
+}
+
+// CharacterClassFilter catches characters of unwanted classes, such as control or
+// zero width characters, masking drops them
+type CharacterClassFilter struct {
+	tables []*unicode.RangeTable
+	action constants.ModerationAction
+}
+
+func NewCharacterClassFilter(classes []string, action constants.ModerationAction) (*CharacterClassFilter, error) {
+	f := &CharacterClassFilter{action: action}
+	for _, class := range classes {
+		var table *unicode.RangeTable
+		switch class {
+		case "control":
+			table = unicode.Cc
+		case "format":
+			table = unicode.Cf
+		default:
+			var ok bool
+			if table, ok = unicode.Scripts[class]; !ok {
+				if table, ok = unicode.Categories[class]; !ok {
+					return nil, fmt.Errorf("unknown character class %q", class)
+				}
+			}
+		}
+		f.tables = append(f.tables, table)
+	}
+	return f, nil
+}
+
+func (f *CharacterClassFilter) Name() string {
+	return "characters"
+}
+
+func (f *CharacterClassFilter) Check(content string) (Decision, string) {
+	found := false
+	masked := strings.Map(func(r rune) rune {
+		// line breaks and tabs are control characters that messages legitimately use
+		if r == '\n' || r == '\t' || !unicode.IsOneOf(f.tables, r) {
+			return r
+		}
+		found = true
+		return -1
+	}, content)
+	if !found {
+		return Decision{Action: constants.ModerationAllow}, content
+	}
+	return Decision{Action: f.action, Reason: "content has characters that are not allowed"}, masked
+}
@@ -0,0 +1,142 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+var severity = map[constants.ModerationAction]int{
+	constants.ModerationAllow:      0,
+	constants.ModerationMask:       1,
+	constants.ModerationQuarantine: 2,
+	constants.ModerationReject:     3,
+}
+
+type Decision struct {
+	Filter string
+	Action constants.ModerationAction
+	Reason string
+}
+
+// Filter checks the content of a message, masking filters return the masked content
+// and the others return it as is
+type Filter interface {
+	Name() string
+	Check(content string) (Decision, string)
+}
+
+// Result is the outcome of a chain, Action is the most severe action taken and
+// Decisions only lists the filters that did not allow the message
+type Result struct {
+	Content   string
+	Action    constants.ModerationAction
+	Decisions []Decision
+}
+
+// Chain runs the filters in order, each one sees the content masked by the previous
+// ones. A rejection stops the chain
+type Chain []Filter
+
+func (c Chain) Run(content string) Result {
+	result := Result{Content: content, Action: constants.ModerationAllow}
+	for _, filter := range c {
+		decision, checked := filter.Check(result.Content)
+		if decision.Action == constants.ModerationAllow {
+			continue
+		}
+		decision.Filter = filter.Name()
+		result.Decisions = append(result.Decisions, decision)
+		if decision.Action == constants.ModerationMask {
+			result.Content = checked
+		}
+		if severity[decision.Action] > severity[result.Action] {
+			result.Action = decision.Action
+		}
+		if decision.Action == constants.ModerationReject {
+			break
+		}
+	}
+	return result
+}
+
+// Moderator holds one chain per conversation type, types without a chain are not moderated
+type Moderator struct {
+	chains map[constants.ConversationType]Chain
+}
+
+// Config maps the conversation types to their filters, in the order they run
+type Config map[constants.ConversationType][]FilterConfig
+
+type FilterConfig struct {
+	// Type is one of words, links, maxLength or characters
+	Type   string                     `json:"type"`
+	Action constants.ModerationAction `json:"action"`
+	// Words are matched as whole words ignoring case, Patterns are regular expressions
+	Words    []string `json:"words,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	// Allow lists the domains links may point at, subdomains included
+	Allow []string `json:"allow,omitempty"`
+	Max   int      `json:"max,omitempty"`
+	// Classes are control, format or the name of a unicode script or category
+	Classes []string `json:"classes,omitempty"`
+}
+
+func NewModerator(config Config) (*Moderator, error) {
+	m := &Moderator{chains: make(map[constants.ConversationType]Chain, len(config))}
+	for convType, filters := range config {
+		if convType != constants.DirectConversation && convType != constants.GroupConversation {
+			return nil, fmt.Errorf("unknown conversation type %v", convType)
+		}
+		for i, filterConfig := range filters {
+			filter, err := newFilter(filterConfig)
+			if err != nil {
+				return nil, fmt.Errorf("%v filter %d: %w", convType, i, err)
+			}
+			m.chains[convType] = append(m.chains[convType], filter)
+		}
+	}
+	return m, nil
+}
+
+func ParseConfig(data []byte) (*Moderator, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid moderation config: %w", err)
+	}
+	return NewModerator(config)
+}
+
+func LoadConfigFile(path string) (*Moderator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+func (m *Moderator) Check(convType constants.ConversationType, content string) Result {
+	return m.chains[convType].Run(content)
+}
+
+func newFilter(config FilterConfig) (Filter, error) {
+	if _, ok := severity[config.Action]; !ok {
+		return nil, fmt.Errorf("unknown action %q", config.Action)
+	}
+	switch config.Type {
+	case "words":
+		return NewWordFilter(config.Words, config.Patterns, config.Action)
+	case "links":
+		return NewLinkFilter(config.Allow, config.Action), nil
+	case "maxLength":
+		if config.Max <= 0 {
+			return nil, fmt.Errorf("max must be positive")
+		}
+		return NewMaxLengthFilter(config.Max, config.Action), nil
+	case "characters":
+		return NewCharacterClassFilter(config.Classes, config.Action)
+	}
+	return nil, fmt.Errorf("unknown filter type %q", config.Type)
+}
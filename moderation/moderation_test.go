@@ -0,0 +1,62 @@
+package moderation_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/moderation"
+)
+
+const config = `{
+	"direct": [
+		{"type": "words", "words": ["darn"], "action": "mask"},
+		{"type": "characters", "classes": ["format"], "action": "mask"}
+	],
+	"group": [
+		{"type": "words", "words": ["darn"], "action": "mask"},
+		{"type": "links", "allow": ["example.com"], "action": "quarantine"},
+		{"type": "maxLength", "max": 20, "action": "reject"}
+	]
+}`
+
+func TestChainAppliesTheMostSevereAction(t *testing.T) {
+	moderator, err := moderation.ParseConfig([]byte(config))
+	require.NoError(t, err)
+
+	result := moderator.Check(constants.DirectConversation, "Darn\u200b it")
+	assert.Equal(t, constants.ModerationMask, result.Action)
+	assert.Equal(t, "**** it", result.Content)
+	require.Len(t, result.Decisions, 2)
+	assert.Equal(t, "words", result.Decisions[0].Filter)
+
+	result = moderator.Check(constants.GroupConversation, "see docs.example.com")
+	assert.Equal(t, constants.ModerationAllow, result.Action)
+	assert.Empty(t, result.Decisions)
+
+	result = moderator.Check(constants.GroupConversation, "darn www.spam.test")
+	assert.Equal(t, constants.ModerationQuarantine, result.Action)
+	assert.Equal(t, "**** www.spam.test", result.Content)
+	require.Len(t, result.Decisions, 2)
+
+	result = moderator.Check(constants.GroupConversation, "a message that is far too long")
+	assert.Equal(t, constants.ModerationReject, result.Action)
+
+	// links are only moderated in groups
+	result = moderator.Check(constants.DirectConversation, "www.spam.test")
+	assert.Equal(t, constants.ModerationAllow, result.Action)
+}
+
+func TestInvalidConfigIsRefused(t *testing.T) {
+	for _, invalid := range []string{
+		`{"direct": [{"type": "words", "patterns": ["("], "action": "mask"}]}`,
+		`{"direct": [{"type": "maxLength", "action": "reject"}]}`,
+		`{"direct": [{"type": "characters", "classes": ["Klingon"], "action": "mask"}]}`,
+		`{"direct": [{"type": "words", "words": ["x"], "action": "ban"}]}`,
+		`{"channel": []}`,
+	} {
+		_, err := moderation.ParseConfig([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,1643 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStore holds the queries that are portable between dialects, the dialect
+// specific stores embed it and add the participant lookups
+type gormStore struct {
+	db *gorm.DB
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *gormStore) FindConversation(participants []string) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := s.db.Where("canonical_key = ?", models.CanonicalKey(participants)).
+		First(&conv).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &conv, nil
+}
+
+// insertConversation relies on the unique canonical key, created is false when
+// the conversation already existed
+func (s *gormStore) insertConversation(tx *gorm.DB, participants []string) (*models.Conversation, bool, error) {
+	conv := &models.Conversation{
+		Participants: pq.StringArray(participants),
+		CanonicalKey: models.CanonicalKey(participants),
+		Messages:     []models.Message{},
+	}
+	result := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "canonical_key"}},
+		DoNothing: true,
+	}).Create(conv)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return conv, true, nil
+	}
+
+	var existing models.Conversation
+	err := tx.Where("canonical_key = ?", conv.CanonicalKey).First(&existing).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+func (s *gormStore) GetConversationByID(id uint) (*models.Conversation, error) {
+	var conv models.Conversation
+	err := s.db.First(&conv, id).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &conv, nil
+}
+
+func (s *gormStore) FindDuplicateConversations() ([][]uint, error) {
+	byKey := map[string][]uint{}
+	var keys []string
+	var batch []models.Conversation
+	err := s.db.Select("id", "participants").
+		Order("id asc").
+		FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+			for _, conv := range batch {
+				key := models.CanonicalKey(conv.Participants)
+				if _, ok := byKey[key]; !ok {
+					keys = append(keys, key)
+				}
+				byKey[key] = append(byKey[key], conv.ID)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates [][]uint
+	for _, key := range keys {
+		if len(byKey[key]) > 1 {
+			duplicates = append(duplicates, byKey[key])
+		}
+	}
+	return duplicates, nil
+}
+
+func (s *gormStore) MergeConversations(keepID uint, duplicateIDs []uint) error {
+	if len(duplicateIDs) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return mergeConversations(tx, keepID, duplicateIDs)
+	})
+}
+
+func mergeConversations(tx *gorm.DB, keepID uint, duplicateIDs []uint) error {
+	for _, model := range []interface{}{&models.Message{}, &models.Pin{}, &models.Mention{}, &models.Report{}, &models.MessageTombstone{}} {
+		err := tx.Model(model).
+			Where("conversation_id IN ?", duplicateIDs).
+			Update("conversation_id", keepID).Error
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"drafts", "participant_settings", "conversation_labels"} {
+		if err := mergePerUser(tx, table, keepID, duplicateIDs); err != nil {
+			return err
+		}
+	}
+
+	return tx.Unscoped().Delete(&models.Conversation{}, duplicateIDs).Error
+}
+
+// mergePerUser moves rows unique per conversation and user, the kept conversation's
+// row wins when a user has one in both
+func mergePerUser(tx *gorm.DB, table string, keepID uint, duplicateIDs []uint) error {
+	for _, duplicateID := range duplicateIDs {
+		err := tx.Table(table).
+			Where("conversation_id = ? AND username IN (?)", duplicateID,
+				tx.Table(table).Select("username").Where("conversation_id = ?", keepID)).
+			Delete(nil).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table(table).
+			Where("conversation_id = ?", duplicateID).
+			Update("conversation_id", keepID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listConversations applies the per-participant filter and ordering, the caller scopes
+// the query to the conversations of the participant
+func (s *gormStore) listConversations(query *gorm.DB, username string, filter InboxFilter, offset, limit int) ([]models.Conversation, error) {
+	query = query.Select("conversations.*").
+		Joins("LEFT JOIN participant_settings ps ON ps.conversation_id = conversations.id AND ps.username = ?", username)
+	if filter.Archived != nil {
+		query = query.Where("COALESCE(ps.archived, ?) = ?", false, *filter.Archived)
+	}
+	if filter.Muted != nil {
+		if *filter.Muted {
+			query = query.Where("ps.muted_until > ?", filter.Now)
+		} else {
+			query = query.Where("ps.muted_until IS NULL OR ps.muted_until <= ?", filter.Now)
+		}
+	}
+	if filter.Label != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM conversation_labels l WHERE l.conversation_id = conversations.id AND l.username = ? AND l.label = ?)", username, filter.Label)
+	}
+
+	conversations := []models.Conversation{}
+	err := query.
+		Order("COALESCE(ps.pinned_to_top, false) DESC").
+		Order("(SELECT MAX(created_at) FROM messages WHERE messages.conversation_id = conversations.id) DESC").
+		Order("conversations.id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+func (s *gormStore) GetLastMessages(conversationIDs []uint) ([]models.Message, error) {
+	var messages []models.Message
+	if len(conversationIDs) == 0 {
+		return messages, nil
+	}
+	// quarantined messages stay out of the inbox previews until they are released
+	err := s.db.Where("conversation_id IN ? AND quarantined = ?", conversationIDs, false).
+		Where("created_at = (SELECT MAX(m.created_at) FROM messages m WHERE m.conversation_id = messages.conversation_id AND m.quarantined = ?)", false).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) SetConversationRetention(id uint, retention constants.RetentionPolicy, notice *models.Message) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Conversation{}).
+			Where("id = ?", id).
+			Update("retention", retention)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if notice == nil {
+			return nil
+		}
+		return createMessage(tx, notice, nil)
+	})
+}
+
+func (s *gormStore) ListConversationsWithRetention() ([]models.Conversation, error) {
+	conversations := []models.Conversation{}
+	err := s.db.Where("retention <> ?", constants.RetentionOff).
+		Order("id asc").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+func (s *gormStore) ListMessages(conversationID uint, offset, limit int) ([]models.Message, error) {
+	return s.listMessages(s.db.Where("conversation_id = ?", conversationID), offset, limit)
+}
+
+func (s *gormStore) ListMessagesVisibleTo(conversationID uint, viewer string, offset, limit int) ([]models.Message, error) {
+	return s.listMessages(s.visibleTo(conversationID, viewer), offset, limit)
+}
+
+func (s *gormStore) CountMessagesVisibleTo(conversationID uint, viewer string) (int64, error) {
+	var count int64
+	err := s.visibleTo(conversationID, viewer).Model(&models.Message{}).Count(&count).Error
+	return count, err
+}
+
+func (s *gormStore) visibleTo(conversationID uint, viewer string) *gorm.DB {
+	return s.db.Where("conversation_id = ?", conversationID).
+		Where("NOT EXISTS (SELECT 1 FROM blocks b WHERE b.blocker = ? AND b.blocked = messages.sender AND messages.created_at >= b.created_at)", viewer).
+		Where("quarantined = ? OR sender = ?", false, viewer)
+}
+
+func (s *gormStore) ListMessagesAfter(conversationID uint, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error) {
+	messages := []models.Message{}
+	err := s.db.Where("conversation_id = ?", conversationID).
+		Where("created_at > ? OR (created_at = ? AND id > ?)", afterCreatedAt, afterCreatedAt, afterID).
+		Order("created_at asc").
+		Order("id asc").
+		Limit(limit).
+		Preload("Attachments").
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) ListMessagesBefore(conversationID uint, beforeCreatedAt time.Time, beforeID string, limit int) ([]models.Message, error) {
+	messages := []models.Message{}
+	err := s.db.Where("conversation_id = ?", conversationID).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", beforeCreatedAt, beforeCreatedAt, beforeID).
+		Order("created_at desc").
+		Order("id desc").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) listMessages(query *gorm.DB, offset, limit int) ([]models.Message, error) {
+	messages := []models.Message{}
+	err := query.
+		Order("created_at desc").
+		Offset(offset).
+		Limit(limit).
+		Preload("Attachments").
+		Preload("SeenBy", "read_at IS NOT NULL").
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) GetMessage(id string) (*models.Message, error) {
+	var msg models.Message
+	err := s.db.First(&msg, "id = ?", id).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &msg, nil
+}
+
+func (s *gormStore) GetMessagesByIDs(ids []string) ([]models.Message, error) {
+	var messages []models.Message
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	err := s.db.Where("id IN ?", ids).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) CreateMessage(msg *models.Message, attachmentIDs []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return createMessage(tx, msg, attachmentIDs)
+	})
+}
+
+func createMessage(tx *gorm.DB, msg *models.Message, attachmentIDs []string) error {
+	if err := tx.Create(msg).Error; err != nil {
+		return err
+	}
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	result := tx.Model(&models.Attachment{}).
+		Where("id IN ? AND uploader = ? AND message_id IS NULL", attachmentIDs, msg.Sender).
+		Update("message_id", msg.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(attachmentIDs)) {
+		return fmt.Errorf("%d of %d attachments could not be linked to message %v", int64(len(attachmentIDs))-result.RowsAffected, len(attachmentIDs), msg.ID)
+	}
+
+	return nil
+}
+
+func (s *gormStore) ImportMessages(messages []models.Message) (int, error) {
+	return importMessages(s.db, messages)
+}
+
+func importMessages(db *gorm.DB, messages []models.Message) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	inserted := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// the partitioned primary key includes created_at, so existing IDs are
+		// looked up instead of relying on the conflict alone
+		var existing []string
+		if err := tx.Model(&models.Message{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		skip := make(map[string]bool, len(existing))
+		for _, id := range existing {
+			skip[id] = true
+		}
+
+		fresh := make([]models.Message, 0, len(messages))
+		for _, msg := range messages {
+			if !skip[msg.ID] {
+				skip[msg.ID] = true
+				fresh = append(fresh, msg)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Omit(clause.Associations).
+			Create(&fresh)
+		if result.Error != nil {
+			return result.Error
+		}
+		inserted = int(result.RowsAffected)
+		return recountForwards(tx, fresh)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return inserted, nil
+}
+
+// recountForwards sets the forward count of imported messages from the forwards
+// already saved, and counts imported forwards on originals saved earlier
+func recountForwards(tx *gorm.DB, imported []models.Message) error {
+	ids := make([]string, len(imported))
+	isImported := make(map[string]bool, len(imported))
+	for i, msg := range imported {
+		ids[i] = msg.ID
+		isImported[msg.ID] = true
+	}
+
+	forwards := make(map[string]int)
+	for _, msg := range imported {
+		if msg.ForwardedFromID != nil && !isImported[*msg.ForwardedFromID] {
+			forwards[*msg.ForwardedFromID]++
+		}
+	}
+	for original, count := range forwards {
+		err := tx.Model(&models.Message{}).
+			Where("id = ?", original).
+			UpdateColumn("forward_count", gorm.Expr("forward_count + ?", count)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.Message{}).
+		Where("id IN ?", ids).
+		UpdateColumn("forward_count", gorm.Expr("(SELECT COUNT(*) FROM messages AS forwards WHERE forwards.forwarded_from_id = messages.id)")).Error
+}
+
+func (s *gormStore) UpdateMessage(msg *models.Message, expectedVersion uint, revision *models.MessageRevision) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if revision != nil {
+			if err := tx.Create(revision).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&models.Message{}).
+			Where("id = ? AND version = ?", msg.ID, expectedVersion).
+			Updates(map[string]interface{}{
+				"content":     msg.Content,
+				"read":        msg.Read,
+				"status":      msg.Status,
+				"type":        msg.Type,
+				"edited":      msg.Edited,
+				"edited_at":   msg.EditedAt,
+				"quarantined": msg.Quarantined,
+				"version":     msg.Version,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+
+		if len(msg.ModerationDecisions) > 0 {
+			return tx.Create(&msg.ModerationDecisions).Error
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) ForwardMessage(msg *models.Message, attachments []models.Attachment) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := createMessage(tx, msg, nil); err != nil {
+			return err
+		}
+		for i := range attachments {
+			attachments[i].MessageID = &msg.ID
+			if err := tx.Create(&attachments[i]).Error; err != nil {
+				return err
+			}
+		}
+		if msg.ForwardedFromID == nil {
+			return nil
+		}
+
+		return tx.Model(&models.Message{}).
+			Where("id = ?", *msg.ForwardedFromID).
+			UpdateColumn("forward_count", gorm.Expr("forward_count + 1")).Error
+	})
+}
+
+func (s *gormStore) ListReplies(conversationID uint, messageID string, offset, limit int) ([]models.Message, error) {
+	replies := []models.Message{}
+	err := s.db.Where("conversation_id = ? AND reply_to_id = ?", conversationID, messageID).
+		Order("created_at asc").
+		Offset(offset).
+		Limit(limit).
+		Find(&replies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return replies, nil
+}
+
+func (s *gormStore) DeleteExpiredMessages(conversationID uint, before time.Time, limit int) ([]models.Message, error) {
+	var expired []models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("conversation_id = ? AND created_at < ?", conversationID, before).
+			Order("created_at asc").
+			Limit(limit).
+			Preload("Attachments").
+			Find(&expired).Error
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		ids := make([]string, len(expired))
+		for i, msg := range expired {
+			ids[i] = msg.ID
+		}
+		return deleteMessages(tx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// deleteMessages hard deletes the messages along with every row hanging off them
+func deleteMessages(tx *gorm.DB, ids []string) error {
+	related := []interface{}{&models.MessageRevision{}, &models.Reaction{}, &models.Receipt{}, &models.Attachment{}, &models.Pin{}, &models.Mention{}, &models.ModerationDecision{}}
+	for _, model := range related {
+		if err := tx.Where("message_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Where("id IN ?", ids).Delete(&models.Message{}).Error
+}
+
+func (s *gormStore) SaveModerationDecisions(decisions []models.ModerationDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	return s.db.Create(&decisions).Error
+}
+
+func (s *gormStore) ListModerationDecisions(messageID string) ([]models.ModerationDecision, error) {
+	decisions := []models.ModerationDecision{}
+	err := s.db.Where("message_id = ?", messageID).
+		Order("id asc").
+		Find(&decisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
+
+func (s *gormStore) ListQuarantinedMessages(offset, limit int) ([]models.Message, error) {
+	messages := []models.Message{}
+	err := s.db.Where("quarantined = ?", true).
+		Order("created_at asc").
+		Offset(offset).
+		Limit(limit).
+		Preload("Attachments").
+		Preload("ModerationDecisions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id asc")
+		}).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) ReleaseQuarantinedMessage(id string) error {
+	result := s.db.Model(&models.Message{}).
+		Where("id = ? AND quarantined = ?", id, true).
+		Update("quarantined", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) DeleteMessage(id, deletedBy, reason string) (*models.Message, error) {
+	var msg models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Attachments").First(&msg, "id = ?", id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := deleteMessages(tx, []string{id}); err != nil {
+			return err
+		}
+		return tx.Create(&models.MessageTombstone{
+			MessageID:      msg.ID,
+			ConversationID: msg.ConversationID,
+			Sender:         msg.Sender,
+			Receiver:       msg.Receiver,
+			CreatedAt:      msg.CreatedAt,
+			DeletedAt:      time.Now(),
+			DeletedBy:      deletedBy,
+			Reason:         reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (s *gormStore) GetMessageTombstone(messageID string) (*models.MessageTombstone, error) {
+	var tombstone models.MessageTombstone
+	if err := s.db.First(&tombstone, "message_id = ?", messageID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &tombstone, nil
+}
+
+func (s *gormStore) ListConversationHistory(conversationID uint, offset, limit int) ([]HistoryEntry, error) {
+	var page []struct {
+		ID      string
+		Deleted bool
+	}
+	err := s.db.Raw(`SELECT id, created_at, FALSE AS deleted FROM messages WHERE conversation_id = ?
+		UNION ALL
+		SELECT message_id, created_at, TRUE FROM message_tombstones WHERE conversation_id = ?
+		ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, conversationID, conversationID, limit, offset).
+		Scan(&page).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var messageIDs, deletedIDs []string
+	for _, row := range page {
+		if row.Deleted {
+			deletedIDs = append(deletedIDs, row.ID)
+		} else {
+			messageIDs = append(messageIDs, row.ID)
+		}
+	}
+	messages := map[string]*models.Message{}
+	if len(messageIDs) > 0 {
+		found, err := s.listMessages(s.db.Where("id IN ?", messageIDs), 0, len(messageIDs))
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			messages[found[i].ID] = &found[i]
+		}
+	}
+	tombstones := map[string]*models.MessageTombstone{}
+	if len(deletedIDs) > 0 {
+		var found []models.MessageTombstone
+		if err := s.db.Where("message_id IN ?", deletedIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for i := range found {
+			tombstones[found[i].MessageID] = &found[i]
+		}
+	}
+
+	history := make([]HistoryEntry, 0, len(page))
+	for _, row := range page {
+		entry := HistoryEntry{Message: messages[row.ID], Tombstone: tombstones[row.ID]}
+		// rows deleted between the two queries are left out
+		if entry.Message != nil || entry.Tombstone != nil {
+			history = append(history, entry)
+		}
+	}
+	return history, nil
+}
+
+func (s *gormStore) ListRevisions(messageID string) ([]models.MessageRevision, error) {
+	revisions := []models.MessageRevision{}
+	err := s.db.Where("message_id = ?", messageID).
+		Order("version asc").
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (s *gormStore) ListRevisionsForMessages(messageIDs []string) ([]models.MessageRevision, error) {
+	revisions := []models.MessageRevision{}
+	if len(messageIDs) == 0 {
+		return revisions, nil
+	}
+	err := s.db.Where("message_id IN ?", messageIDs).
+		Order("version asc").
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (s *gormStore) AddReaction(reaction *models.Reaction) error {
+	// reacting twice with the same emoji is a no-op
+	return s.db.
+		Where(models.Reaction{MessageID: reaction.MessageID, Username: reaction.Username, Emoji: reaction.Emoji}).
+		FirstOrCreate(reaction).Error
+}
+
+func (s *gormStore) RemoveReaction(messageID, username, emoji string) error {
+	return s.db.
+		Where("message_id = ? AND username = ? AND emoji = ?", messageID, username, emoji).
+		Delete(&models.Reaction{}).Error
+}
+
+func (s *gormStore) SummarizeReactions(messageIDs []string, username string) ([]models.ReactionSummary, error) {
+	summaries := []models.ReactionSummary{}
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+	err := s.db.Model(&models.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, MAX(CASE WHEN username = ? THEN 1 ELSE 0 END) AS reacted_by_me", username).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id, emoji").
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func (s *gormStore) RecordReceipt(messageID, username string, status constants.RoutingKey, at time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var receipt models.Receipt
+		err := tx.Where(models.Receipt{MessageID: messageID, Username: username}).
+			FirstOrCreate(&receipt).Error
+		if err != nil {
+			return err
+		}
+
+		updateFields := map[string]interface{}{}
+		if receipt.DeliveredAt == nil {
+			updateFields["delivered_at"] = at
+		}
+		if status == constants.MessageReadKey && receipt.ReadAt == nil {
+			updateFields["read_at"] = at
+		}
+		if len(updateFields) == 0 {
+			return nil
+		}
+
+		return tx.Model(&receipt).Updates(updateFields).Error
+	})
+}
+
+func (s *gormStore) ListReceipts(messageID string) ([]models.Receipt, error) {
+	receipts := []models.Receipt{}
+	err := s.db.Where("message_id = ?", messageID).
+		Order("username asc").
+		Find(&receipts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+// AddPin relies on sqlite serializing writers, postgres locks the conversation first
+func (s *gormStore) AddPin(pin *models.Pin, max int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return addPin(tx, pin, max)
+	})
+}
+
+// addPin counts the pins before inserting, concurrent callers must not see the same count
+func addPin(tx *gorm.DB, pin *models.Pin, max int) error {
+	var existing models.Pin
+	err := tx.Where("conversation_id = ? AND message_id = ?", pin.ConversationID, pin.MessageID).
+		First(&existing).Error
+	if err == nil {
+		*pin = existing
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var count int64
+	err = tx.Model(&models.Pin{}).Where("conversation_id = ?", pin.ConversationID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count >= int64(max) {
+		return ErrLimitReached
+	}
+
+	return tx.Create(pin).Error
+}
+
+func (s *gormStore) RemovePin(conversationID uint, messageID string) error {
+	result := s.db.Where("conversation_id = ? AND message_id = ?", conversationID, messageID).
+		Delete(&models.Pin{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) ListPins(conversationID uint) ([]models.Pin, error) {
+	pins := []models.Pin{}
+	err := s.db.Where("conversation_id = ?", conversationID).
+		Order("pinned_at desc").
+		Find(&pins).Error
+	if err != nil || len(pins) == 0 {
+		return pins, err
+	}
+
+	ids := make([]string, len(pins))
+	for i, pin := range pins {
+		ids[i] = pin.MessageID
+	}
+	byID, err := s.messagesByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pins {
+		pins[i].Message = byID[pins[i].MessageID]
+	}
+
+	return pins, nil
+}
+
+func (s *gormStore) ListMentions(username string, offset, limit int) ([]models.Mention, error) {
+	mentions := []models.Mention{}
+	err := s.db.Where("username = ?", username).
+		Order("created_at desc").
+		Offset(offset).
+		Limit(limit).
+		Find(&mentions).Error
+	if err != nil || len(mentions) == 0 {
+		return mentions, err
+	}
+
+	ids := make([]string, len(mentions))
+	for i, mention := range mentions {
+		ids[i] = mention.MessageID
+	}
+	byID, err := s.messagesByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mentions {
+		mentions[i].Message = byID[mentions[i].MessageID]
+	}
+
+	return mentions, nil
+}
+
+func (s *gormStore) CountUnreadMentions(username string) ([]models.MentionCount, error) {
+	counts := []models.MentionCount{}
+	err := s.db.Model(&models.Mention{}).
+		Select("conversation_id, COUNT(*) AS count").
+		Where("username = ?", username).
+		Where("NOT EXISTS (SELECT 1 FROM receipts WHERE receipts.message_id = mentions.message_id AND receipts.username = mentions.username AND receipts.read_at IS NOT NULL)").
+		Group("conversation_id").
+		Order("conversation_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func (s *gormStore) messagesByID(ids []string) (map[string]*models.Message, error) {
+	messages, err := s.GetMessagesByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Message, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+	}
+	return byID, nil
+}
+
+func (s *gormStore) GetDraft(conversationID uint, username string) (*models.Draft, error) {
+	var draft models.Draft
+	err := s.db.Where("conversation_id = ? AND username = ?", conversationID, username).
+		First(&draft).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &draft, nil
+}
+
+func (s *gormStore) ListDrafts(username string, conversationIDs []uint) ([]models.Draft, error) {
+	drafts := []models.Draft{}
+	if len(conversationIDs) == 0 {
+		return drafts, nil
+	}
+	err := s.db.Where("username = ? AND conversation_id IN ? AND content <> ''", username, conversationIDs).
+		Find(&drafts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return drafts, nil
+}
+
+func (s *gormStore) SaveDraft(draft *models.Draft, expectedVersion uint) error {
+	draft.Version = expectedVersion + 1
+	draft.UpdatedAt = time.Now()
+	if expectedVersion == 0 {
+		result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(draft)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+
+	result := s.db.Model(&models.Draft{}).
+		Where("conversation_id = ? AND username = ? AND version = ?", draft.ConversationID, draft.Username, expectedVersion).
+		Updates(map[string]interface{}{
+			"content":     draft.Content,
+			"reply_to_id": draft.ReplyToID,
+			"version":     draft.Version,
+			"updated_at":  draft.UpdatedAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (s *gormStore) ClearDraft(conversationID uint, username string, savedBefore time.Time) error {
+	return s.db.Model(&models.Draft{}).
+		Where("conversation_id = ? AND username = ? AND content <> '' AND updated_at <= ?", conversationID, username, savedBefore).
+		Updates(map[string]interface{}{
+			"content":     "",
+			"reply_to_id": nil,
+			"version":     gorm.Expr("version + 1"),
+			"updated_at":  time.Now(),
+		}).Error
+}
+
+func (s *gormStore) GetParticipantSettings(conversationID uint, username string) (*models.ParticipantSettings, error) {
+	settings, err := s.ListParticipantSettings(username, []uint{conversationID})
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return &models.ParticipantSettings{ConversationID: conversationID, Username: username, Labels: []string{}}, nil
+	}
+	return &settings[0], nil
+}
+
+func (s *gormStore) ListParticipantSettings(username string, conversationIDs []uint) ([]models.ParticipantSettings, error) {
+	settings := []models.ParticipantSettings{}
+	if len(conversationIDs) == 0 {
+		return settings, nil
+	}
+	err := s.db.Where("username = ? AND conversation_id IN ?", username, conversationIDs).
+		Find(&settings).Error
+	if err != nil || len(settings) == 0 {
+		return settings, err
+	}
+
+	var labels []models.ConversationLabel
+	err = s.db.Where("username = ? AND conversation_id IN ?", username, conversationIDs).
+		Order("label").
+		Find(&labels).Error
+	if err != nil {
+		return nil, err
+	}
+	byConversation := make(map[uint][]string)
+	for _, label := range labels {
+		byConversation[label.ConversationID] = append(byConversation[label.ConversationID], label.Label)
+	}
+	for i := range settings {
+		settings[i].Labels = byConversation[settings[i].ConversationID]
+		if settings[i].Labels == nil {
+			settings[i].Labels = []string{}
+		}
+	}
+
+	return settings, nil
+}
+
+func (s *gormStore) SaveParticipantSettings(settings *models.ParticipantSettings) error {
+	settings.UpdatedAt = time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "conversation_id"}, {Name: "username"}},
+			DoUpdates: clause.AssignmentColumns([]string{"muted_until", "archived", "pinned_to_top", "updated_at"}),
+		}).Create(settings).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("conversation_id = ? AND username = ?", settings.ConversationID, settings.Username).
+			Delete(&models.ConversationLabel{}).Error
+		if err != nil {
+			return err
+		}
+		for _, label := range dedupe(settings.Labels) {
+			err := tx.Create(&models.ConversationLabel{
+				ConversationID: settings.ConversationID,
+				Username:       settings.Username,
+				Label:          label,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) ListMutedParticipants(conversationID uint, at time.Time) ([]string, error) {
+	var muted []string
+	err := s.db.Model(&models.ParticipantSettings{}).
+		Where("conversation_id = ? AND muted_until > ?", conversationID, at).
+		Order("username").
+		Pluck("username", &muted).Error
+	return muted, err
+}
+
+func (s *gormStore) BlockUser(block *models.Block) error {
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(block).Error
+}
+
+func (s *gormStore) UnblockUser(blocker, blocked string) error {
+	result := s.db.Where("blocker = ? AND blocked = ?", blocker, blocked).Delete(&models.Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) ListBlocks(blocker string) ([]models.Block, error) {
+	blocks := []models.Block{}
+	err := s.db.Where("blocker = ?", blocker).Order("created_at desc").Find(&blocks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+func (s *gormStore) ListBlockers(blocked string, candidates []string) ([]string, error) {
+	var blockers []string
+	if len(candidates) == 0 {
+		return blockers, nil
+	}
+	err := s.db.Model(&models.Block{}).
+		Where("blocked = ? AND blocker IN ?", blocked, candidates).
+		Order("blocker").
+		Pluck("blocker", &blockers).Error
+	return blockers, err
+}
+
+func (s *gormStore) EnsureDataKey(key *models.DataKey) (*models.DataKey, error) {
+	result := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}},
+		DoNothing: true,
+	}).Create(key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return key, nil
+	}
+
+	var existing models.DataKey
+	err := s.db.Where("conversation_id = ?", key.ConversationID).First(&existing).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &existing, nil
+}
+
+func (s *gormStore) GetDataKey(id uint) (*models.DataKey, error) {
+	var key models.DataKey
+	err := s.db.First(&key, id).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &key, nil
+}
+
+func (s *gormStore) ListDataKeysToRewrap(masterKeyID string, afterID uint, limit int) ([]models.DataKey, error) {
+	keys := []models.DataKey{}
+	err := s.db.Where("master_key_id <> ? AND id > ?", masterKeyID, afterID).
+		Order("id asc").
+		Limit(limit).
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *gormStore) RewrapDataKey(key *models.DataKey, previousMasterKeyID string) error {
+	result := s.db.Model(&models.DataKey{}).
+		Where("id = ? AND master_key_id = ?", key.ID, previousMasterKeyID).
+		Updates(map[string]interface{}{
+			"wrapped_key":   key.WrappedKey,
+			"master_key_id": key.MasterKeyID,
+			"updated_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (s *gormStore) CreateScheduledMessage(scheduled *models.ScheduledMessage) error {
+	return s.db.Create(scheduled).Error
+}
+
+func (s *gormStore) GetScheduledMessage(id string) (*models.ScheduledMessage, error) {
+	var scheduled models.ScheduledMessage
+	err := s.db.First(&scheduled, "id = ?", id).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &scheduled, nil
+}
+
+func (s *gormStore) ListScheduledMessages(sender string, status constants.ScheduledStatus) ([]models.ScheduledMessage, error) {
+	scheduled := []models.ScheduledMessage{}
+	err := s.db.Where("sender = ? AND status = ?", sender, status).
+		Order("send_at asc").
+		Find(&scheduled).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+func (s *gormStore) UpdateScheduledMessage(scheduled *models.ScheduledMessage) error {
+	result := s.db.Model(scheduled).
+		Where("sender = ? AND status = ?", scheduled.Sender, constants.ScheduledPending).
+		Select("receiver", "content", "reply_to_id", "attachments", "send_at", "updated_at").
+		Updates(scheduled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) CancelScheduledMessage(id, sender string) error {
+	result := s.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND sender = ? AND status = ?", id, sender, constants.ScheduledPending).
+		Updates(map[string]interface{}{
+			"status":     constants.ScheduledCancelled,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) ClaimDueScheduledMessages(now, leaseUntil time.Time, limit int) ([]models.ScheduledMessage, error) {
+	var due []models.ScheduledMessage
+	err := s.db.Where("send_at <= ?", now).
+		Where("status = ? OR (status = ? AND published_at IS NULL)", constants.ScheduledPending, constants.ScheduledSent).
+		Where("lease_until IS NULL OR lease_until < ?", now).
+		Order("send_at asc").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// the lease is taken row by row with a conditional update so that replicas
+	// racing for the same rows each get a disjoint share of them
+	claimed := []models.ScheduledMessage{}
+	for _, scheduled := range due {
+		result := s.db.Model(&models.ScheduledMessage{}).
+			Where("id = ? AND (lease_until IS NULL OR lease_until < ?)", scheduled.ID, now).
+			Updates(map[string]interface{}{
+				"lease_until": leaseUntil,
+				"attempts":    gorm.Expr("attempts + 1"),
+			})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 1 {
+			scheduled.LeaseUntil = &leaseUntil
+			scheduled.Attempts++
+			claimed = append(claimed, scheduled)
+		}
+	}
+
+	return claimed, nil
+}
+
+func (s *gormStore) FireScheduledMessage(id string, msg *models.Message, attachmentIDs []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ScheduledMessage{}).
+			Where("id = ? AND status = ?", id, constants.ScheduledPending).
+			Updates(map[string]interface{}{
+				"status":     constants.ScheduledSent,
+				"updated_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return createMessage(tx, msg, attachmentIDs)
+	})
+}
+
+func (s *gormStore) MarkScheduledMessagePublished(id string, at time.Time) error {
+	return s.db.Model(&models.ScheduledMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"published_at": at,
+			"lease_until":  nil,
+		}).Error
+}
+
+func (s *gormStore) FailScheduledMessage(id, reason string) error {
+	return s.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", id, constants.ScheduledPending).
+		Updates(map[string]interface{}{
+			"status":      constants.ScheduledFailed,
+			"error":       reason,
+			"lease_until": nil,
+			"updated_at":  time.Now(),
+		}).Error
+}
+
+func (s *gormStore) CreateAttachment(attachment *models.Attachment) error {
+	return s.db.Create(attachment).Error
+}
+
+func (s *gormStore) GetAttachment(id string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.First(&attachment, "id = ?", id).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &attachment, nil
+}
+
+func (s *gormStore) ListAttachments(messageID string) ([]models.Attachment, error) {
+	attachments := []models.Attachment{}
+	err := s.db.Where("message_id = ?", messageID).Order("created_at asc").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (s *gormStore) CountAttachmentsByStorageKey(storageKey string) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Attachment{}).Where("storage_key = ?", storageKey).Count(&count).Error
+	return count, err
+}
+
+// eraseUser runs the erasure for the given conversations of the user, each store finds
+// them its own way
+func eraseUser(tx *gorm.DB, username, pseudonym string, conversations []models.Conversation) (*ErasureResult, error) {
+	result := &ErasureResult{}
+	sent := tx.Model(&models.Message{}).Select("id").Where("sender = ?", username)
+
+	// attachments of the redacted messages and uploads that were never sent
+	var attachments []models.Attachment
+	err := tx.Where("message_id IN (?) OR (uploader = ? AND message_id IS NULL)", sent, username).
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) > 0 {
+		ids := make([]string, len(attachments))
+		for i, attachment := range attachments {
+			ids[i] = attachment.ID
+			result.StorageKeys = append(result.StorageKeys, attachment.StorageKey)
+		}
+		deleted := tx.Where("id IN ?", ids).Delete(&models.Attachment{})
+		if deleted.Error != nil {
+			return nil, deleted.Error
+		}
+		result.AttachmentsDeleted = deleted.RowsAffected
+	}
+
+	// previous versions and mentions come from the content that is redacted
+	for _, model := range []interface{}{&models.MessageRevision{}, &models.Mention{}} {
+		if err := tx.Where("message_id IN (?)", sent).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	redacted := tx.Model(&models.Message{}).Where("sender = ?", username).
+		Updates(map[string]interface{}{"content": "", "redacted": true, "sender": pseudonym})
+	if redacted.Error != nil {
+		return nil, redacted.Error
+	}
+	result.MessagesRedacted = redacted.RowsAffected
+
+	if err := eraseFromReports(tx, username, pseudonym, conversations); err != nil {
+		return nil, err
+	}
+
+	received := tx.Model(&models.Message{}).Where("receiver = ?", username).Update("receiver", pseudonym)
+	if received.Error != nil {
+		return nil, received.Error
+	}
+	result.MessagesPseudonymized = received.RowsAffected
+
+	renames := []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.Message{}, "forwarded_from"},
+		{&models.Reaction{}, "username"},
+		{&models.Receipt{}, "username"},
+		{&models.Mention{}, "username"},
+		{&models.Pin{}, "pinned_by"},
+		{&models.ModerationDecision{}, "sender"},
+		{&models.Report{}, "reporter"},
+		{&models.Report{}, "claimed_by"},
+		{&models.Report{}, "resolved_by"},
+		{&models.MessageTombstone{}, "sender"},
+		{&models.MessageTombstone{}, "receiver"},
+		{&models.MessageTombstone{}, "deleted_by"},
+		{&models.AuditEntry{}, "actor"},
+	}
+	for _, rename := range renames {
+		err := tx.Model(rename.model).Where(rename.column+" = ?", username).Update(rename.column, pseudonym).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Model(&models.AuditEntry{}).Where("target = ?", "user:"+username).Update("target", "user:"+pseudonym).Error
+	if err != nil {
+		return nil, err
+	}
+
+	deletes := []struct {
+		model interface{}
+		where string
+		args  []interface{}
+	}{
+		{&models.Draft{}, "username = ?", []interface{}{username}},
+		{&models.ParticipantSettings{}, "username = ?", []interface{}{username}},
+		{&models.ConversationLabel{}, "username = ?", []interface{}{username}},
+		{&models.Block{}, "blocker = ? OR blocked = ?", []interface{}{username, username}},
+		{&models.ScheduledMessage{}, "sender = ?", []interface{}{username}},
+	}
+	for _, del := range deletes {
+		if err := tx.Where(del.where, del.args...).Delete(del.model).Error; err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Model(&models.ScheduledMessage{}).
+		Where("receiver = ?", username).
+		Updates(map[string]interface{}{"receiver": pseudonym, "status": constants.ScheduledCancelled}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, conv := range conversations {
+		var remaining []string
+		for _, participant := range conv.Participants {
+			if participant != username {
+				remaining = append(remaining, participant)
+			}
+		}
+		err := tx.Model(&models.Conversation{}).Where("id = ?", conv.ID).Updates(map[string]interface{}{
+			"participants":  pq.StringArray(remaining),
+			"canonical_key": fmt.Sprintf("erased:%d", conv.ID),
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		result.ConversationsUpdated++
+	}
+
+	return result, nil
+}
+
+// eraseFromReports redacts what the user said in the copies kept by reports, the user's
+// messages can only show up in reports of their conversations or about them
+func eraseFromReports(tx *gorm.DB, username, pseudonym string, conversations []models.Conversation) error {
+	conversationIDs := make([]uint, len(conversations))
+	for i, conv := range conversations {
+		conversationIDs[i] = conv.ID
+	}
+	var reports []models.Report
+	err := tx.Where("conversation_id IN ? OR sender = ?", conversationIDs, username).Find(&reports).Error
+	if err != nil {
+		return err
+	}
+
+	redact := func(preview *models.MessagePreview) bool {
+		if preview.Sender != username {
+			return false
+		}
+		preview.Sender = pseudonym
+		preview.Content = ""
+		return true
+	}
+	for i := range reports {
+		report := &reports[i]
+		changed := redact(&report.Snapshot)
+		for j := range report.Context {
+			changed = redact(&report.Context[j]) || changed
+		}
+		if report.Sender == username {
+			report.Sender = pseudonym
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		err := tx.Model(report).Select("sender", "snapshot", "context").Updates(report).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gormStore) SaveErasureRecord(record *models.ErasureRecord) error {
+	return s.db.Save(record).Error
+}
+
+func (s *gormStore) GetErasureRecord(id string) (*models.ErasureRecord, error) {
+	var record models.ErasureRecord
+	if err := s.db.First(&record, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+
+	return &record, nil
+}
+
+func (s *gormStore) FindUnfinishedErasure(subjectHash string) (*models.ErasureRecord, error) {
+	var record models.ErasureRecord
+	err := s.db.Where("subject_hash = ? AND status <> ?", subjectHash, constants.ErasureCompleted).
+		Order("requested_at desc").
+		First(&record).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &record, nil
+}
+
+func (s *gormStore) ListUserMessages(username string, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error) {
+	messages := []models.Message{}
+	err := s.db.Where("sender = ? OR receiver = ?", username, username).
+		Where("created_at > ? OR (created_at = ? AND id > ?)", afterCreatedAt, afterCreatedAt, afterID).
+		Order("created_at asc").
+		Order("id asc").
+		Limit(limit).
+		Preload("Attachments").
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *gormStore) ListUserRecords(username string) (*UserRecords, error) {
+	records := &UserRecords{}
+	queries := []struct {
+		dest  interface{}
+		where string
+	}{
+		{&records.Reactions, "username = ?"},
+		{&records.Receipts, "username = ?"},
+		{&records.Mentions, "username = ?"},
+		{&records.Pins, "pinned_by = ?"},
+		{&records.Attachments, "uploader = ?"},
+		{&records.Drafts, "username = ?"},
+		{&records.ScheduledMessages, "sender = ?"},
+		{&records.Settings, "username = ?"},
+		{&records.Blocks, "blocker = ?"},
+		{&records.Reports, "reporter = ?"},
+	}
+	for _, query := range queries {
+		if err := s.db.Where(query.where, username).Find(query.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var labels []models.ConversationLabel
+	if err := s.db.Where("username = ?", username).Order("label").Find(&labels).Error; err != nil {
+		return nil, err
+	}
+	byConversation := make(map[uint][]string)
+	for _, label := range labels {
+		byConversation[label.ConversationID] = append(byConversation[label.ConversationID], label.Label)
+	}
+	for i := range records.Settings {
+		records.Settings[i].Labels = byConversation[records.Settings[i].ConversationID]
+	}
+
+	return records, nil
+}
+
+func (s *gormStore) CreateAuditEntry(entry *models.AuditEntry) error {
+	return s.db.Create(entry).Error
+}
+
+func (s *gormStore) ListAuditEntries(filter AuditFilter, offset, limit int) ([]models.AuditEntry, error) {
+	query := s.db
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	entries := []models.AuditEntry{}
+	err := query.Order("created_at desc").
+		Order("id desc").
+		Offset(offset).
+		Limit(limit).
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *gormStore) CreateReport(report *models.Report) error {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (s *gormStore) GetReport(id string) (*models.Report, error) {
+	var report models.Report
+	if err := s.db.First(&report, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+
+	return &report, nil
+}
+
+func (s *gormStore) ListReports(status constants.ReportStatus, offset, limit int) ([]models.Report, error) {
+	query := s.db
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	reports := []models.Report{}
+	err := query.
+		Order("created_at asc").
+		Offset(offset).
+		Limit(limit).
+		Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (s *gormStore) ClaimReport(id, moderator string, at time.Time) error {
+	result := s.db.Model(&models.Report{}).
+		Where("id = ? AND (status = ? OR (status = ? AND claimed_by = ?))", id, constants.ReportOpen, constants.ReportClaimed, moderator).
+		Updates(map[string]interface{}{
+			"status":     constants.ReportClaimed,
+			"claimed_by": moderator,
+			"claimed_at": at,
+			"updated_at": at,
+		})
+	return s.reportTransition(id, result)
+}
+
+func (s *gormStore) ResolveReport(report *models.Report) error {
+	result := s.db.Model(&models.Report{}).
+		Where("id = ? AND status = ? AND claimed_by = ?", report.ID, constants.ReportClaimed, report.ResolvedBy).
+		Updates(map[string]interface{}{
+			"status":      constants.ReportResolved,
+			"resolved_by": report.ResolvedBy,
+			"resolved_at": report.ResolvedAt,
+			"resolution":  report.Resolution,
+			"note":        report.Note,
+			"updated_at":  report.ResolvedAt,
+		})
+	return s.reportTransition(report.ID, result)
+}
+
+// reportTransition tells a missing report from one that is in another state
+func (s *gormStore) reportTransition(id string, result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	if _, err := s.GetReport(id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yonraz/gochat_messages/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps participants in a text[] column and matches them with array operators
+type PostgresStore struct {
+	gormStore
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{
+		gormStore{db: db},
+	}
+}
+
+func (s *PostgresStore) CreateConversation(participants []string) (*models.Conversation, error) {
+	conv, _, err := s.insertConversation(s.db, participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return conv, nil
+}
+
+// ListConversations can use the GIN index on participants
+func (s *PostgresStore) ListConversations(username string, filter InboxFilter, offset, limit int) ([]models.Conversation, error) {
+	return s.listConversations(s.db.Where("conversations.participants @> ARRAY[?]::text[]", username), username, filter, offset, limit)
+}
+
+func (s *PostgresStore) EraseUser(username, pseudonym string) (*ErasureResult, error) {
+	var result *ErasureResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var conversations []models.Conversation
+		err := tx.Where("participants @> ARRAY[?]::text[]", username).Find(&conversations).Error
+		if err != nil {
+			return err
+		}
+		result, err = eraseUser(tx, username, pseudonym, conversations)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// AddPin locks the conversation row so that concurrent pins are counted one at a time
+func (s *PostgresStore) AddPin(pin *models.Pin, max int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var conv models.Conversation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			First(&conv, pin.ConversationID).Error
+		if err != nil {
+			return notFound(err)
+		}
+		return addPin(tx, pin, max)
+	})
+}
+
+// ImportMessages creates the partitions of the imported months first, history
+// older than the managed months would otherwise land in messages_default
+func (s *PostgresStore) ImportMessages(messages []models.Message) (int, error) {
+	months := make(map[time.Time]bool)
+	for _, msg := range messages {
+		month := StartOfMonth(msg.CreatedAt)
+		if months[month] {
+			continue
+		}
+		months[month] = true
+		if err := CreateMonthPartition(s.db, month); err != nil {
+			return 0, err
+		}
+	}
+	return importMessages(s.db, messages)
+}
+
+// CreateMonthPartition creates the partition of messages for the month starting at
+// from. It fails while messages_default holds rows of that month
+func CreateMonthPartition(db *gorm.DB, from time.Time) error {
+	to := from.AddDate(0, 1, 0)
+	err := db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF messages FOR VALUES FROM ('%s') TO ('%s')",
+		PartitionName(from), from.Format(time.RFC3339), to.Format(time.RFC3339),
+	)).Error
+	if err != nil {
+		return fmt.Errorf("failed to create partition %v: %w", PartitionName(from), err)
+	}
+	return nil
+}
+
+func PartitionName(month time.Time) string {
+	return "messages_p" + month.Format("2006_01")
+}
+
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"github.com/yonraz/gochat_messages/models"
+	"gorm.io/gorm"
+)
+
+// ConversationParticipant normalizes participants for dialects without array operators
+type ConversationParticipant struct {
+	ConversationID uint   `gorm:"primaryKey"`
+	Username       string `gorm:"primaryKey;index"`
+}
+
+// SQLiteStore runs the service in-process, mostly for tests. Participants are
+// still saved on the conversation row but also normalized into a join table
+type SQLiteStore struct {
+	gormStore
+}
+
+// NewSQLiteStore creates the schema with AutoMigrate, postgres uses the migrations package instead
+func NewSQLiteStore(db *gorm.DB) (*SQLiteStore, error) {
+	err := db.AutoMigrate(
+		&models.Conversation{},
+		&ConversationParticipant{},
+		&models.Message{},
+		&models.MessageRevision{},
+		&models.Reaction{},
+		&models.Attachment{},
+		&models.Receipt{},
+		&models.Pin{},
+		&models.Mention{},
+		&models.ScheduledMessage{},
+		&models.Draft{},
+		&models.ParticipantSettings{},
+		&models.ConversationLabel{},
+		&models.Block{},
+		&models.DataKey{},
+		&models.ErasureRecord{},
+		&models.ModerationDecision{},
+		&models.Report{},
+		&models.MessageTombstone{},
+		&models.AuditEntry{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteStore{
+		gormStore{db: db},
+	}, nil
+}
+
+func (s *SQLiteStore) CreateConversation(participants []string) (*models.Conversation, error) {
+	var conv *models.Conversation
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var created bool
+		var err error
+		conv, created, err = s.insertConversation(tx, participants)
+		if err != nil || !created {
+			return err
+		}
+		for _, username := range dedupe(participants) {
+			err := tx.Create(&ConversationParticipant{ConversationID: conv.ID, Username: username}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conv, nil
+}
+
+func (s *SQLiteStore) MergeConversations(keepID uint, duplicateIDs []uint) error {
+	if len(duplicateIDs) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := mergeConversations(tx, keepID, duplicateIDs); err != nil {
+			return err
+		}
+		return tx.Where("conversation_id IN ?", duplicateIDs).Delete(&ConversationParticipant{}).Error
+	})
+}
+
+func (s *SQLiteStore) ListConversations(username string, filter InboxFilter, offset, limit int) ([]models.Conversation, error) {
+	participating := s.db.Model(&ConversationParticipant{}).Select("conversation_id").Where("username = ?", username)
+	return s.listConversations(s.db.Where("conversations.id IN (?)", participating), username, filter, offset, limit)
+}
+
+func (s *SQLiteStore) EraseUser(username, pseudonym string) (*ErasureResult, error) {
+	var result *ErasureResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		participating := tx.Model(&ConversationParticipant{}).Select("conversation_id").Where("username = ?", username)
+		var conversations []models.Conversation
+		if err := tx.Where("id IN (?)", participating).Find(&conversations).Error; err != nil {
+			return err
+		}
+		var err error
+		result, err = eraseUser(tx, username, pseudonym, conversations)
+		if err != nil {
+			return err
+		}
+		return tx.Where("username = ?", username).Delete(&ConversationParticipant{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
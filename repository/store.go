@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+// InboxFilter narrows the inbox listing, nil fields match everything
+type InboxFilter struct {
+	Archived *bool
+	Muted    *bool
+	Label    string
+	// Now is when mutes are evaluated
+	Now time.Time
+}
+
+// ErasureResult counts what erasing a user changed, StorageKeys are the blobs of the
+// deleted attachments
+type ErasureResult struct {
+	MessagesRedacted      int64
+	MessagesPseudonymized int64
+	ConversationsUpdated  int64
+	AttachmentsDeleted    int64
+	StorageKeys           []string
+}
+
+// UserRecords is what is held about a user besides messages and conversations
+type UserRecords struct {
+	Reactions         []models.Reaction            `json:"reactions"`
+	Receipts          []models.Receipt             `json:"receipts"`
+	Mentions          []models.Mention             `json:"mentions"`
+	Pins              []models.Pin                 `json:"pins"`
+	Attachments       []models.Attachment          `json:"attachments"`
+	Drafts            []models.Draft               `json:"drafts"`
+	ScheduledMessages []models.ScheduledMessage    `json:"scheduledMessages"`
+	Settings          []models.ParticipantSettings `json:"settings"`
+	Blocks            []models.Block               `json:"blocks"`
+	Reports           []models.Report              `json:"reports"`
+}
+
+// HistoryEntry is a message of a conversation or the tombstone of one that was deleted
+type HistoryEntry struct {
+	Message   *models.Message          `json:"message,omitempty"`
+	Tombstone *models.MessageTombstone `json:"tombstone,omitempty"`
+}
+
+// AuditFilter narrows the audit log, empty fields match everything
+type AuditFilter struct {
+	Actor  string
+	Target string
+}
+
+var (
+	ErrNotFound        = errors.New("record not found")
+	ErrVersionConflict = errors.New("version conflict")
+	ErrLimitReached    = errors.New("limit reached")
+	ErrDuplicate       = errors.New("duplicate record")
+)
+
+// Store is the persistence layer under the services. Every implementation has
+// to pass the conformance suite in store_test.go
+type Store interface {
+	// FindConversation returns the conversation with exactly these participants, in any order
+	FindConversation(participants []string) (*models.Conversation, error)
+	// CreateConversation inserts the conversation, or returns the existing one when a
+	// concurrent writer created it first
+	CreateConversation(participants []string) (*models.Conversation, error)
+	GetConversationByID(id uint) (*models.Conversation, error)
+	// FindDuplicateConversations groups the ids of conversations sharing the same participants
+	FindDuplicateConversations() ([][]uint, error)
+	// MergeConversations moves everything from the duplicates into keepID and deletes them
+	MergeConversations(keepID uint, duplicateIDs []uint) error
+	// SetConversationRetention saves the policy and the system message announcing it in
+	// one transaction, a nil notice saves the policy only
+	SetConversationRetention(id uint, retention constants.RetentionPolicy, notice *models.Message) error
+	ListConversationsWithRetention() ([]models.Conversation, error)
+
+	// ListMessages returns a page of the conversation, newest first, with attachments and read receipts
+	ListMessages(conversationID uint, offset, limit int) ([]models.Message, error)
+	// ListMessagesVisibleTo pages like ListMessages but leaves out what the viewer's
+	// blocked users sent after they were blocked, and quarantined messages of others
+	ListMessagesVisibleTo(conversationID uint, viewer string, offset, limit int) ([]models.Message, error)
+	// ListMessagesAfter pages oldest first through the messages after the given one,
+	// keyset paging keeps long histories cheap to walk
+	ListMessagesAfter(conversationID uint, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error)
+	// ListMessagesBefore pages newest first through the messages before the given one
+	ListMessagesBefore(conversationID uint, beforeCreatedAt time.Time, beforeID string, limit int) ([]models.Message, error)
+	// CountMessagesVisibleTo counts what ListMessagesVisibleTo pages through
+	CountMessagesVisibleTo(conversationID uint, viewer string) (int64, error)
+	GetMessage(id string) (*models.Message, error)
+	GetMessagesByIDs(ids []string) ([]models.Message, error)
+	// CreateMessage saves the message and claims the sender's unlinked attachments in one transaction
+	CreateMessage(msg *models.Message, attachmentIDs []string) error
+	// UpdateMessage saves the mutable fields of msg if the stored version still equals
+	// expectedVersion, and stores the revision and the new moderation decisions alongside.
+	// created_at is the partition key of messages and is never rewritten
+	UpdateMessage(msg *models.Message, expectedVersion uint, revision *models.MessageRevision) error
+	// ForwardMessage saves the forward with copies of the attachments and bumps the
+	// forward count of the original message
+	ForwardMessage(msg *models.Message, attachments []models.Attachment) error
+	// ImportMessages inserts the messages as they are and skips the IDs that already
+	// exist, it returns how many were inserted. Forward counts are recounted from the
+	// forwards that are stored
+	ImportMessages(messages []models.Message) (int, error)
+	// ListReplies only lists the replies from the parent's own conversation
+	ListReplies(conversationID uint, messageID string, offset, limit int) ([]models.Message, error)
+	// DeleteExpiredMessages hard deletes up to limit messages created before the cutoff along
+	// with their revisions, reactions, receipts and attachment rows, and returns them
+	DeleteExpiredMessages(conversationID uint, before time.Time, limit int) ([]models.Message, error)
+	// DeleteMessage hard deletes the message like DeleteExpiredMessages, leaves a
+	// tombstone in its place and returns it with its attachments
+	DeleteMessage(id, deletedBy, reason string) (*models.Message, error)
+	GetMessageTombstone(messageID string) (*models.MessageTombstone, error)
+	// ListConversationHistory pages newest first through the messages of the conversation
+	// and the tombstones of its deleted messages, quarantined messages included
+	ListConversationHistory(conversationID uint, offset, limit int) ([]HistoryEntry, error)
+
+	ListRevisions(messageID string) ([]models.MessageRevision, error)
+	ListRevisionsForMessages(messageIDs []string) ([]models.MessageRevision, error)
+
+	AddReaction(reaction *models.Reaction) error
+	RemoveReaction(messageID, username, emoji string) error
+	SummarizeReactions(messageIDs []string, username string) ([]models.ReactionSummary, error)
+
+	// RecordReceipt sets the delivered (and for reads, read) time unless it was already set
+	RecordReceipt(messageID, username string, status constants.RoutingKey, at time.Time) error
+	ListReceipts(messageID string) ([]models.Receipt, error)
+
+	// AddPin fails with ErrLimitReached when the conversation already has max pins,
+	// pinning a message twice is a no-op
+	AddPin(pin *models.Pin, max int) error
+	RemovePin(conversationID uint, messageID string) error
+	// ListPins returns the pins newest first with their messages
+	ListPins(conversationID uint) ([]models.Pin, error)
+
+	// ListMentions returns the mentions of the user newest first with their messages
+	ListMentions(username string, offset, limit int) ([]models.Mention, error)
+	// CountUnreadMentions counts per conversation the mentions the user has no read receipt for
+	CountUnreadMentions(username string) ([]models.MentionCount, error)
+
+	// ListConversations returns the conversations of the participant matching the filter,
+	// pinned ones first and then most recently active first
+	ListConversations(username string, filter InboxFilter, offset, limit int) ([]models.Conversation, error)
+	// GetLastMessages returns the newest message of each conversation that is not quarantined
+	GetLastMessages(conversationIDs []uint) ([]models.Message, error)
+
+	GetDraft(conversationID uint, username string) (*models.Draft, error)
+	// ListDrafts returns the non-empty drafts of the user in the given conversations
+	ListDrafts(username string, conversationIDs []uint) ([]models.Draft, error)
+	// SaveDraft fails with ErrVersionConflict unless expectedVersion is the stored
+	// version, 0 when there is no draft yet
+	SaveDraft(draft *models.Draft, expectedVersion uint) error
+	// ClearDraft empties the draft unless it was saved after the given time
+	ClearDraft(conversationID uint, username string, savedBefore time.Time) error
+
+	// GetParticipantSettings returns defaults when the participant never changed them
+	GetParticipantSettings(conversationID uint, username string) (*models.ParticipantSettings, error)
+	ListParticipantSettings(username string, conversationIDs []uint) ([]models.ParticipantSettings, error)
+	// SaveParticipantSettings replaces the settings and labels of the participant
+	SaveParticipantSettings(settings *models.ParticipantSettings) error
+	ListMutedParticipants(conversationID uint, at time.Time) ([]string, error)
+
+	// BlockUser is a no-op when the user is already blocked
+	BlockUser(block *models.Block) error
+	UnblockUser(blocker, blocked string) error
+	ListBlocks(blocker string) ([]models.Block, error)
+	// ListBlockers returns which of the candidates blocked the user
+	ListBlockers(blocked string, candidates []string) ([]string, error)
+
+	// EnsureDataKey saves the key unless the conversation already has one and returns the stored key
+	EnsureDataKey(key *models.DataKey) (*models.DataKey, error)
+	GetDataKey(id uint) (*models.DataKey, error)
+	// ListDataKeysToRewrap pages by ID through the keys wrapped by another master key than the given one
+	ListDataKeysToRewrap(masterKeyID string, afterID uint, limit int) ([]models.DataKey, error)
+	// RewrapDataKey fails with ErrVersionConflict when the key was rewrapped concurrently
+	RewrapDataKey(key *models.DataKey, previousMasterKeyID string) error
+
+	CreateScheduledMessage(scheduled *models.ScheduledMessage) error
+	GetScheduledMessage(id string) (*models.ScheduledMessage, error)
+	ListScheduledMessages(sender string, status constants.ScheduledStatus) ([]models.ScheduledMessage, error)
+	// UpdateScheduledMessage and CancelScheduledMessage only touch pending messages of
+	// the sender and fail with ErrNotFound otherwise
+	UpdateScheduledMessage(scheduled *models.ScheduledMessage) error
+	CancelScheduledMessage(id, sender string) error
+	// ClaimDueScheduledMessages leases the due messages that are pending or not published
+	// yet, a message is only handed to one caller until leaseUntil passes
+	ClaimDueScheduledMessages(now, leaseUntil time.Time, limit int) ([]models.ScheduledMessage, error)
+	// FireScheduledMessage marks the scheduled message as sent and saves msg in the same
+	// transaction, ErrNotFound means it is not pending anymore
+	FireScheduledMessage(id string, msg *models.Message, attachmentIDs []string) error
+	MarkScheduledMessagePublished(id string, at time.Time) error
+	FailScheduledMessage(id, reason string) error
+
+	CreateAttachment(attachment *models.Attachment) error
+	GetAttachment(id string) (*models.Attachment, error)
+	ListAttachments(messageID string) ([]models.Attachment, error)
+	// CountAttachmentsByStorageKey tells whether a blob is still referenced, forwards share it
+	CountAttachmentsByStorageKey(storageKey string) (int64, error)
+
+	// SaveModerationDecisions records the decisions on messages that were not saved,
+	// the others are saved along with their message
+	SaveModerationDecisions(decisions []models.ModerationDecision) error
+	ListModerationDecisions(messageID string) ([]models.ModerationDecision, error)
+	// ListQuarantinedMessages pages oldest first through the messages held for review,
+	// with their attachments and moderation decisions
+	ListQuarantinedMessages(offset, limit int) ([]models.Message, error)
+	// ReleaseQuarantinedMessage fails with ErrNotFound unless the message is quarantined
+	ReleaseQuarantinedMessage(id string) error
+
+	// CreateReport fails with ErrDuplicate when the reporter already reported the message
+	CreateReport(report *models.Report) error
+	GetReport(id string) (*models.Report, error)
+	// ListReports pages oldest first through the reports with the status, all of them when it is empty
+	ListReports(status constants.ReportStatus, offset, limit int) ([]models.Report, error)
+	// ClaimReport assigns an open report to the moderator, claiming it again is a no-op.
+	// It fails with ErrVersionConflict when another moderator claimed it or it is resolved
+	ClaimReport(id, moderator string, at time.Time) error
+	// ResolveReport saves the resolution of a report claimed by report.ResolvedBy, and
+	// fails with ErrVersionConflict otherwise
+	ResolveReport(report *models.Report) error
+
+	CreateAuditEntry(entry *models.AuditEntry) error
+	// ListAuditEntries pages newest first through the audit log
+	ListAuditEntries(filter AuditFilter, offset, limit int) ([]models.AuditEntry, error)
+
+	// EraseUser redacts what the user sent, replaces the user with the pseudonym
+	// everywhere else and removes them from their conversations, in one transaction.
+	// The conversations are detached from participant lookups
+	EraseUser(username, pseudonym string) (*ErasureResult, error)
+	SaveErasureRecord(record *models.ErasureRecord) error
+	GetErasureRecord(id string) (*models.ErasureRecord, error)
+	// FindUnfinishedErasure returns the latest erasure of the subject that did not complete
+	FindUnfinishedErasure(subjectHash string) (*models.ErasureRecord, error)
+	// ListUserMessages pages oldest first through the messages the user sent or received
+	ListUserMessages(username string, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error)
+	ListUserRecords(username string) (*UserRecords, error)
+}
@@ -0,0 +1,733 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/migrations"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newSQLiteStore(t *testing.T) repository.Store {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "messages.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	store, err := repository.NewSQLiteStore(db)
+	require.NoError(t, err)
+	return store
+}
+
+// newPostgresStore runs against POSTGRES_TEST_DSN, every table is truncated first
+func newPostgresStore(t *testing.T) repository.Store {
+	return repository.NewPostgresStore(openPostgres(t))
+}
+
+func openPostgres(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	migrator, err := migrations.NewMigrator(sqlDB)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	var tables []string
+	err = db.Raw("SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'").
+		Scan(&tables).Error
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("TRUNCATE "+strings.Join(tables, ", ")+" RESTART IDENTITY CASCADE").Error)
+
+	return db
+}
+
+func TestSQLiteStore(t *testing.T) {
+	runConformance(t, newSQLiteStore)
+}
+
+func TestPostgresStore(t *testing.T) {
+	runConformance(t, newPostgresStore)
+}
+
+func TestPostgresImportCreatesPartitions(t *testing.T) {
+	db := openPostgres(t)
+	store := repository.NewPostgresStore(db)
+	conv := createConversation(t, store)
+
+	msg := newMessage(conv.ID, 0)
+	msg.CreatedAt = time.Date(2015, 4, 2, 0, 0, 0, 0, time.UTC)
+	inserted, err := store.ImportMessages([]models.Message{*msg})
+	require.NoError(t, err)
+	assert.Equal(t, 1, inserted)
+
+	var partition string
+	err = db.Raw("SELECT tableoid::regclass::text FROM messages WHERE id = ?", msg.ID).Scan(&partition).Error
+	require.NoError(t, err)
+	assert.Equal(t, "messages_p2015_04", partition)
+}
+
+func runConformance(t *testing.T, newStore func(t *testing.T) repository.Store) {
+	t.Run("conversations are matched regardless of participant order", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateConversation([]string{"foo", "bar"})
+		require.NoError(t, err)
+
+		found, err := store.FindConversation([]string{"bar", "foo"})
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, found.ID)
+
+		_, err = store.FindConversation([]string{"foo", "baz"})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = store.FindConversation([]string{"foo"})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		byID, err := store.GetConversationByID(created.ID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"foo", "bar"}, byID.Participants)
+	})
+
+	t.Run("creating an existing conversation returns it", func(t *testing.T) {
+		store := newStore(t)
+
+		first, err := store.CreateConversation([]string{"foo", "bar"})
+		require.NoError(t, err)
+		second, err := store.CreateConversation([]string{"bar", "foo", "bar"})
+		require.NoError(t, err)
+		assert.Equal(t, first.ID, second.ID)
+
+		duplicates, err := store.FindDuplicateConversations()
+		require.NoError(t, err)
+		assert.Empty(t, duplicates)
+	})
+
+	t.Run("merging conversations moves their messages", func(t *testing.T) {
+		store := newStore(t)
+		keep := createConversation(t, store)
+		other, err := store.CreateConversation([]string{"foo", "baz"})
+		require.NoError(t, err)
+		msg := newMessage(other.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+
+		require.NoError(t, store.SaveDraft(&models.Draft{ConversationID: keep.ID, Username: "foo", Content: "kept"}, 0))
+		require.NoError(t, store.SaveDraft(&models.Draft{ConversationID: other.ID, Username: "foo", Content: "dropped"}, 0))
+		require.NoError(t, store.SaveDraft(&models.Draft{ConversationID: other.ID, Username: "baz", Content: "moved"}, 0))
+
+		require.NoError(t, store.MergeConversations(keep.ID, []uint{other.ID}))
+
+		moved, err := store.GetMessage(msg.ID)
+		require.NoError(t, err)
+		assert.Equal(t, keep.ID, moved.ConversationID)
+		_, err = store.GetConversationByID(other.ID)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		draft, err := store.GetDraft(keep.ID, "foo")
+		require.NoError(t, err)
+		assert.Equal(t, "kept", draft.Content)
+		draft, err = store.GetDraft(keep.ID, "baz")
+		require.NoError(t, err)
+		assert.Equal(t, "moved", draft.Content)
+	})
+
+	t.Run("messages are paged newest first", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+
+		var ids []string
+		for i := 0; i < 5; i++ {
+			msg := newMessage(conv.ID, time.Duration(i)*time.Minute)
+			require.NoError(t, store.CreateMessage(msg, nil))
+			ids = append(ids, msg.ID)
+		}
+
+		page, err := store.ListMessages(conv.ID, 1, 2)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, ids[3], page[0].ID)
+		assert.Equal(t, ids[2], page[1].ID)
+
+		msg, err := store.GetMessage(ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "foo", msg.Sender)
+
+		_, err = store.GetMessage(uuid.NewString())
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		byIDs, err := store.GetMessagesByIDs([]string{ids[0], ids[4], uuid.NewString()})
+		require.NoError(t, err)
+		assert.Len(t, byIDs, 2)
+	})
+
+	t.Run("updates are rejected on version conflicts", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+
+		createdAt := msg.CreatedAt
+		msg.Content = "edited"
+		msg.Version = 2
+		msg.CreatedAt = time.Time{}
+		err := store.UpdateMessage(msg, 5, nil)
+		assert.ErrorIs(t, err, repository.ErrVersionConflict)
+
+		revision := &models.MessageRevision{MessageID: msg.ID, Version: 1, Content: "hello", EditedBy: "foo", EditedAt: time.Now()}
+		require.NoError(t, store.UpdateMessage(msg, 1, revision))
+
+		saved, err := store.GetMessage(msg.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "edited", saved.Content)
+		assert.Equal(t, uint(2), saved.Version)
+		assert.True(t, createdAt.Equal(saved.CreatedAt), "the partition key is kept")
+
+		revisions, err := store.ListRevisions(msg.ID)
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+		assert.Equal(t, "hello", revisions[0].Content)
+	})
+
+	t.Run("attachments are claimed with the message", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+
+		own := &models.Attachment{ID: uuid.NewString(), Uploader: "foo", Filename: "a.png"}
+		other := &models.Attachment{ID: uuid.NewString(), Uploader: "bar", Filename: "b.png"}
+		require.NoError(t, store.CreateAttachment(own))
+		require.NoError(t, store.CreateAttachment(other))
+
+		stolen := newMessage(conv.ID, 0)
+		assert.Error(t, store.CreateMessage(stolen, []string{other.ID}))
+		_, err := store.GetMessage(stolen.ID)
+		assert.ErrorIs(t, err, repository.ErrNotFound, "the message must be rolled back")
+
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, []string{own.ID}))
+
+		messages, err := store.ListMessages(conv.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		require.Len(t, messages[0].Attachments, 1)
+		assert.Equal(t, own.ID, messages[0].Attachments[0].ID)
+
+		attachment, err := store.GetAttachment(own.ID)
+		require.NoError(t, err)
+		require.NotNil(t, attachment.MessageID)
+		assert.Equal(t, msg.ID, *attachment.MessageID)
+	})
+
+	t.Run("reactions are aggregated per emoji", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+
+		require.NoError(t, store.AddReaction(&models.Reaction{MessageID: msg.ID, Username: "foo", Emoji: "👍"}))
+		require.NoError(t, store.AddReaction(&models.Reaction{MessageID: msg.ID, Username: "foo", Emoji: "👍"}))
+		require.NoError(t, store.AddReaction(&models.Reaction{MessageID: msg.ID, Username: "bar", Emoji: "👍"}))
+		require.NoError(t, store.AddReaction(&models.Reaction{MessageID: msg.ID, Username: "bar", Emoji: "🎉"}))
+
+		summaries, err := store.SummarizeReactions([]string{msg.ID}, "foo")
+		require.NoError(t, err)
+		require.Len(t, summaries, 2)
+		byEmoji := map[string]models.ReactionSummary{}
+		for _, summary := range summaries {
+			byEmoji[summary.Emoji] = summary
+		}
+		assert.Equal(t, 2, byEmoji["👍"].Count)
+		assert.True(t, byEmoji["👍"].ReactedByMe)
+		assert.Equal(t, 1, byEmoji["🎉"].Count)
+		assert.False(t, byEmoji["🎉"].ReactedByMe)
+
+		require.NoError(t, store.RemoveReaction(msg.ID, "bar", "🎉"))
+		summaries, err = store.SummarizeReactions([]string{msg.ID}, "foo")
+		require.NoError(t, err)
+		assert.Len(t, summaries, 1)
+	})
+
+	t.Run("receipts keep the first delivery and read times", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+
+		delivered := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		read := time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, store.RecordReceipt(msg.ID, "bar", constants.MessageDeliveredKey, delivered))
+		require.NoError(t, store.RecordReceipt(msg.ID, "bar", constants.MessageReadKey, read))
+		require.NoError(t, store.RecordReceipt(msg.ID, "bar", constants.MessageDeliveredKey, read))
+
+		receipts, err := store.ListReceipts(msg.ID)
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		require.NotNil(t, receipts[0].DeliveredAt)
+		require.NotNil(t, receipts[0].ReadAt)
+		assert.True(t, delivered.Equal(*receipts[0].DeliveredAt))
+		assert.True(t, read.Equal(*receipts[0].ReadAt))
+
+		messages, err := store.ListMessages(conv.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, messages[0].SeenBy, 1)
+		assert.Equal(t, "bar", messages[0].SeenBy[0].Username)
+	})
+
+	t.Run("retention is saved with its notice", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		notice := newMessage(conv.ID, 0)
+		require.NoError(t, store.SetConversationRetention(conv.ID, constants.Retention7Days, notice))
+
+		saved, err := store.GetConversationByID(conv.ID)
+		require.NoError(t, err)
+		assert.Equal(t, constants.Retention7Days, saved.Retention)
+		_, err = store.GetMessage(notice.ID)
+		require.NoError(t, err)
+
+		orphan := newMessage(conv.ID+1, 0)
+		err = store.SetConversationRetention(conv.ID+1, constants.Retention7Days, orphan)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = store.GetMessage(orphan.ID)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("expired messages are deleted in batches", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		require.NoError(t, store.SetConversationRetention(conv.ID, constants.Retention24Hours, nil))
+
+		old := newMessage(conv.ID, -48*time.Hour)
+		older := newMessage(conv.ID, -72*time.Hour)
+		recent := newMessage(conv.ID, 0)
+		for _, msg := range []*models.Message{old, older, recent} {
+			require.NoError(t, store.CreateMessage(msg, nil))
+		}
+		require.NoError(t, store.AddReaction(&models.Reaction{MessageID: old.ID, Username: "bar", Emoji: "👍"}))
+
+		withRetention, err := store.ListConversationsWithRetention()
+		require.NoError(t, err)
+		require.Len(t, withRetention, 1)
+		assert.Equal(t, constants.Retention24Hours, withRetention[0].Retention)
+
+		cutoff := time.Now().Add(-24 * time.Hour)
+		expired, err := store.DeleteExpiredMessages(conv.ID, cutoff, 1)
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+		assert.Equal(t, older.ID, expired[0].ID)
+
+		expired, err = store.DeleteExpiredMessages(conv.ID, cutoff, 10)
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+
+		remaining, err := store.ListMessages(conv.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, remaining, 1)
+		assert.Equal(t, recent.ID, remaining[0].ID)
+		summaries, err := store.SummarizeReactions([]string{old.ID}, "bar")
+		require.NoError(t, err)
+		assert.Empty(t, summaries)
+	})
+
+	t.Run("replies are listed oldest first", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		parent := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(parent, nil))
+
+		for i := 2; i > 0; i-- {
+			reply := newMessage(conv.ID, time.Duration(i)*time.Minute)
+			reply.ReplyToID = &parent.ID
+			require.NoError(t, store.CreateMessage(reply, nil))
+		}
+
+		other, err := store.CreateConversation([]string{"foo", "baz"})
+		require.NoError(t, err)
+		elsewhere := newMessage(other.ID, 0)
+		elsewhere.ReplyToID = &parent.ID
+		require.NoError(t, store.CreateMessage(elsewhere, nil))
+
+		replies, err := store.ListReplies(conv.ID, parent.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, replies, 2)
+		assert.True(t, replies[0].CreatedAt.Before(replies[1].CreatedAt))
+	})
+
+	t.Run("keyset paging visits messages sharing a timestamp once", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		for i := 0; i < 5; i++ {
+			msg := newMessage(conv.ID, 0)
+			msg.CreatedAt = at.Add(time.Duration(i/2) * time.Second)
+			require.NoError(t, store.CreateMessage(msg, nil))
+		}
+
+		seen := map[string]bool{}
+		var afterCreatedAt time.Time
+		afterID := ""
+		for {
+			page, err := store.ListMessagesAfter(conv.ID, afterCreatedAt, afterID, 2)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			for _, msg := range page {
+				assert.False(t, seen[msg.ID])
+				assert.False(t, msg.CreatedAt.Before(afterCreatedAt))
+				seen[msg.ID] = true
+			}
+			last := page[len(page)-1]
+			afterCreatedAt, afterID = last.CreatedAt, last.ID
+		}
+		assert.Len(t, seen, 5)
+	})
+
+	t.Run("quarantined messages are only shown to their sender", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		delivered := newMessage(conv.ID, -time.Minute)
+		require.NoError(t, store.CreateMessage(delivered, nil))
+		held := newMessage(conv.ID, 0)
+		held.Quarantined = true
+		held.ModerationDecisions = []models.ModerationDecision{{Sender: "foo", Filter: "links", Action: constants.ModerationQuarantine}}
+		require.NoError(t, store.CreateMessage(held, nil))
+
+		visible, err := store.ListMessagesVisibleTo(conv.ID, "bar", 0, 10)
+		require.NoError(t, err)
+		require.Len(t, visible, 1)
+		assert.Equal(t, delivered.ID, visible[0].ID)
+		visible, err = store.ListMessagesVisibleTo(conv.ID, "foo", 0, 10)
+		require.NoError(t, err)
+		assert.Len(t, visible, 2)
+		last, err := store.GetLastMessages([]uint{conv.ID})
+		require.NoError(t, err)
+		require.Len(t, last, 1)
+		assert.Equal(t, delivered.ID, last[0].ID)
+
+		queue, err := store.ListQuarantinedMessages(0, 10)
+		require.NoError(t, err)
+		require.Len(t, queue, 1)
+		assert.Len(t, queue[0].ModerationDecisions, 1)
+		require.NoError(t, store.ReleaseQuarantinedMessage(held.ID))
+		assert.ErrorIs(t, store.ReleaseQuarantinedMessage(held.ID), repository.ErrNotFound)
+
+		deleted, err := store.DeleteMessage(held.ID, "mod", "spam")
+		require.NoError(t, err)
+		assert.Equal(t, held.ID, deleted.ID)
+		decisions, err := store.ListModerationDecisions(held.ID)
+		require.NoError(t, err)
+		assert.Empty(t, decisions)
+		_, err = store.DeleteMessage(held.ID, "mod", "spam")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("reports are claimed by one moderator and resolved once", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+		report := &models.Report{
+			ID:             uuid.NewString(),
+			MessageID:      msg.ID,
+			ConversationID: conv.ID,
+			Reporter:       "bar",
+			Sender:         "foo",
+			Reason:         "rude",
+			Snapshot:       models.MessagePreview{ID: msg.ID, Sender: "foo", Content: "hello", CreatedAt: msg.CreatedAt},
+			Status:         constants.ReportOpen,
+		}
+		require.NoError(t, store.CreateReport(report))
+		duplicate := *report
+		duplicate.ID = uuid.NewString()
+		assert.ErrorIs(t, store.CreateReport(&duplicate), repository.ErrDuplicate)
+
+		now := time.Now()
+		require.NoError(t, store.ClaimReport(report.ID, "mod1", now))
+		require.NoError(t, store.ClaimReport(report.ID, "mod1", now))
+		assert.ErrorIs(t, store.ClaimReport(report.ID, "mod2", now), repository.ErrVersionConflict)
+		assert.ErrorIs(t, store.ClaimReport(uuid.NewString(), "mod1", now), repository.ErrNotFound)
+		open, err := store.ListReports(constants.ReportOpen, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, open)
+
+		report.Status = constants.ReportResolved
+		report.ResolvedBy = "mod2"
+		report.ResolvedAt = &now
+		report.Resolution = constants.ReportDismiss
+		assert.ErrorIs(t, store.ResolveReport(report), repository.ErrVersionConflict)
+		report.ResolvedBy = "mod1"
+		require.NoError(t, store.ResolveReport(report))
+		assert.ErrorIs(t, store.ResolveReport(report), repository.ErrVersionConflict)
+
+		stored, err := store.GetReport(report.ID)
+		require.NoError(t, err)
+		assert.Equal(t, constants.ReportResolved, stored.Status)
+		assert.Equal(t, constants.ReportDismiss, stored.Resolution)
+		assert.Equal(t, "hello", stored.Snapshot.Content)
+	})
+
+	t.Run("deleted messages leave tombstones in the history", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		oldest := newMessage(conv.ID, -2*time.Minute)
+		deleted := newMessage(conv.ID, -time.Minute)
+		held := newMessage(conv.ID, 0)
+		held.Quarantined = true
+		for _, msg := range []*models.Message{oldest, deleted, held} {
+			require.NoError(t, store.CreateMessage(msg, nil))
+		}
+		_, err := store.DeleteMessage(deleted.ID, "mod", "insult")
+		require.NoError(t, err)
+
+		history, err := store.ListConversationHistory(conv.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		assert.Equal(t, held.ID, history[0].Message.ID)
+		assert.Nil(t, history[1].Message)
+		assert.Equal(t, deleted.ID, history[1].Tombstone.MessageID)
+		assert.Equal(t, "mod", history[1].Tombstone.DeletedBy)
+		assert.Equal(t, oldest.ID, history[2].Message.ID)
+		page, err := store.ListConversationHistory(conv.ID, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.NotNil(t, page[0].Tombstone)
+
+		tombstone, err := store.GetMessageTombstone(deleted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "insult", tombstone.Reason)
+		_, err = store.GetMessageTombstone(oldest.ID)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("audit entries are listed newest first", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		entries := []*models.AuditEntry{
+			{Actor: "admin", Action: "GET /api/admin/messages/:id/history", Target: "message:1", Status: 200, CreatedAt: now.Add(-time.Minute)},
+			{Actor: "admin", Action: "PUT /api/admin/messages/:id/status", Target: "message:1", Status: 200, Details: map[string]interface{}{"status": "message.read"}, CreatedAt: now},
+			{Actor: "other", Action: "GET /api/admin/audit", Status: 403, CreatedAt: now},
+		}
+		for _, entry := range entries {
+			require.NoError(t, store.CreateAuditEntry(entry))
+		}
+
+		listed, err := store.ListAuditEntries(repository.AuditFilter{Target: "message:1"}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, listed, 2)
+		assert.Equal(t, "PUT /api/admin/messages/:id/status", listed[0].Action)
+		assert.Equal(t, "message.read", listed[0].Details["status"])
+		listed, err = store.ListAuditEntries(repository.AuditFilter{Actor: "other"}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.Equal(t, 403, listed[0].Status)
+	})
+
+	t.Run("erasing a user detaches their conversations", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		msg := newMessage(conv.ID, 0)
+		require.NoError(t, store.CreateMessage(msg, nil))
+
+		result, err := store.EraseUser("foo", "deleted-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), result.MessagesRedacted)
+		assert.Equal(t, int64(1), result.ConversationsUpdated)
+
+		inbox, err := store.ListConversations("foo", repository.InboxFilter{Now: time.Now()}, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, inbox)
+		inbox, err = store.ListConversations("bar", repository.InboxFilter{Now: time.Now()}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, inbox, 1)
+		_, err = store.FindConversation([]string{"foo", "bar"})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		saved, err := store.GetMessage(msg.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "deleted-1", saved.Sender)
+		assert.True(t, saved.Redacted)
+	})
+
+	t.Run("inbox lists the most recently active conversations first", func(t *testing.T) {
+		store := newStore(t)
+		quiet := createConversation(t, store)
+		active, err := store.CreateConversation([]string{"foo", "baz"})
+		require.NoError(t, err)
+		_, err = store.CreateConversation([]string{"bar", "baz"})
+		require.NoError(t, err)
+		require.NoError(t, store.CreateMessage(newMessage(quiet.ID, -time.Hour), nil))
+		last := newMessage(active.ID, 0)
+		require.NoError(t, store.CreateMessage(newMessage(active.ID, -2*time.Hour), nil))
+		require.NoError(t, store.CreateMessage(last, nil))
+
+		conversations, err := store.ListConversations("foo", repository.InboxFilter{}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, conversations, 2)
+		assert.Equal(t, active.ID, conversations[0].ID)
+		assert.Equal(t, quiet.ID, conversations[1].ID)
+
+		lastMessages, err := store.GetLastMessages([]uint{active.ID})
+		require.NoError(t, err)
+		require.Len(t, lastMessages, 1)
+		assert.Equal(t, last.ID, lastMessages[0].ID)
+	})
+
+	t.Run("inbox is filtered by participant settings", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now()
+		archived := createConversation(t, store)
+		muted, err := store.CreateConversation([]string{"foo", "baz"})
+		require.NoError(t, err)
+		pinned, err := store.CreateConversation([]string{"foo", "qux"})
+		require.NoError(t, err)
+		require.NoError(t, store.CreateMessage(newMessage(muted.ID, 0), nil))
+
+		mutedUntil := now.Add(time.Hour)
+		require.NoError(t, store.SaveParticipantSettings(&models.ParticipantSettings{ConversationID: archived.ID, Username: "foo", Archived: true, Labels: []string{"work"}}))
+		require.NoError(t, store.SaveParticipantSettings(&models.ParticipantSettings{ConversationID: muted.ID, Username: "foo", MutedUntil: &mutedUntil, Labels: []string{"work", "family", "work"}}))
+		require.NoError(t, store.SaveParticipantSettings(&models.ParticipantSettings{ConversationID: pinned.ID, Username: "foo", PinnedToTop: true}))
+
+		no, yes := false, true
+		ids := func(filter repository.InboxFilter) []uint {
+			filter.Now = now
+			conversations, err := store.ListConversations("foo", filter, 0, 10)
+			require.NoError(t, err)
+			var ids []uint
+			for _, conv := range conversations {
+				ids = append(ids, conv.ID)
+			}
+			return ids
+		}
+		assert.Equal(t, []uint{pinned.ID, muted.ID}, ids(repository.InboxFilter{Archived: &no}))
+		assert.Equal(t, []uint{archived.ID}, ids(repository.InboxFilter{Archived: &yes}))
+		assert.Equal(t, []uint{muted.ID}, ids(repository.InboxFilter{Muted: &yes}))
+		assert.Equal(t, []uint{muted.ID, archived.ID}, ids(repository.InboxFilter{Label: "work"}))
+
+		settings, err := store.GetParticipantSettings(muted.ID, "foo")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"family", "work"}, settings.Labels)
+		mutedBy, err := store.ListMutedParticipants(muted.ID, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"foo"}, mutedBy)
+		mutedBy, err = store.ListMutedParticipants(muted.ID, now.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, mutedBy)
+	})
+
+	t.Run("drafts are versioned and cleared by newer messages", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+
+		draft := &models.Draft{ConversationID: conv.ID, Username: "foo", Content: "hel"}
+		require.NoError(t, store.SaveDraft(draft, 0))
+		assert.ErrorIs(t, store.SaveDraft(&models.Draft{ConversationID: conv.ID, Username: "foo", Content: "other device"}, 0), repository.ErrVersionConflict)
+		draft.Content = "hello"
+		require.NoError(t, store.SaveDraft(draft, 1))
+		assert.Equal(t, uint(2), draft.Version)
+
+		require.NoError(t, store.ClearDraft(conv.ID, "foo", draft.UpdatedAt.Add(-time.Second)))
+		drafts, err := store.ListDrafts("foo", []uint{conv.ID})
+		require.NoError(t, err)
+		assert.Len(t, drafts, 1, "drafts saved after the message are kept")
+
+		require.NoError(t, store.ClearDraft(conv.ID, "foo", time.Now()))
+		drafts, err = store.ListDrafts("foo", []uint{conv.ID})
+		require.NoError(t, err)
+		assert.Empty(t, drafts)
+		cleared, err := store.GetDraft(conv.ID, "foo")
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), cleared.Version)
+	})
+
+	t.Run("imports recount forwards", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		original := newMessage(conv.ID, -time.Hour)
+		original.ForwardCount = 7
+		forward := newMessage(conv.ID, 0)
+		forward.ForwardedFromID = &original.ID
+
+		inserted, err := store.ImportMessages([]models.Message{*original})
+		require.NoError(t, err)
+		assert.Equal(t, 1, inserted)
+		saved, err := store.GetMessage(original.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 0, saved.ForwardCount)
+
+		_, err = store.ImportMessages([]models.Message{*forward})
+		require.NoError(t, err)
+		saved, err = store.GetMessage(original.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 1, saved.ForwardCount)
+	})
+
+	t.Run("pins are capped per conversation", func(t *testing.T) {
+		store := newStore(t)
+		conv := createConversation(t, store)
+		first := newMessage(conv.ID, 0)
+		second := newMessage(conv.ID, time.Minute)
+		for _, msg := range []*models.Message{first, second} {
+			require.NoError(t, store.CreateMessage(msg, nil))
+		}
+
+		pin := &models.Pin{ConversationID: conv.ID, MessageID: first.ID, PinnedBy: "foo", PinnedAt: time.Now()}
+		require.NoError(t, store.AddPin(pin, 1))
+		again := &models.Pin{ConversationID: conv.ID, MessageID: first.ID, PinnedBy: "bar", PinnedAt: time.Now()}
+		require.NoError(t, store.AddPin(again, 1))
+		assert.Equal(t, "foo", again.PinnedBy)
+
+		err := store.AddPin(&models.Pin{ConversationID: conv.ID, MessageID: second.ID, PinnedAt: time.Now()}, 1)
+		assert.ErrorIs(t, err, repository.ErrLimitReached)
+
+		pins, err := store.ListPins(conv.ID)
+		require.NoError(t, err)
+		require.Len(t, pins, 1)
+		require.NotNil(t, pins[0].Message)
+		assert.Equal(t, first.ID, pins[0].Message.ID)
+
+		require.NoError(t, store.RemovePin(conv.ID, first.ID))
+		assert.ErrorIs(t, store.RemovePin(conv.ID, first.ID), repository.ErrNotFound)
+	})
+}
+
+func createConversation(t *testing.T, store repository.Store) *models.Conversation {
+	conv, err := store.CreateConversation([]string{"foo", "bar"})
+	require.NoError(t, err)
+	return conv
+}
+
+func newMessage(conversationID uint, age time.Duration) *models.Message {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return &models.Message{
+		ID:             uuid.NewString(),
+		ConversationID: conversationID,
+		Content:        "hello",
+		Sender:         "foo",
+		Receiver:       "bar",
+		Status:         constants.MessageSentKey,
+		Type:           constants.MessageCreate,
+		Sent:           true,
+		CreatedAt:      now.Add(age),
+		UpdatedAt:      now,
+		Version:        1,
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var ADMIN_PAGINATION_SIZE = 50
+
+type AdminServiceInterface interface {
+	FindConversations(participant string, offset int) ([]models.Conversation, error)
+	GetConversation(id uint) (*models.Conversation, error)
+	ListHistory(conversationID uint, offset int) ([]repository.HistoryEntry, error)
+	GetMessageHistory(messageID string) (*models.MessageHistory, error)
+	CorrectStatus(messageID string, status constants.RoutingKey) (*models.Message, constants.RoutingKey, error)
+	ListDuplicates() ([][]uint, error)
+	MergeConversations(keepID uint, duplicateIDs []uint) (*models.Conversation, error)
+	Reemit(messageID string, key constants.RoutingKey) error
+	ListAudit(filter repository.AuditFilter, offset int) ([]models.AuditEntry, error)
+}
+
+// AdminService lets support inspect and repair conversations without going to the
+// database. The admin routes record every call in the audit log
+type AdminService struct {
+	Messages *MessagesService
+}
+
+func NewAdminService(messages *MessagesService) *AdminService {
+	return &AdminService{
+		Messages: messages,
+	}
+}
+
+// FindConversations lists the conversations of the participant regardless of their
+// settings, most recently active first
+func (srv *AdminService) FindConversations(participant string, offset int) ([]models.Conversation, error) {
+	return srv.Messages.Store.ListConversations(participant, repository.InboxFilter{}, offset, ADMIN_PAGINATION_SIZE)
+}
+
+func (srv *AdminService) GetConversation(id uint) (*models.Conversation, error) {
+	return srv.Messages.Store.GetConversationByID(id)
+}
+
+// ListHistory pages through the messages of the conversation as stored, quarantined
+// ones and the tombstones of deleted ones included
+func (srv *AdminService) ListHistory(conversationID uint, offset int) ([]repository.HistoryEntry, error) {
+	if _, err := srv.Messages.Store.GetConversationByID(conversationID); err != nil {
+		return nil, err
+	}
+	return srv.Messages.Store.ListConversationHistory(conversationID, offset, ADMIN_PAGINATION_SIZE)
+}
+
+// GetMessageHistory gathers the versions of the message, its receipts as the status
+// history, the moderation decisions and the admin actions taken on it
+func (srv *AdminService) GetMessageHistory(messageID string) (*models.MessageHistory, error) {
+	history := &models.MessageHistory{}
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		if history.Tombstone, err = srv.Messages.Store.GetMessageTombstone(messageID); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	history.Message = msg
+
+	if history.Revisions, err = srv.Messages.Store.ListRevisions(messageID); err != nil {
+		return nil, err
+	}
+	if history.Receipts, err = srv.Messages.Store.ListReceipts(messageID); err != nil {
+		return nil, err
+	}
+	if history.ModerationDecisions, err = srv.Messages.Store.ListModerationDecisions(messageID); err != nil {
+		return nil, err
+	}
+	filter := repository.AuditFilter{Target: "message:" + messageID}
+	if history.Audit, err = srv.Messages.Store.ListAuditEntries(filter, 0, ADMIN_PAGINATION_SIZE); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// CorrectStatus overwrites the status of the message and returns the previous one. A
+// delivered or read status is recorded as a receipt of the receiver too, receipts that
+// are already set are kept
+func (srv *AdminService) CorrectStatus(messageID string, status constants.RoutingKey) (*models.Message, constants.RoutingKey, error) {
+	if status != constants.MessageSentKey && status != constants.MessageDeliveredKey && status != constants.MessageReadKey {
+		return nil, "", ErrInvalidStatus
+	}
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	updated := *msg
+	updated.Status = status
+	updated.Read = status == constants.MessageReadKey
+	updated.Version = msg.Version + 1
+	if err := srv.Messages.Store.UpdateMessage(&updated, msg.Version, nil); err != nil {
+		return nil, "", err
+	}
+	if status != constants.MessageSentKey {
+		if err := srv.Messages.Store.RecordReceipt(msg.ID, msg.Receiver, status, time.Now()); err != nil {
+			return nil, "", err
+		}
+	}
+	return &updated, msg.Status, nil
+}
+
+func (srv *AdminService) ListDuplicates() ([][]uint, error) {
+	return srv.Messages.Store.FindDuplicateConversations()
+}
+
+// MergeConversations merges the duplicates into the kept conversation like the
+// repair-conversations command, they must have the same participants
+func (srv *AdminService) MergeConversations(keepID uint, duplicateIDs []uint) (*models.Conversation, error) {
+	keep, err := srv.Messages.Store.GetConversationByID(keepID)
+	if err != nil {
+		return nil, err
+	}
+	if len(duplicateIDs) == 0 {
+		return nil, ErrInvalidMerge
+	}
+	key := models.CanonicalKey(keep.Participants)
+	for _, id := range duplicateIDs {
+		if id == keepID {
+			return nil, ErrInvalidMerge
+		}
+		duplicate, err := srv.Messages.Store.GetConversationByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if models.CanonicalKey(duplicate.Participants) != key {
+			return nil, ErrInvalidMerge
+		}
+	}
+
+	if err := srv.Messages.Store.MergeConversations(keepID, duplicateIDs); err != nil {
+		return nil, err
+	}
+	return keep, nil
+}
+
+// Reemit publishes an event of the message again from what is stored, for consumers
+// that missed it. Events the message never got to, such as message.read on an unread
+// message, are refused since consumers would act on them
+func (srv *AdminService) Reemit(messageID string, key constants.RoutingKey) error {
+	if srv.Messages.Publisher == nil {
+		return errors.New("no publisher to emit events with")
+	}
+	if key == constants.MessageDeletedKey {
+		tombstone, err := srv.Messages.Store.GetMessageTombstone(messageID)
+		if err != nil {
+			return err
+		}
+		event := deletedEvent(srv.Messages.Store, tombstone.ConversationID, tombstone.MessageID)
+		return srv.Messages.Publisher.Publish(constants.MessageEventsExchange, key, event)
+	}
+
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if err != nil {
+		return err
+	}
+	var event interface{}
+	switch {
+	case key == constants.MessageQuarantinedKey && msg.Quarantined:
+		event = quarantinedEvent(msg)
+	case msg.Quarantined:
+		// anything else would deliver a message held for review
+		return ErrInvalidEvent
+	case key == constants.MessageSentKey:
+		attachments, err := srv.Messages.Store.ListAttachments(msg.ID)
+		if err != nil {
+			return err
+		}
+		attachmentIDs := make([]string, len(attachments))
+		for i, attachment := range attachments {
+			attachmentIDs[i] = attachment.ID
+		}
+		sent := srv.Messages.sentEvent(msg, attachmentIDs)
+		sent.Status = constants.MessageSentKey
+		event = sent
+	case key == constants.MessageDeliveredKey && msg.Status != constants.MessageSentKey,
+		key == constants.MessageReadKey && msg.Read:
+		event = models.WsMessage{
+			ID:        msg.ID,
+			Content:   msg.Content,
+			Sender:    msg.Sender,
+			Receiver:  msg.Receiver,
+			Status:    key,
+			Type:      constants.MessageUpdate,
+			Read:      key == constants.MessageReadKey,
+			Sent:      msg.Sent,
+			ReplyToID: msg.ReplyToID,
+			UpdatedBy: msg.Receiver,
+			CreatedAt: msg.CreatedAt,
+			UpdatedAt: msg.UpdatedAt,
+		}
+	default:
+		return ErrInvalidEvent
+	}
+	return srv.Messages.Publisher.Publish(constants.MessageEventsExchange, key, event)
+}
+
+func (srv *AdminService) ListAudit(filter repository.AuditFilter, offset int) ([]models.AuditEntry, error) {
+	return srv.Messages.Store.ListAuditEntries(filter, offset, ADMIN_PAGINATION_SIZE)
+}
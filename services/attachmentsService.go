@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var ATTACHMENT_URL_TTL = 15 * time.Minute
+
+// image headers are small, this is enough to read their dimensions
+const imageHeaderSize = 64 * 1024
+
+// MIN_SIGNING_KEY_LENGTH matches the size of the HMAC-SHA256 output
+var MIN_SIGNING_KEY_LENGTH = 32
+
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+var ErrInvalidSigningKey = fmt.Errorf("the attachment url signing key must be at least %d bytes", MIN_SIGNING_KEY_LENGTH)
+
+type AttachmentsServiceInterface interface {
+	Upload(ctx context.Context, uploader, filename string, file io.Reader, size int64) (*models.Attachment, error)
+	GetAttachment(id string) (*models.Attachment, error)
+	SignedURL(attachment *models.Attachment) string
+	VerifySignature(id, expires, signature string) error
+	Open(ctx context.Context, attachment *models.Attachment) (io.ReadCloser, error)
+}
+
+type AttachmentsService struct {
+	Store      repository.Store
+	Blobs      blobstore.BlobStore
+	signingKey []byte
+}
+
+// NewAttachmentsService fails with ErrInvalidSigningKey when the key is too short to
+// keep the download urls from being forged
+func NewAttachmentsService(store repository.Store, blobs blobstore.BlobStore, signingKey string) (*AttachmentsService, error) {
+	if len(signingKey) < MIN_SIGNING_KEY_LENGTH {
+		return nil, ErrInvalidSigningKey
+	}
+	return &AttachmentsService{
+		Store:      store,
+		Blobs:      blobs,
+		signingKey: []byte(signingKey),
+	}, nil
+}
+
+// Upload streams the file to the blob store while computing its checksum, the
+// attachment stays unlinked until a message references it
+func (srv *AttachmentsService) Upload(ctx context.Context, uploader, filename string, file io.Reader, size int64) (*models.Attachment, error) {
+	id := uuid.NewString()
+	attachment := &models.Attachment{
+		ID:         id,
+		Uploader:   uploader,
+		Filename:   filename,
+		Size:       size,
+		StorageKey: "attachments/" + id,
+	}
+
+	reader := bufio.NewReaderSize(file, imageHeaderSize)
+	head, _ := reader.Peek(512)
+	attachment.MimeType = http.DetectContentType(head)
+	if strings.HasPrefix(attachment.MimeType, "image/") {
+		header, _ := reader.Peek(imageHeaderSize)
+		if config, _, err := image.DecodeConfig(bytes.NewReader(header)); err == nil {
+			attachment.Width = &config.Width
+			attachment.Height = &config.Height
+		}
+	}
+
+	hasher := sha256.New()
+	err := srv.Blobs.Put(ctx, attachment.StorageKey, io.TeeReader(reader, hasher), size, attachment.MimeType)
+	if err != nil {
+		log.Printf("error storing attachment %v: %v\n", id, err)
+		return nil, err
+	}
+	attachment.Checksum = hex.EncodeToString(hasher.Sum(nil))
+
+	if err := srv.Store.CreateAttachment(attachment); err != nil {
+		log.Printf("error saving attachment %v: %v\n", id, err)
+		if deleteErr := srv.Blobs.Delete(ctx, attachment.StorageKey); deleteErr != nil {
+			log.Printf("error removing orphaned blob %v: %v\n", attachment.StorageKey, deleteErr)
+		}
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func (srv *AttachmentsService) GetAttachment(id string) (*models.Attachment, error) {
+	return srv.Store.GetAttachment(id)
+}
+
+func (srv *AttachmentsService) SignedURL(attachment *models.Attachment) string {
+	expires := strconv.FormatInt(time.Now().Add(ATTACHMENT_URL_TTL).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", srv.sign(attachment.ID, expires))
+
+	return fmt.Sprintf("/api/attachments/%s/content?%s", attachment.ID, query.Encode())
+}
+
+func (srv *AttachmentsService) VerifySignature(id, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(srv.sign(id, expires)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (srv *AttachmentsService) Open(ctx context.Context, attachment *models.Attachment) (io.ReadCloser, error) {
+	return srv.Blobs.Get(ctx, attachment.StorageKey)
+}
+
+func (srv *AttachmentsService) sign(id, expires string) string {
+	mac := hmac.New(sha256.New, srv.signingKey)
+	mac.Write([]byte(id + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
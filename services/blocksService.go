@@ -0,0 +1,51 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+type BlocksServiceInterface interface {
+	Block(blocker, blocked string) (*models.Block, error)
+	Unblock(blocker, blocked string) error
+	ListBlocks(blocker string) ([]models.Block, error)
+}
+
+type BlocksService struct {
+	Store repository.Store
+}
+
+func NewBlocksService(store repository.Store) *BlocksService {
+	return &BlocksService{
+		Store: store,
+	}
+}
+
+// Block keeps the original block time when the user was already blocked
+func (srv *BlocksService) Block(blocker, blocked string) (*models.Block, error) {
+	blocked = strings.TrimSpace(blocked)
+	if blocked == "" || blocked == blocker {
+		return nil, ErrInvalidBlock
+	}
+
+	block := &models.Block{
+		Blocker:   blocker,
+		Blocked:   blocked,
+		CreatedAt: time.Now(),
+	}
+	if err := srv.Store.BlockUser(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (srv *BlocksService) Unblock(blocker, blocked string) error {
+	return srv.Store.UnblockUser(blocker, blocked)
+}
+
+func (srv *BlocksService) ListBlocks(blocker string) ([]models.Block, error) {
+	return srv.Store.ListBlocks(blocker)
+}
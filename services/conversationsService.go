@@ -0,0 +1,310 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var MAX_PINS_PER_CONVERSATION = 50
+
+var retentionDurations = map[constants.RetentionPolicy]time.Duration{
+	constants.Retention24Hours: 24 * time.Hour,
+	constants.Retention7Days:   7 * 24 * time.Hour,
+	constants.Retention90Days:  90 * 24 * time.Hour,
+}
+
+// expired tells whether the retention policy of the conversation removed messages
+// created at createdAt. The sweeper only deletes from the database, messages read back
+// from archived partitions are filtered with it
+func expired(conv *models.Conversation, createdAt, now time.Time) bool {
+	duration, ok := retentionDurations[conv.Retention]
+	return ok && createdAt.Before(now.Add(-duration))
+}
+
+func dropExpired(messages []models.Message, conv *models.Conversation, now time.Time) []models.Message {
+	kept := messages[:0]
+	for _, msg := range messages {
+		if !expired(conv, msg.CreatedAt, now) {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+type ConversationsServiceInterface interface {
+	GetConversationForParticipant(id uint, user string) (*models.Conversation, error)
+	SetRetention(id uint, user string, retention constants.RetentionPolicy) (*models.Message, error)
+	GetPins(id uint, user string) ([]models.Pin, error)
+	PinMessage(id uint, user, messageID string) (*models.Pin, error)
+	UnpinMessage(id uint, user, messageID string) error
+	ListInbox(user string, filter repository.InboxFilter, offset int) ([]models.InboxEntry, error)
+	GetDraft(id uint, user string) (*models.Draft, error)
+	SaveDraft(draft *models.Draft, expectedVersion uint) error
+	GetSettings(id uint, user string) (*models.ParticipantSettings, error)
+	SaveSettings(settings *models.ParticipantSettings) error
+}
+
+type ConversationsService struct {
+	Store     repository.Store
+	Publisher EventPublisher
+}
+
+func NewConversationsService(store repository.Store, publisher EventPublisher) *ConversationsService {
+	return &ConversationsService{
+		Store:     store,
+		Publisher: publisher,
+	}
+}
+
+// GetConversationForParticipant returns ErrForbidden when the user is not part of the conversation
+func (srv *ConversationsService) GetConversationForParticipant(id uint, user string) (*models.Conversation, error) {
+	conv, err := srv.Store.GetConversationByID(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, participant := range conv.Participants {
+		if participant == user {
+			return conv, nil
+		}
+	}
+	return nil, ErrForbidden
+}
+
+// SetRetention changes the policy and records the change as a system message in the
+// conversation, both are saved together. The system message is then sent like any other
+func (srv *ConversationsService) SetRetention(id uint, user string, retention constants.RetentionPolicy) (*models.Message, error) {
+	if _, ok := retentionDurations[retention]; !ok && retention != constants.RetentionOff {
+		return nil, ErrInvalidRetention
+	}
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	content := fmt.Sprintf("%s set disappearing messages to %s", user, retention)
+	if retention == constants.RetentionOff {
+		content = fmt.Sprintf("%s turned off disappearing messages", user)
+	}
+	now := time.Now()
+	msg := &models.Message{
+		ID:             uuid.NewString(),
+		ConversationID: conv.ID,
+		Content:        content,
+		Sender:         user,
+		Receiver:       otherParticipant(conv, user),
+		Status:         constants.MessageSentKey,
+		Type:           constants.MessageSystem,
+		Sent:           true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Version:        1,
+	}
+	if err := srv.Store.SetConversationRetention(conv.ID, retention, msg); err != nil {
+		log.Printf("error updating retention of conversation %v: %v\n", conv.ID, err)
+		return nil, err
+	}
+
+	messages := NewMessagesService(srv.Store)
+	messages.Publisher = srv.Publisher
+	messages.publishSent(msg, nil)
+	return msg, nil
+}
+
+// GetPins leaves out the pins of messages the user can not see, the message may have
+// been quarantined by an edit or sent by someone the user blocked since
+func (srv *ConversationsService) GetPins(id uint, user string) ([]models.Pin, error) {
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return nil, err
+	}
+	pins, err := srv.Store.ListPins(conv.ID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := NewMessagesService(srv.Store).blockedFor(user)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := pins[:0]
+	for _, pin := range pins {
+		if pin.Message != nil && (heldFrom(pin.Message, user) || blocked(pin.Message)) {
+			continue
+		}
+		visible = append(visible, pin)
+	}
+	return visible, nil
+}
+
+// PinMessage pins a message of the conversation, pinning it again is a no-op
+func (srv *ConversationsService) PinMessage(id uint, user, messageID string) (*models.Pin, error) {
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := srv.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ConversationID != conv.ID {
+		return nil, repository.ErrNotFound
+	}
+	// pinning would show a blocked or quarantined message to the whole conversation
+	if err := NewMessagesService(srv.Store).ensureVisible(msg, user); err != nil {
+		return nil, err
+	}
+
+	pin := &models.Pin{
+		ConversationID: conv.ID,
+		MessageID:      msg.ID,
+		PinnedBy:       user,
+		PinnedAt:       time.Now(),
+	}
+	err = srv.Store.AddPin(pin, MAX_PINS_PER_CONVERSATION)
+	if errors.Is(err, repository.ErrLimitReached) {
+		return nil, ErrPinLimitReached
+	} else if err != nil {
+		log.Printf("error pinning message %v: %v\n", messageID, err)
+		return nil, err
+	}
+	pin.Message = msg
+
+	srv.publishPin(conv, constants.MessagePinnedKey, msg.ID, user)
+	return pin, nil
+}
+
+func (srv *ConversationsService) UnpinMessage(id uint, user, messageID string) error {
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return err
+	}
+	if err := srv.Store.RemovePin(conv.ID, messageID); err != nil {
+		return err
+	}
+
+	srv.publishPin(conv, constants.MessageUnpinnedKey, messageID, user)
+	return nil
+}
+
+// publishPin only logs failures, the pin itself is already saved
+func (srv *ConversationsService) publishPin(conv *models.Conversation, key constants.RoutingKey, messageID, user string) {
+	if srv.Publisher == nil {
+		return
+	}
+	event := models.PinEvent{
+		ConversationID: conv.ID,
+		Participants:   conv.Participants,
+		MessageID:      messageID,
+		Username:       user,
+	}
+	if err := srv.Publisher.Publish(constants.MessageEventsExchange, key, event); err != nil {
+		log.Printf("error publishing %v for message %v: %v\n", key, messageID, err)
+	}
+}
+
+// ListInbox pages through the user's conversations with their last message, the
+// user's settings and whether the user has a draft in them
+func (srv *ConversationsService) ListInbox(user string, filter repository.InboxFilter, offset int) ([]models.InboxEntry, error) {
+	if filter.Now.IsZero() {
+		filter.Now = time.Now()
+	}
+	conversations, err := srv.Store.ListConversations(user, filter, offset, MESSAGE_PAGINATION_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(conversations))
+	for i, conv := range conversations {
+		ids[i] = conv.ID
+	}
+
+	lastMessages, err := srv.Store.GetLastMessages(ids)
+	if err != nil {
+		return nil, err
+	}
+	byConversation := make(map[uint]*models.Message, len(lastMessages))
+	for i := range lastMessages {
+		byConversation[lastMessages[i].ConversationID] = &lastMessages[i]
+	}
+	drafts, err := srv.Store.ListDrafts(user, ids)
+	if err != nil {
+		return nil, err
+	}
+	hasDraft := make(map[uint]bool, len(drafts))
+	for _, draft := range drafts {
+		hasDraft[draft.ConversationID] = true
+	}
+	settings, err := srv.Store.ListParticipantSettings(user, ids)
+	if err != nil {
+		return nil, err
+	}
+	settingsByConversation := make(map[uint]*models.ParticipantSettings, len(settings))
+	for i := range settings {
+		settingsByConversation[settings[i].ConversationID] = &settings[i]
+	}
+
+	inbox := make([]models.InboxEntry, len(conversations))
+	for i := range conversations {
+		inbox[i] = models.InboxEntry{
+			Conversation: &conversations[i],
+			LastMessage:  byConversation[conversations[i].ID],
+			HasDraft:     hasDraft[conversations[i].ID],
+			Settings:     settingsByConversation[conversations[i].ID],
+		}
+	}
+	return inbox, nil
+}
+
+// GetDraft returns an empty draft at version 0 when the user never saved one
+func (srv *ConversationsService) GetDraft(id uint, user string) (*models.Draft, error) {
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return nil, err
+	}
+	draft, err := srv.Store.GetDraft(conv.ID, user)
+	if errors.Is(err, repository.ErrNotFound) {
+		return &models.Draft{ConversationID: conv.ID, Username: user}, nil
+	}
+	return draft, err
+}
+
+// SaveDraft fails with repository.ErrVersionConflict when another device saved in the meantime
+func (srv *ConversationsService) SaveDraft(draft *models.Draft, expectedVersion uint) error {
+	if _, err := srv.GetConversationForParticipant(draft.ConversationID, draft.Username); err != nil {
+		return err
+	}
+	return srv.Store.SaveDraft(draft, expectedVersion)
+}
+
+func (srv *ConversationsService) GetSettings(id uint, user string) (*models.ParticipantSettings, error) {
+	conv, err := srv.GetConversationForParticipant(id, user)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Store.GetParticipantSettings(conv.ID, user)
+}
+
+func (srv *ConversationsService) SaveSettings(settings *models.ParticipantSettings) error {
+	if _, err := srv.GetConversationForParticipant(settings.ConversationID, settings.Username); err != nil {
+		return err
+	}
+	if settings.Labels == nil {
+		settings.Labels = []string{}
+	}
+	return srv.Store.SaveParticipantSettings(settings)
+}
+
+func otherParticipant(conv *models.Conversation, user string) string {
+	for _, participant := range conv.Participants {
+		if participant != user {
+			return participant
+		}
+	}
+	return user
+}
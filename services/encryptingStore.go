@@ -0,0 +1,485 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/encryption"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+// EncryptingStore encrypts message content on its way to the store and decrypts it
+// on its way back, so the rest of the service only ever sees plaintext. Drafts and
+// scheduled messages are encrypted with the key of their conversation too. Messages
+// passed in keep their plaintext content once the call returns
+type EncryptingStore struct {
+	repository.Store
+	Cipher *encryption.ContentCipher
+}
+
+// NewEncryptingStore returns the store as is when there is no cipher
+func NewEncryptingStore(store repository.Store, cipher *encryption.ContentCipher) repository.Store {
+	if cipher == nil {
+		return store
+	}
+	return &EncryptingStore{
+		Store:  store,
+		Cipher: cipher,
+	}
+}
+
+func (s *EncryptingStore) CreateMessage(msg *models.Message, attachmentIDs []string) error {
+	return s.withEncrypted(msg, func() error {
+		return s.Store.CreateMessage(msg, attachmentIDs)
+	})
+}
+
+func (s *EncryptingStore) SetConversationRetention(id uint, retention constants.RetentionPolicy, notice *models.Message) error {
+	if notice == nil {
+		return s.Store.SetConversationRetention(id, retention, nil)
+	}
+	return s.withEncrypted(notice, func() error {
+		return s.Store.SetConversationRetention(id, retention, notice)
+	})
+}
+
+func (s *EncryptingStore) ForwardMessage(msg *models.Message, attachments []models.Attachment) error {
+	return s.withEncrypted(msg, func() error {
+		return s.Store.ForwardMessage(msg, attachments)
+	})
+}
+
+// ImportMessages encrypts copies of the messages, the caller's slice keeps its plaintext
+func (s *EncryptingStore) ImportMessages(messages []models.Message) (int, error) {
+	encrypted := make([]models.Message, len(messages))
+	for i, msg := range messages {
+		content, err := s.Cipher.Encrypt(msg.ConversationID, msg.ID, msg.Content)
+		if err != nil {
+			return 0, err
+		}
+		msg.Content = content
+		encrypted[i] = msg
+	}
+	return s.Store.ImportMessages(encrypted)
+}
+
+func (s *EncryptingStore) FireScheduledMessage(id string, msg *models.Message, attachmentIDs []string) error {
+	return s.withEncrypted(msg, func() error {
+		return s.Store.FireScheduledMessage(id, msg, attachmentIDs)
+	})
+}
+
+func (s *EncryptingStore) UpdateMessage(msg *models.Message, expectedVersion uint, revision *models.MessageRevision) error {
+	if revision != nil {
+		plaintext := revision.Content
+		encrypted, err := s.Cipher.Encrypt(msg.ConversationID, revision.MessageID, plaintext)
+		if err != nil {
+			return err
+		}
+		revision.Content = encrypted
+		defer func() { revision.Content = plaintext }()
+	}
+	return s.withEncrypted(msg, func() error {
+		return s.Store.UpdateMessage(msg, expectedVersion, revision)
+	})
+}
+
+func (s *EncryptingStore) GetMessage(id string) (*models.Message, error) {
+	msg, err := s.Store.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	return msg, s.decrypt(msg)
+}
+
+func (s *EncryptingStore) GetMessagesByIDs(ids []string) ([]models.Message, error) {
+	return s.decryptAll(s.Store.GetMessagesByIDs(ids))
+}
+
+func (s *EncryptingStore) ListMessages(conversationID uint, offset, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListMessages(conversationID, offset, limit))
+}
+
+func (s *EncryptingStore) ListMessagesVisibleTo(conversationID uint, viewer string, offset, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListMessagesVisibleTo(conversationID, viewer, offset, limit))
+}
+
+func (s *EncryptingStore) ListReplies(conversationID uint, messageID string, offset, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListReplies(conversationID, messageID, offset, limit))
+}
+
+func (s *EncryptingStore) GetLastMessages(conversationIDs []uint) ([]models.Message, error) {
+	return s.decryptAll(s.Store.GetLastMessages(conversationIDs))
+}
+
+// DeleteExpiredMessages is not decrypted, the sweeper only needs the IDs and attachments
+func (s *EncryptingStore) DeleteExpiredMessages(conversationID uint, before time.Time, limit int) ([]models.Message, error) {
+	return s.Store.DeleteExpiredMessages(conversationID, before, limit)
+}
+
+func (s *EncryptingStore) DeleteMessage(id, deletedBy, reason string) (*models.Message, error) {
+	msg, err := s.Store.DeleteMessage(id, deletedBy, reason)
+	if err != nil {
+		return nil, err
+	}
+	return msg, s.decrypt(msg)
+}
+
+func (s *EncryptingStore) ListConversationHistory(conversationID uint, offset, limit int) ([]repository.HistoryEntry, error) {
+	history, err := s.Store.ListConversationHistory(conversationID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		if err := s.decrypt(entry.Message); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
+
+func (s *EncryptingStore) ListQuarantinedMessages(offset, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListQuarantinedMessages(offset, limit))
+}
+
+func (s *EncryptingStore) ListMessagesAfter(conversationID uint, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListMessagesAfter(conversationID, afterCreatedAt, afterID, limit))
+}
+
+func (s *EncryptingStore) ListMessagesBefore(conversationID uint, beforeCreatedAt time.Time, beforeID string, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListMessagesBefore(conversationID, beforeCreatedAt, beforeID, limit))
+}
+
+func (s *EncryptingStore) ListUserMessages(username string, afterCreatedAt time.Time, afterID string, limit int) ([]models.Message, error) {
+	return s.decryptAll(s.Store.ListUserMessages(username, afterCreatedAt, afterID, limit))
+}
+
+func (s *EncryptingStore) ListRevisionsForMessages(messageIDs []string) ([]models.MessageRevision, error) {
+	return s.decryptRevisions(s.Store.ListRevisionsForMessages(messageIDs))
+}
+
+func (s *EncryptingStore) ListRevisions(messageID string) ([]models.MessageRevision, error) {
+	return s.decryptRevisions(s.Store.ListRevisions(messageID))
+}
+
+func (s *EncryptingStore) decryptRevisions(revisions []models.MessageRevision, err error) ([]models.MessageRevision, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		revisions[i].Content, err = s.Cipher.Decrypt(revisions[i].MessageID, revisions[i].Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revisions, nil
+}
+
+func (s *EncryptingStore) ListPins(conversationID uint) ([]models.Pin, error) {
+	pins, err := s.Store.ListPins(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pins {
+		if err := s.decrypt(pins[i].Message); err != nil {
+			return nil, err
+		}
+	}
+	return pins, nil
+}
+
+func (s *EncryptingStore) ListMentions(username string, offset, limit int) ([]models.Mention, error) {
+	mentions, err := s.Store.ListMentions(username, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mentions {
+		if err := s.decrypt(mentions[i].Message); err != nil {
+			return nil, err
+		}
+	}
+	return mentions, nil
+}
+
+// CreateReport encrypts the copied messages like the messages themselves, the caller's
+// report keeps its plaintext
+func (s *EncryptingStore) CreateReport(report *models.Report) error {
+	encrypted := *report
+	encrypted.Context = make([]models.MessagePreview, len(report.Context))
+	copy(encrypted.Context, report.Context)
+	previews := append([]*models.MessagePreview{&encrypted.Snapshot}, contextOf(&encrypted)...)
+	for _, preview := range previews {
+		content, err := s.Cipher.Encrypt(report.ConversationID, preview.ID, preview.Content)
+		if err != nil {
+			return err
+		}
+		preview.Content = content
+	}
+	if err := s.Store.CreateReport(&encrypted); err != nil {
+		return err
+	}
+	report.CreatedAt, report.UpdatedAt = encrypted.CreatedAt, encrypted.UpdatedAt
+	return nil
+}
+
+func (s *EncryptingStore) GetReport(id string) (*models.Report, error) {
+	report, err := s.Store.GetReport(id)
+	if err != nil {
+		return nil, err
+	}
+	return report, s.decryptReport(report)
+}
+
+func (s *EncryptingStore) ListReports(status constants.ReportStatus, offset, limit int) ([]models.Report, error) {
+	reports, err := s.Store.ListReports(status, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reports {
+		if err := s.decryptReport(&reports[i]); err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+func (s *EncryptingStore) ListUserRecords(username string) (*repository.UserRecords, error) {
+	records, err := s.Store.ListUserRecords(username)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records.Reports {
+		if err := s.decryptReport(&records.Reports[i]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.decryptDrafts(records.Drafts, nil); err != nil {
+		return nil, err
+	}
+	if _, err := s.decryptAllScheduled(records.ScheduledMessages, nil); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SaveDraft leaves empty drafts as they are, the store tells drafts with content
+// from cleared ones by their content
+func (s *EncryptingStore) SaveDraft(draft *models.Draft, expectedVersion uint) error {
+	if draft.Content == "" {
+		return s.Store.SaveDraft(draft, expectedVersion)
+	}
+	return s.withSealed(draft.ConversationID, draftLabel(draft), &draft.Content, func() error {
+		return s.Store.SaveDraft(draft, expectedVersion)
+	})
+}
+
+func (s *EncryptingStore) GetDraft(conversationID uint, username string) (*models.Draft, error) {
+	draft, err := s.Store.GetDraft(conversationID, username)
+	if err != nil {
+		return nil, err
+	}
+	draft.Content, err = s.Cipher.Decrypt(draftLabel(draft), draft.Content)
+	if err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func (s *EncryptingStore) ListDrafts(username string, conversationIDs []uint) ([]models.Draft, error) {
+	return s.decryptDrafts(s.Store.ListDrafts(username, conversationIDs))
+}
+
+// draftLabel binds the draft content to its conversation and user, drafts are saved
+// without an ID of their own
+func draftLabel(draft *models.Draft) string {
+	return fmt.Sprintf("draft:%d:%s", draft.ConversationID, draft.Username)
+}
+
+func (s *EncryptingStore) decryptDrafts(drafts []models.Draft, err error) ([]models.Draft, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range drafts {
+		drafts[i].Content, err = s.Cipher.Decrypt(draftLabel(&drafts[i]), drafts[i].Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return drafts, nil
+}
+
+// CreateScheduledMessage encrypts with the key of the conversation the message will be
+// sent to, the conversation is created here when it does not exist yet
+func (s *EncryptingStore) CreateScheduledMessage(scheduled *models.ScheduledMessage) error {
+	return s.withScheduledSealed(scheduled, func() error {
+		return s.Store.CreateScheduledMessage(scheduled)
+	})
+}
+
+func (s *EncryptingStore) UpdateScheduledMessage(scheduled *models.ScheduledMessage) error {
+	return s.withScheduledSealed(scheduled, func() error {
+		return s.Store.UpdateScheduledMessage(scheduled)
+	})
+}
+
+func (s *EncryptingStore) GetScheduledMessage(id string) (*models.ScheduledMessage, error) {
+	scheduled, err := s.Store.GetScheduledMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	scheduled.Content, err = s.Cipher.Decrypt(scheduled.ID, scheduled.Content)
+	if err != nil {
+		return nil, err
+	}
+	return scheduled, nil
+}
+
+func (s *EncryptingStore) ListScheduledMessages(sender string, status constants.ScheduledStatus) ([]models.ScheduledMessage, error) {
+	return s.decryptAllScheduled(s.Store.ListScheduledMessages(sender, status))
+}
+
+func (s *EncryptingStore) ClaimDueScheduledMessages(now, leaseUntil time.Time, limit int) ([]models.ScheduledMessage, error) {
+	return s.decryptAllScheduled(s.Store.ClaimDueScheduledMessages(now, leaseUntil, limit))
+}
+
+func (s *EncryptingStore) withScheduledSealed(scheduled *models.ScheduledMessage, save func() error) error {
+	participants := []string{scheduled.Sender, scheduled.Receiver}
+	conv, err := s.Store.FindConversation(participants)
+	if errors.Is(err, repository.ErrNotFound) {
+		conv, err = s.Store.CreateConversation(participants)
+	}
+	if err != nil {
+		return err
+	}
+	return s.withSealed(conv.ID, scheduled.ID, &scheduled.Content, save)
+}
+
+func (s *EncryptingStore) decryptAllScheduled(scheduled []models.ScheduledMessage, err error) ([]models.ScheduledMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range scheduled {
+		scheduled[i].Content, err = s.Cipher.Decrypt(scheduled[i].ID, scheduled[i].Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scheduled, nil
+}
+
+func (s *EncryptingStore) decryptReport(report *models.Report) error {
+	previews := append([]*models.MessagePreview{&report.Snapshot}, contextOf(report)...)
+	for _, preview := range previews {
+		content, err := s.Cipher.Decrypt(preview.ID, preview.Content)
+		if err != nil {
+			return err
+		}
+		preview.Content = content
+	}
+	return nil
+}
+
+func contextOf(report *models.Report) []*models.MessagePreview {
+	previews := make([]*models.MessagePreview, len(report.Context))
+	for i := range report.Context {
+		previews[i] = &report.Context[i]
+	}
+	return previews
+}
+
+func (s *EncryptingStore) withEncrypted(msg *models.Message, save func() error) error {
+	return s.withSealed(msg.ConversationID, msg.ID, &msg.Content, save)
+}
+
+// withSealed encrypts the content for the duration of save, binding it to the label
+func (s *EncryptingStore) withSealed(conversationID uint, label string, content *string, save func() error) error {
+	plaintext := *content
+	encrypted, err := s.Cipher.Encrypt(conversationID, label, plaintext)
+	if err != nil {
+		return err
+	}
+	*content = encrypted
+	defer func() { *content = plaintext }()
+	return save()
+}
+
+func (s *EncryptingStore) decrypt(msg *models.Message) error {
+	if msg == nil {
+		return nil
+	}
+	content, err := s.Cipher.Decrypt(msg.ID, msg.Content)
+	if err != nil {
+		return err
+	}
+	msg.Content = content
+	return nil
+}
+
+func (s *EncryptingStore) decryptAll(messages []models.Message, err error) ([]models.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		if err := s.decrypt(&messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// DecryptingArchive decrypts the messages read back from archived partitions, they
+// were archived as they were stored
+type DecryptingArchive struct {
+	Archive MessageArchive
+	Cipher  *encryption.ContentCipher
+}
+
+func NewDecryptingArchive(archive MessageArchive, cipher *encryption.ContentCipher) MessageArchive {
+	if cipher == nil {
+		return archive
+	}
+	return &DecryptingArchive{
+		Archive: archive,
+		Cipher:  cipher,
+	}
+}
+
+// ReadMessages leaves keep to look at the encrypted messages, it only needs their metadata
+func (a *DecryptingArchive) ReadMessages(conversationID uint, offset, limit int, keep func(*models.Message) bool) ([]models.Message, error) {
+	messages, err := a.Archive.ReadMessages(conversationID, offset, limit, keep)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Content, err = a.Cipher.Decrypt(messages[i].ID, messages[i].Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+func (a *DecryptingArchive) EachMessage(conversationID uint, fn func(*models.Message) error) error {
+	return a.Archive.EachMessage(conversationID, func(msg *models.Message) error {
+		content, err := a.Cipher.Decrypt(msg.ID, msg.Content)
+		if err != nil {
+			return err
+		}
+		msg.Content = content
+		return fn(msg)
+	})
+}
+
+func (a *DecryptingArchive) Walk(fn func(*models.Message) error) error {
+	return a.Archive.Walk(func(msg *models.Message) error {
+		content, err := a.Cipher.Decrypt(msg.ID, msg.Content)
+		if err != nil {
+			return err
+		}
+		msg.Content = content
+		return fn(msg)
+	})
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+const (
+	ErasureSourceEvent = "event"
+	ErasureSourceAdmin = "admin"
+)
+
+type ErasureServiceInterface interface {
+	EraseUser(username, source, requestedBy string) (*models.ErasureRecord, error)
+	GetErasure(id string) (*models.ErasureRecord, error)
+	ExportUserData(username string, w io.Writer) error
+}
+
+// ArchiveRewriter edits the archived partitions in place
+type ArchiveRewriter interface {
+	Rewrite(fn func(*models.Message) bool) (int, error)
+}
+
+// ErasureService erases a deleted user's data and exports everything held about a
+// user for subject access requests
+type ErasureService struct {
+	Store           repository.Store
+	Blobs           blobstore.BlobStore
+	Publisher       EventPublisher
+	Archive         MessageArchive
+	ArchiveRewriter ArchiveRewriter
+}
+
+func NewErasureService(store repository.Store, blobs blobstore.BlobStore, publisher EventPublisher) *ErasureService {
+	return &ErasureService{
+		Store:     store,
+		Blobs:     blobs,
+		Publisher: publisher,
+	}
+}
+
+// EraseUser redacts what the user sent and replaces them with a pseudonym everywhere
+// else, in the database and in the archives. It is safe to run again, an erasure
+// that failed is retried with the same record and pseudonym
+func (srv *ErasureService) EraseUser(username, source, requestedBy string) (*models.ErasureRecord, error) {
+	if strings.TrimSpace(username) == "" {
+		return nil, ErrInvalidErasure
+	}
+
+	subjectHash := models.SubjectHash(username)
+	record, err := srv.Store.FindUnfinishedErasure(subjectHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		record = &models.ErasureRecord{
+			ID:          uuid.NewString(),
+			SubjectHash: subjectHash,
+			Pseudonym:   "deleted-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12],
+			Source:      source,
+			RequestedBy: requestedBy,
+			RequestedAt: time.Now(),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	record.Status = constants.ErasurePending
+	record.Error = ""
+	if err := srv.Store.SaveErasureRecord(record); err != nil {
+		return nil, err
+	}
+
+	if err := srv.erase(username, record); err != nil {
+		record.Status = constants.ErasureFailed
+		record.Error = err.Error()
+		if saveErr := srv.Store.SaveErasureRecord(record); saveErr != nil {
+			log.Printf("error saving failed erasure %v: %v\n", record.ID, saveErr)
+		}
+		return record, err
+	}
+
+	completedAt := time.Now()
+	record.Status = constants.ErasureCompleted
+	record.CompletedAt = &completedAt
+	if err := srv.Store.SaveErasureRecord(record); err != nil {
+		return record, err
+	}
+	srv.publishErasure(record)
+
+	return record, nil
+}
+
+func (srv *ErasureService) erase(username string, record *models.ErasureRecord) error {
+	result, err := srv.Store.EraseUser(username, record.Pseudonym)
+	if err != nil {
+		return err
+	}
+	// retries add what the previous attempts left over
+	record.MessagesRedacted += result.MessagesRedacted
+	record.MessagesPseudonymized += result.MessagesPseudonymized
+	record.ConversationsUpdated += result.ConversationsUpdated
+	record.AttachmentsDeleted += result.AttachmentsDeleted
+
+	if srv.Blobs != nil {
+		for _, storageKey := range result.StorageKeys {
+			deleteBlob(context.Background(), srv.Store, srv.Blobs, storageKey)
+		}
+	}
+
+	if srv.ArchiveRewriter != nil {
+		rewritten, err := srv.ArchiveRewriter.Rewrite(func(msg *models.Message) bool {
+			return pseudonymize(msg, username, record.Pseudonym)
+		})
+		record.ArchivedMessages += int64(rewritten)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pseudonymize applies the erasure to an archived message and tells whether it changed
+func pseudonymize(msg *models.Message, username, pseudonym string) bool {
+	changed := false
+	if msg.Sender == username {
+		msg.Sender = pseudonym
+		msg.Content = ""
+		msg.Redacted = true
+		changed = true
+	}
+	if msg.Receiver == username {
+		msg.Receiver = pseudonym
+		changed = true
+	}
+	if msg.ForwardedFrom == username {
+		msg.ForwardedFrom = pseudonym
+		changed = true
+	}
+	return changed
+}
+
+// publishErasure lets the other services drop the user too, failures are only logged
+func (srv *ErasureService) publishErasure(record *models.ErasureRecord) {
+	if srv.Publisher == nil {
+		return
+	}
+	event := models.UserErasedEvent{
+		ErasureID:   record.ID,
+		SubjectHash: record.SubjectHash,
+		Pseudonym:   record.Pseudonym,
+	}
+	if err := srv.Publisher.Publish(constants.UserEventsExchange, constants.UserErasedKey, event); err != nil {
+		log.Printf("error publishing erasure %v: %v\n", record.ID, err)
+	}
+}
+
+func (srv *ErasureService) GetErasure(id string) (*models.ErasureRecord, error) {
+	return srv.Store.GetErasureRecord(id)
+}
+
+// ExportUserData streams everything held about the user as one JSON document, the
+// messages they sent or received are written a page at a time after the rest
+func (srv *ErasureService) ExportUserData(username string, w io.Writer) error {
+	if strings.TrimSpace(username) == "" {
+		return ErrInvalidErasure
+	}
+
+	now := time.Now()
+	var conversations []models.Conversation
+	for offset := 0; ; offset += EXPORT_PAGE_SIZE {
+		page, err := srv.Store.ListConversations(username, repository.InboxFilter{Now: now}, offset, EXPORT_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		conversations = append(conversations, page...)
+		if len(page) < EXPORT_PAGE_SIZE {
+			break
+		}
+	}
+	records, err := srv.Store.ListUserRecords(username)
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(struct {
+		Username      string                  `json:"username"`
+		GeneratedAt   time.Time               `json:"generatedAt"`
+		Conversations []models.Conversation   `json:"conversations"`
+		Records       *repository.UserRecords `json:"records"`
+	}{username, now, conversations, records})
+	if err != nil {
+		return err
+	}
+	// the header object is left open to append the messages to it
+	if _, err := fmt.Fprintf(w, "%s,\"messages\":[", header[:len(header)-1]); err != nil {
+		return err
+	}
+
+	written := 0
+	writePage := func(page []models.Message) error {
+		if len(page) == 0 {
+			return nil
+		}
+		ids := make([]string, len(page))
+		for i, msg := range page {
+			ids[i] = msg.ID
+		}
+		revisions, err := srv.Store.ListRevisionsForMessages(ids)
+		if err != nil {
+			return err
+		}
+		byMessage := make(map[string][]models.MessageRevision)
+		for _, revision := range revisions {
+			byMessage[revision.MessageID] = append(byMessage[revision.MessageID], revision)
+		}
+
+		for i := range page {
+			encoded, err := json.Marshal(ExportedMessage{Message: page[i], Revisions: byMessage[page[i].ID]})
+			if err != nil {
+				return err
+			}
+			if written > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			written++
+			if _, err := w.Write(encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if srv.Archive != nil {
+		page := make([]models.Message, 0, EXPORT_PAGE_SIZE)
+		err := srv.Archive.Walk(func(msg *models.Message) error {
+			if msg.Sender != username && msg.Receiver != username {
+				return nil
+			}
+			page = append(page, *msg)
+			if len(page) < EXPORT_PAGE_SIZE {
+				return nil
+			}
+			err := writePage(page)
+			page = page[:0]
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := writePage(page); err != nil {
+			return err
+		}
+	}
+
+	var afterCreatedAt time.Time
+	afterID := ""
+	for {
+		page, err := srv.Store.ListUserMessages(username, afterCreatedAt, afterID, EXPORT_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		if err := writePage(page); err != nil {
+			return err
+		}
+		if len(page) < EXPORT_PAGE_SIZE {
+			break
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
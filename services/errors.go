@@ -0,0 +1,24 @@
+package services
+
+import "errors"
+
+var (
+	ErrForbidden           = errors.New("forbidden")
+	ErrInvalidRetention    = errors.New("invalid retention policy")
+	ErrPinLimitReached     = errors.New("pin limit reached")
+	ErrInvalidSchedule     = errors.New("invalid scheduled message")
+	ErrBlocked             = errors.New("the receiver blocked the sender")
+	ErrInvalidBlock        = errors.New("users can not block themselves")
+	ErrInvalidExportFormat = errors.New("export format must be json, csv or html")
+	ErrInvalidErasure      = errors.New("a username is required")
+	ErrRejected            = errors.New("the message was rejected by moderation")
+	ErrThrottled           = errors.New("the sender is sending too many messages")
+	ErrInvalidReport       = errors.New("reports need a reason of at most 1000 characters and can not be about the reporter's own messages")
+	ErrInvalidReportAction = errors.New("action must be dismiss, delete, warn or block")
+	ErrInvalidStatus       = errors.New("status must be message.sent, message.delivered or message.read")
+	ErrInvalidMerge        = errors.New("only other conversations with the same participants can be merged")
+	ErrInvalidEvent        = errors.New("the event can not be emitted for this message")
+	ErrInvalidReply        = errors.New("replies must be to a message of the same conversation the sender can see")
+	ErrRedacted            = errors.New("the message was redacted and can not be edited")
+	ErrNotSender           = errors.New("only the sender can edit the content of their message")
+)
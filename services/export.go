@@ -0,0 +1,322 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yonraz/gochat_messages/models"
+)
+
+var EXPORT_PAGE_SIZE = 200
+
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json"
+	ExportCSV  ExportFormat = "csv"
+	ExportHTML ExportFormat = "html"
+)
+
+// ContentType is the media type the export is served with
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(strings.ToLower(format)) {
+	case "", ExportJSON:
+		return ExportJSON, nil
+	case ExportCSV:
+		return ExportCSV, nil
+	case ExportHTML:
+		return ExportHTML, nil
+	}
+	return "", ErrInvalidExportFormat
+}
+
+// ExportedMessage is a message of the transcript along with its previous versions
+type ExportedMessage struct {
+	models.Message
+	Revisions []models.MessageRevision `json:"revisions,omitempty"`
+}
+
+// transcriptWriter writes one export format, entries arrive oldest first
+type transcriptWriter interface {
+	begin(conv *models.Conversation) error
+	write(entry *ExportedMessage) error
+	end() error
+}
+
+// ExportConversation streams the whole history of the conversation to w, the archived
+// history first and then the database, one page at a time. An empty viewer is an admin
+// export that skips the participant check and keeps the messages of blocked users.
+// Nothing is written when the checks fail
+func (srv *MessagesService) ExportConversation(conversationID uint, viewer string, format ExportFormat, w io.Writer) error {
+	conv, err := srv.Store.GetConversationByID(conversationID)
+	if err != nil {
+		return err
+	}
+	if viewer != "" && !hasParticipant(conv, viewer) {
+		return ErrForbidden
+	}
+
+	out := newTranscriptWriter(format, w)
+	if err := out.begin(conv); err != nil {
+		return err
+	}
+
+	if srv.Archive != nil {
+		now := time.Now()
+		page := make([]models.Message, 0, EXPORT_PAGE_SIZE)
+		err := srv.Archive.EachMessage(conv.ID, func(msg *models.Message) error {
+			if expired(conv, msg.CreatedAt, now) {
+				return nil
+			}
+			page = append(page, *msg)
+			if len(page) < EXPORT_PAGE_SIZE {
+				return nil
+			}
+			err := srv.exportPage(out, page, viewer)
+			page = page[:0]
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := srv.exportPage(out, page, viewer); err != nil {
+			return err
+		}
+	}
+
+	var afterCreatedAt time.Time
+	afterID := ""
+	for {
+		page, err := srv.Store.ListMessagesAfter(conv.ID, afterCreatedAt, afterID, EXPORT_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+
+		if err := srv.exportPage(out, page, viewer); err != nil {
+			return err
+		}
+		if len(page) < EXPORT_PAGE_SIZE {
+			break
+		}
+	}
+
+	return out.end()
+}
+
+// exportPage loads the reactions and revisions of a page in one query each
+func (srv *MessagesService) exportPage(out transcriptWriter, page []models.Message, viewer string) error {
+	if viewer != "" {
+		var err error
+		if page, err = srv.hideBlocked(hideQuarantined(page, viewer), viewer); err != nil {
+			return err
+		}
+	}
+	if len(page) == 0 {
+		return nil
+	}
+	if err := srv.attachReactions(page, viewer); err != nil {
+		return err
+	}
+
+	ids := make([]string, len(page))
+	for i, msg := range page {
+		ids[i] = msg.ID
+	}
+	revisions, err := srv.Store.ListRevisionsForMessages(ids)
+	if err != nil {
+		return err
+	}
+	byMessage := make(map[string][]models.MessageRevision)
+	for _, revision := range revisions {
+		byMessage[revision.MessageID] = append(byMessage[revision.MessageID], revision)
+	}
+
+	for i := range page {
+		entry := ExportedMessage{Message: page[i], Revisions: byMessage[page[i].ID]}
+		if err := out.write(&entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTranscriptWriter(format ExportFormat, w io.Writer) transcriptWriter {
+	switch format {
+	case ExportCSV:
+		return &csvTranscript{w: csv.NewWriter(w)}
+	case ExportHTML:
+		return &htmlTranscript{w: w}
+	default:
+		return &jsonTranscript{w: w}
+	}
+}
+
+// jsonTranscript writes {"conversation": ..., "messages": [...]} one message at a time
+type jsonTranscript struct {
+	w       io.Writer
+	written int
+}
+
+func (t *jsonTranscript) begin(conv *models.Conversation) error {
+	header, err := json.Marshal(struct {
+		ID           uint      `json:"id"`
+		Participants []string  `json:"participants"`
+		CreatedAt    time.Time `json:"createdAt"`
+	}{conv.ID, conv.Participants, conv.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(t.w, "{\"conversation\":%s,\"messages\":[", header)
+	return err
+}
+
+func (t *jsonTranscript) write(entry *ExportedMessage) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if t.written > 0 {
+		if _, err := io.WriteString(t.w, ","); err != nil {
+			return err
+		}
+	}
+	t.written++
+	_, err = t.w.Write(encoded)
+	return err
+}
+
+func (t *jsonTranscript) end() error {
+	_, err := io.WriteString(t.w, "]}\n")
+	return err
+}
+
+var csvHeader = []string{
+	"id", "created_at", "sender", "receiver", "content", "edited", "edited_at",
+	"reply_to_id", "forwarded_from", "reactions", "attachments", "revisions",
+}
+
+type csvTranscript struct {
+	w *csv.Writer
+}
+
+func (t *csvTranscript) begin(conv *models.Conversation) error {
+	return t.w.Write(csvHeader)
+}
+
+func (t *csvTranscript) write(entry *ExportedMessage) error {
+	editedAt := ""
+	if entry.EditedAt != nil {
+		editedAt = entry.EditedAt.UTC().Format(time.RFC3339)
+	}
+	replyTo := ""
+	if entry.ReplyToID != nil {
+		replyTo = *entry.ReplyToID
+	}
+
+	err := t.w.Write([]string{
+		entry.ID,
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		entry.Sender,
+		entry.Receiver,
+		entry.Content,
+		strconv.FormatBool(entry.Edited),
+		editedAt,
+		replyTo,
+		entry.ForwardedFrom,
+		formatReactions(entry.Reactions),
+		formatAttachments(entry.Attachments),
+		formatRevisions(entry.Revisions),
+	})
+	if err != nil {
+		return err
+	}
+	// flush every row so the rows go out as they are written
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTranscript) end() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func formatReactions(reactions []models.ReactionSummary) string {
+	parts := make([]string, len(reactions))
+	for i, reaction := range reactions {
+		parts[i] = fmt.Sprintf("%s:%d", reaction.Emoji, reaction.Count)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatAttachments(attachments []models.Attachment) string {
+	parts := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		parts[i] = fmt.Sprintf("%s:%s", attachment.ID, attachment.Filename)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatRevisions(revisions []models.MessageRevision) string {
+	parts := make([]string, len(revisions))
+	for i, revision := range revisions {
+		parts[i] = fmt.Sprintf("v%d %s: %s", revision.Version, revision.EditedAt.UTC().Format(time.RFC3339), revision.Content)
+	}
+	return strings.Join(parts, " | ")
+}
+
+var htmlTranscriptTemplates = template.Must(template.New("transcript").Parse(`{{define "head"}}<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Conversation {{.ID}}</title></head>
+<body>
+<h1>Conversation between {{range $i, $p := .Participants}}{{if $i}}, {{end}}{{$p}}{{end}}</h1>
+<table>
+<tr><th>Time</th><th>Sender</th><th>Message</th><th>Reactions</th><th>Attachments</th></tr>
+{{end}}{{define "row"}}<tr id="{{.ID}}">
+<td>{{.CreatedAt.UTC.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Sender}}</td>
+<td>{{if .ForwardedFrom}}<div>Forwarded from {{.ForwardedFrom}}</div>{{end}}{{if .ReplyToID}}<div>Reply to <a href="#{{.ReplyToID}}">message</a></div>{{end}}{{.Content}}{{if .Edited}} <em>(edited)</em>{{end}}{{if .Revisions}}<details><summary>Previous versions</summary><ol>{{range .Revisions}}<li>{{.EditedAt.UTC.Format "2006-01-02 15:04:05"}}: {{.Content}}</li>{{end}}</ol></details>{{end}}</td>
+<td>{{range .Reactions}}{{.Emoji}} {{.Count}} {{end}}</td>
+<td>{{range .Attachments}}<div>{{.Filename}} ({{.MimeType}}, {{.Size}} bytes)</div>{{end}}</td>
+</tr>
+{{end}}{{define "foot"}}</table>
+</body>
+</html>
+{{end}}`))
+
+type htmlTranscript struct {
+	w io.Writer
+}
+
+func (t *htmlTranscript) begin(conv *models.Conversation) error {
+	return htmlTranscriptTemplates.ExecuteTemplate(t.w, "head", conv)
+}
+
+func (t *htmlTranscript) write(entry *ExportedMessage) error {
+	return htmlTranscriptTemplates.ExecuteTemplate(t.w, "row", entry)
+}
+
+func (t *htmlTranscript) end() error {
+	return htmlTranscriptTemplates.ExecuteTemplate(t.w, "foot", nil)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+// FREQUENTLY_FORWARDED_THRESHOLD is how many times the original has to be forwarded
+// before new forwards of it are flagged
+var FREQUENTLY_FORWARDED_THRESHOLD = 5
+
+// ForwardMessage copies a message the user can see, attachments included, into
+// another conversation of the user and publishes it as message.sent
+func (srv *MessagesService) ForwardMessage(messageID, user string, conversationID uint) (*models.Message, error) {
+	source, err := srv.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.ensureVisible(source, user); err != nil {
+		return nil, err
+	}
+	target, err := srv.Store.GetConversationByID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasParticipant(target, user) {
+		return nil, ErrForbidden
+	}
+
+	original := source
+	if source.ForwardedFromID != nil {
+		original, err = srv.Store.GetMessage(*source.ForwardedFromID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+	}
+	forwardedFromID, forwardedFrom := source.ID, source.Sender
+	if source.ForwardedFromID != nil {
+		forwardedFromID, forwardedFrom = *source.ForwardedFromID, source.ForwardedFrom
+	}
+
+	now := time.Now()
+	msg := &models.Message{
+		ID:                  uuid.NewString(),
+		ConversationID:      target.ID,
+		Content:             source.Content,
+		Sender:              user,
+		Receiver:            otherParticipant(target, user),
+		Status:              constants.MessageSentKey,
+		Type:                constants.MessageCreate,
+		Sent:                true,
+		ForwardedFromID:     &forwardedFromID,
+		ForwardedFrom:       forwardedFrom,
+		FrequentlyForwarded: original != nil && original.ForwardCount+1 >= FREQUENTLY_FORWARDED_THRESHOLD,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+		Version:             1,
+	}
+	if err := srv.prepareMessage(msg); err != nil {
+		return nil, err
+	}
+
+	attachments, err := srv.Store.ListAttachments(source.ID)
+	if err != nil {
+		return nil, err
+	}
+	attachmentIDs := make([]string, len(attachments))
+	for i := range attachments {
+		attachments[i].ID = uuid.NewString()
+		attachments[i].CreatedAt = now
+		attachmentIDs[i] = attachments[i].ID
+	}
+
+	if err := srv.Store.ForwardMessage(msg, attachments); err != nil {
+		log.Printf("error forwarding message %v: %v\n", source.ID, err)
+		return nil, err
+	}
+	msg.Attachments = attachments
+
+	if msg.Quarantined {
+		srv.publishQuarantine(msg)
+		return msg, nil
+	}
+	srv.publishMentions(msg)
+	srv.publishSent(msg, attachmentIDs)
+	return msg, nil
+}
+
+// ensureVisible returns ErrForbidden when the user is not part of the message's
+// conversation, and ErrNotFound when the user blocked its sender before it was sent
+// or it is held for review
+func (srv *MessagesService) ensureVisible(msg *models.Message, user string) error {
+	conv, err := srv.Store.GetConversationByID(msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	if !hasParticipant(conv, user) {
+		return ErrForbidden
+	}
+	visible, err := srv.hideBlocked(hideQuarantined([]models.Message{*msg}, user), user)
+	if err != nil {
+		return err
+	}
+	if len(visible) == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// publishSent sends a message that was saved here, such as a forward, to the websocket
+// service like any new message. The sent consumer skips it since it is already saved
+func (srv *MessagesService) publishSent(msg *models.Message, attachmentIDs []string) {
+	if srv.Publisher == nil {
+		return
+	}
+	if err := srv.Publisher.Publish(constants.MessageEventsExchange, constants.MessageSentKey, srv.sentEvent(msg, attachmentIDs)); err != nil {
+		log.Printf("error publishing message %v: %v\n", msg.ID, err)
+	}
+}
+
+func (srv *MessagesService) sentEvent(msg *models.Message, attachmentIDs []string) models.WsMessage {
+	event := models.WsMessage{
+		ID:              msg.ID,
+		Content:         msg.Content,
+		Sender:          msg.Sender,
+		Receiver:        msg.Receiver,
+		Status:          msg.Status,
+		Type:            msg.Type,
+		Sent:            msg.Sent,
+		ReplyToID:       msg.ReplyToID,
+		Attachments:     attachmentIDs,
+		ForwardedFromID: msg.ForwardedFromID,
+		ForwardedFrom:   msg.ForwardedFrom,
+		CreatedAt:       msg.CreatedAt,
+		UpdatedAt:       msg.UpdatedAt,
+	}
+	muted, err := srv.Store.ListMutedParticipants(msg.ConversationID, msg.CreatedAt)
+	if err != nil {
+		log.Printf("error loading muted participants of conversation %v: %v\n", msg.ConversationID, err)
+	}
+	event.MutedBy = muted
+	return event
+}
+
+func hasParticipant(conv *models.Conversation, user string) bool {
+	for _, participant := range conv.Participants {
+		if participant == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+var IMPORT_BATCH_SIZE = 500
+
+// IMPORT_MAX_REPORTED_REJECTIONS bounds the rejections kept in the report, all of
+// them are counted and passed to OnReject
+var IMPORT_MAX_REPORTED_REJECTIONS = 1000
+
+const importMaxLineSize = 4 * 1024 * 1024
+
+type ImporterInterface interface {
+	Import(r io.Reader, opts ImportOptions) (*ImportReport, error)
+}
+
+type ImportOptions struct {
+	// StartLine is the checkpoint of a previous run, lines up to it are skipped
+	StartLine int
+	// Checkpoint is called with the last line once everything before it is saved
+	Checkpoint func(line int) error
+	// OnReject is called for every rejected line
+	OnReject func(rejection ImportRejection)
+}
+
+type ImportRejection struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type ImportReport struct {
+	Imported int `json:"imported"`
+	// Skipped counts the messages that already existed
+	Skipped  int               `json:"skipped"`
+	Rejected int               `json:"rejected"`
+	LastLine int               `json:"lastLine"`
+	Errors   []ImportRejection `json:"errors"`
+}
+
+// Importer loads JSONL archives of messages from the legacy chat, one message per
+// line. IDs and timestamps are kept and conversations are resolved by participants.
+// Messages are saved as history: mentions are not parsed, blocks are not applied and
+// no events are published. Forward counts are recounted from the stored forwards, and
+// quarantined or redacted records are rejected since this service holds no moderation
+// decision or erasure behind them
+type Importer struct {
+	Messages *MessagesService
+}
+
+func NewImporter(srv *MessagesService) *Importer {
+	return &Importer{
+		Messages: srv,
+	}
+}
+
+type importedLine struct {
+	line int
+	msg  models.Message
+}
+
+func (imp *Importer) Import(r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{LastLine: opts.StartLine, Errors: []ImportRejection{}}
+	conversations := make(map[string]uint)
+	batch := make([]importedLine, 0, IMPORT_BATCH_SIZE)
+
+	reject := func(line int, id string, reason string) {
+		rejection := ImportRejection{Line: line, ID: id, Reason: reason}
+		report.Rejected++
+		if len(report.Errors) < IMPORT_MAX_REPORTED_REJECTIONS {
+			report.Errors = append(report.Errors, rejection)
+		}
+		if opts.OnReject != nil {
+			opts.OnReject(rejection)
+		}
+	}
+
+	flush := func(lastLine int) error {
+		if len(batch) > 0 {
+			messages := make([]models.Message, len(batch))
+			for i, entry := range batch {
+				messages[i] = entry.msg
+			}
+			inserted, err := imp.Messages.Store.ImportMessages(messages)
+			if err != nil {
+				return fmt.Errorf("failed to import lines %d to %d: %w", batch[0].line, batch[len(batch)-1].line, err)
+			}
+			report.Imported += inserted
+			report.Skipped += len(batch) - inserted
+			batch = batch[:0]
+		}
+		if lastLine == report.LastLine {
+			return nil
+		}
+		report.LastLine = lastLine
+		if opts.Checkpoint != nil {
+			return opts.Checkpoint(lastLine)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line <= opts.StartLine {
+			continue
+		}
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		msg, err := parseImportedMessage(raw)
+		if err != nil {
+			reject(line, msg.ID, err.Error())
+			continue
+		}
+
+		key := models.CanonicalKey([]string{msg.Sender, msg.Receiver})
+		conversationID, ok := conversations[key]
+		if !ok {
+			conv, err := imp.Messages.GetConversation(msg.Sender, msg.Receiver)
+			if err != nil {
+				return report, err
+			}
+			conversationID = conv.ID
+			conversations[key] = conversationID
+		}
+		msg.ConversationID = conversationID
+
+		batch = append(batch, importedLine{line: line, msg: *msg})
+		if len(batch) >= IMPORT_BATCH_SIZE {
+			if err := flush(line); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// the lines before the broken one are still saved
+		if flushErr := flush(line); flushErr != nil {
+			return report, flushErr
+		}
+		return report, fmt.Errorf("failed to read line %d: %w", line+1, err)
+	}
+
+	return report, flush(line)
+}
+
+// parseImportedMessage validates a record against the message shape, rejected records
+// still return a message with the ID when there is one so that rejections can name it
+func parseImportedMessage(raw []byte) (*models.Message, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var msg models.Message
+	if err := decoder.Decode(&msg); err != nil {
+		var named struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(raw, &named)
+		return &models.Message{ID: named.ID}, fmt.Errorf("invalid record: %w", err)
+	}
+	if decoder.More() {
+		return &msg, errors.New("invalid record: more than one value on the line")
+	}
+
+	if _, err := uuid.Parse(msg.ID); err != nil {
+		return &msg, errors.New("id must be a uuid")
+	}
+	if msg.Sender == "" || msg.Receiver == "" {
+		return &msg, errors.New("sender and receiver are required")
+	}
+	if msg.Sender == msg.Receiver {
+		return &msg, errors.New("sender and receiver must differ")
+	}
+	if msg.Content == "" {
+		return &msg, errors.New("content is required")
+	}
+	if msg.CreatedAt.IsZero() {
+		return &msg, errors.New("createdAt is required")
+	}
+	if msg.CreatedAt.After(time.Now().Add(time.Minute)) {
+		return &msg, errors.New("createdAt is in the future")
+	}
+	if len(msg.Attachments) > 0 {
+		return &msg, errors.New("attachments can not be imported")
+	}
+	if msg.Quarantined {
+		return &msg, errors.New("quarantined messages can not be imported")
+	}
+	if msg.Redacted {
+		return &msg, errors.New("redacted messages can not be imported")
+	}
+	if msg.ReplyToID != nil {
+		if _, err := uuid.Parse(*msg.ReplyToID); err != nil {
+			return &msg, errors.New("replyToId must be a uuid")
+		}
+	}
+	if msg.ForwardedFromID != nil {
+		if _, err := uuid.Parse(*msg.ForwardedFromID); err != nil {
+			return &msg, errors.New("forwardedFromId must be a uuid")
+		}
+	}
+
+	switch msg.Type {
+	case "":
+		msg.Type = constants.MessageCreate
+	case constants.MessageCreate, constants.MessageSystem:
+	default:
+		return &msg, fmt.Errorf("unknown type %v", msg.Type)
+	}
+	switch msg.Status {
+	case "":
+		msg.Status = constants.MessageSentKey
+	case constants.MessageSentKey, constants.MessageDeliveredKey, constants.MessageReadKey:
+	default:
+		return &msg, fmt.Errorf("unknown status %v", msg.Status)
+	}
+
+	// the legacy conversation IDs mean nothing here, and derived fields are rebuilt
+	msg.ConversationID = 0
+	msg.Sent = true
+	msg.Read = msg.Read || msg.Status == constants.MessageReadKey
+	msg.Reactions = nil
+	msg.ReplyTo = nil
+	msg.SeenBy = nil
+	msg.Mentions = nil
+	msg.ForwardCount = 0
+	msg.FrequentlyForwarded = false
+	if msg.UpdatedAt.IsZero() {
+		msg.UpdatedAt = msg.CreatedAt
+	}
+	if msg.Version == 0 {
+		msg.Version = 1
+	}
+	return &msg, nil
+}
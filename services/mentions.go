@@ -0,0 +1,104 @@
+package services
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+)
+
+// the @ must not follow a word character so that email addresses are not mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// parseMentions returns the participants named in the content, without the sender
+// and in the order they first appear
+func parseMentions(content, sender string, participants []string) []string {
+	known := make(map[string]bool, len(participants))
+	for _, participant := range participants {
+		known[participant] = true
+	}
+
+	var mentioned []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// trailing punctuation such as "@foo." ends the sentence, not the name
+		username := strings.TrimRight(match[1], ".-")
+		if !known[username] || username == sender || seen[username] {
+			continue
+		}
+		seen[username] = true
+		mentioned = append(mentioned, username)
+	}
+	return mentioned
+}
+
+// attachMentions fills msg.Mentions so they are saved along with the message,
+// participants who blocked the sender are not notified of it
+func (srv *MessagesService) attachMentions(msg *models.Message, conv *models.Conversation, blockers []string) {
+	msg.Mentions = nil
+	if !strings.Contains(msg.Content, "@") {
+		return
+	}
+
+	blocked := make(map[string]bool, len(blockers))
+	for _, blocker := range blockers {
+		blocked[blocker] = true
+	}
+	for _, username := range parseMentions(msg.Content, msg.Sender, conv.Participants) {
+		if blocked[username] {
+			continue
+		}
+		msg.Mentions = append(msg.Mentions, models.Mention{
+			MessageID:      msg.ID,
+			ConversationID: msg.ConversationID,
+			Username:       username,
+			Sender:         msg.Sender,
+			CreatedAt:      msg.CreatedAt,
+		})
+	}
+}
+
+// publishMentions only logs failures, the message itself is already saved
+func (srv *MessagesService) publishMentions(msg *models.Message) {
+	if srv.Publisher == nil || len(msg.Mentions) == 0 {
+		return
+	}
+	muted, err := srv.Store.ListMutedParticipants(msg.ConversationID, time.Now())
+	if err != nil {
+		log.Printf("error loading muted participants of conversation %v: %v\n", msg.ConversationID, err)
+		return
+	}
+	isMuted := make(map[string]bool, len(muted))
+	for _, username := range muted {
+		isMuted[username] = true
+	}
+
+	event := models.MentionEvent{
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		Sender:         msg.Sender,
+	}
+	for _, mention := range msg.Mentions {
+		// the mention is still stored, muting only silences the notification
+		if !isMuted[mention.Username] {
+			event.Mentioned = append(event.Mentioned, mention.Username)
+		}
+	}
+	if len(event.Mentioned) == 0 {
+		return
+	}
+	if err := srv.Publisher.Publish(constants.MessageEventsExchange, constants.MessageMentionedKey, event); err != nil {
+		log.Printf("error publishing mentions of message %v: %v\n", msg.ID, err)
+	}
+}
+
+func (srv *MessagesService) GetMentions(username string, offset int) ([]models.Mention, error) {
+	return srv.Store.ListMentions(username, offset, MESSAGE_PAGINATION_SIZE)
+}
+
+func (srv *MessagesService) CountUnreadMentions(username string) ([]models.MentionCount, error) {
+	return srv.Store.CountUnreadMentions(username)
+}
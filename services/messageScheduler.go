@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var SCHEDULER_BATCH_SIZE = 100
+var SCHEDULER_LEASE = time.Minute
+var SCHEDULED_MAX_ATTEMPTS = 5
+
+// MessageScheduler sends the scheduled messages that are due. Saving the message and
+// marking it sent happen in one transaction and the message reuses the scheduled ID,
+// so it is persisted exactly once no matter how many replicas run the scheduler.
+// Publishing happens afterwards and is retried until it is recorded, consumers of
+// message.sent see the same message ID on every attempt.
+type MessageScheduler struct {
+	Messages  *MessagesService
+	Publisher EventPublisher
+}
+
+func NewMessageScheduler(messages *MessagesService, publisher EventPublisher) *MessageScheduler {
+	return &MessageScheduler{
+		Messages:  messages,
+		Publisher: publisher,
+	}
+}
+
+func (s *MessageScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(time.Now()); err != nil {
+			log.Printf("error sending scheduled messages: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *MessageScheduler) Tick(now time.Time) error {
+	due, err := s.Messages.Store.ClaimDueScheduledMessages(now, now.Add(SCHEDULER_LEASE), SCHEDULER_BATCH_SIZE)
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		// on error the lease runs out and the next tick tries again
+		if err := s.fire(&due[i], now); err != nil {
+			log.Printf("error sending scheduled message %v: %v\n", due[i].ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *MessageScheduler) fire(scheduled *models.ScheduledMessage, now time.Time) error {
+	var msg *models.Message
+	var err error
+	if scheduled.Status == constants.ScheduledPending {
+		msg, err = s.persist(scheduled)
+		if errors.Is(err, repository.ErrNotFound) {
+			// cancelled or sent by another replica in the meantime
+			return nil
+		} else if errors.Is(err, ErrBlocked) || errors.Is(err, ErrRejected) || errors.Is(err, ErrInvalidReply) ||
+			errors.Is(err, ErrThrottled) {
+			// retrying a throttled sender would only keep their rate up, the sender
+			// sees the reason and can schedule the message again
+			return s.Messages.Store.FailScheduledMessage(scheduled.ID, err.Error())
+		} else if err != nil {
+			if scheduled.Attempts >= SCHEDULED_MAX_ATTEMPTS {
+				return s.Messages.Store.FailScheduledMessage(scheduled.ID, err.Error())
+			}
+			return err
+		}
+	} else if msg, err = s.Messages.Store.GetMessage(scheduled.ID); err != nil {
+		// saved on an earlier tick that failed to publish
+		return err
+	}
+
+	if msg.Quarantined {
+		if err := s.Publisher.Publish(constants.MessageEventsExchange, constants.MessageQuarantinedKey, quarantinedEvent(msg)); err != nil {
+			return err
+		}
+		return s.Messages.Store.MarkScheduledMessagePublished(scheduled.ID, now)
+	}
+
+	// the event is built from the saved message, whose content went through moderation
+	event := s.Messages.sentEvent(msg, scheduled.Attachments)
+	if err := s.Publisher.Publish(constants.MessageEventsExchange, constants.MessageSentKey, event); err != nil {
+		return err
+	}
+	return s.Messages.Store.MarkScheduledMessagePublished(scheduled.ID, now)
+}
+
+func (s *MessageScheduler) persist(scheduled *models.ScheduledMessage) (*models.Message, error) {
+	conv, err := s.Messages.GetConversation(scheduled.Sender, scheduled.Receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &models.Message{
+		ID:             scheduled.ID,
+		ConversationID: conv.ID,
+		Content:        scheduled.Content,
+		Sender:         scheduled.Sender,
+		Receiver:       scheduled.Receiver,
+		Status:         constants.MessageSentKey,
+		Type:           constants.MessageCreate,
+		Sent:           true,
+		ReplyToID:      scheduled.ReplyToID,
+		CreatedAt:      scheduled.SendAt,
+		UpdatedAt:      scheduled.SendAt,
+		Version:        1,
+	}
+	if err := s.Messages.prepareMessage(msg); err != nil {
+		return nil, err
+	}
+	if err := s.Messages.Store.FireScheduledMessage(scheduled.ID, msg, scheduled.Attachments); err != nil {
+		return nil, err
+	}
+
+	s.Messages.publishMentions(msg)
+	return msg, nil
+}
@@ -1,162 +1,556 @@
 package services
 
 import (
-	"context"
 	"errors"
+	"io"
 	"log"
+	"time"
 
-	"github.com/lib/pq"
+	"github.com/yonraz/gochat_messages/constants"
 	"github.com/yonraz/gochat_messages/models"
-	"gorm.io/gorm"
+	"github.com/yonraz/gochat_messages/moderation"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/spam"
 )
 var MESSAGE_PAGINATION_SIZE = 20
+var REPLY_PREVIEW_LENGTH = 100
 
 type MessagesServiceInterface interface {
     GetConversation(sender, receiver string) (*models.Conversation, error)
 	GetConversationWithMessages(sender, receiver string, page int) (*models.Conversation, error)
+	GetConversationWithArchivedMessages(sender, receiver string, offset int) (*models.Conversation, error)
     AddMessage(msg *models.Message) error
 	CreateConversation(sender string, receiver string) (*models.Conversation, error)
 	UpdateMessage(message *models.Message) (*models.Message, error)
 	GetMessageByID(id string) (*models.Message, error)
+	GetVisibleMessage(id, user string) (*models.Message, error)
+	GetConversationByID(id uint) (*models.Conversation, error)
+	GetMessageRevisions(messageID, user string) ([]models.MessageRevision, error)
+	AddReaction(reaction *models.Reaction) error
+	RemoveReaction(messageID, username, emoji string) error
+	GetReplies(messageID, viewer string, offset int) ([]models.Message, error)
+	GetReceipts(messageID, user string) ([]models.Receipt, error)
+	GetMentions(username string, offset int) ([]models.Mention, error)
+	CountUnreadMentions(username string) ([]models.MentionCount, error)
+	ForwardMessage(messageID, user string, conversationID uint) (*models.Message, error)
+	ExportConversation(conversationID uint, viewer string, format ExportFormat, w io.Writer) error
+}
+
+// MessageArchive reads messages whose partitions were moved out of the database
+type MessageArchive interface {
+	// ReadMessages pages newest first through the archived messages keep accepts, the
+	// offset only counts those. A nil keep accepts every message
+	ReadMessages(conversationID uint, offset, limit int, keep func(*models.Message) bool) ([]models.Message, error)
+	EachMessage(conversationID uint, fn func(*models.Message) error) error
+	Walk(fn func(*models.Message) error) error
 }
 
 type MessagesService struct {
-	DB *gorm.DB
+	Store     repository.Store
+	Archive   MessageArchive
+	Publisher EventPublisher
+	// Moderator filters the content of new messages, nil turns moderation off
+	Moderator *moderation.Moderator
+	// Spam scores the senders of new messages, nil turns detection off
+	Spam *spam.Detector
 }
 
-func NewMessagesService(db *gorm.DB) *MessagesService {
+func NewMessagesService(store repository.Store) *MessagesService {
 	return &MessagesService{
-		DB: db,
+		Store: store,
 	}
 }
 
+// GetConversation returns the conversation between sender and receiver, creating it on first contact
 func (srv *MessagesService) GetConversation(sender, receiver string) (*models.Conversation, error) {
-var conv models.Conversation
-	participants := pq.StringArray{sender, receiver}
-	query := srv.DB.WithContext(context.Background()).
-		Where("participants @> ? AND participants <@ ?", participants, participants).
-		First(&conv)
-
-	if errors.Is(query.Error, gorm.ErrRecordNotFound) {
-		conversation := models.Conversation{
-			Participants: participants,
-			Messages:     []models.Message{},
+	conv, err := srv.Store.FindConversation([]string{sender, receiver})
+	if errors.Is(err, repository.ErrNotFound) {
+		conv, err = srv.Store.CreateConversation([]string{sender, receiver})
+		if err != nil {
+			log.Printf("error saving new conversation: %v\n", err)
+			return nil, err
 		}
-		
-		result := srv.DB.Create(&conversation)
-		if result.Error != nil {
-			log.Printf("error saving new conversation: %v\n", result.Error)
-			return nil, result.Error
-		}
-		return &conversation, nil 
-	} else if query.Error != nil {
-		log.Printf("error querying conversation: %v\n", query.Error)
-		return nil, query.Error
+		return conv, nil
+	} else if err != nil {
+		log.Printf("error querying conversation: %v\n", err)
+		return nil, err
 	}
 
-	return &conv, nil 
+	return conv, nil
 }
 
 func (srv *MessagesService) GetConversationWithMessages(sender, receiver string, offset int) (*models.Conversation, error) {
-	var conv models.Conversation
-	participants := pq.StringArray{sender, receiver}
-	query := srv.DB.WithContext(context.Background()).
-		Where("participants @> ? AND participants <@ ?", participants, participants).
-		Preload("Messages", func(db *gorm.DB) *gorm.DB {
-			return db.Order("created_at desc").Offset(offset).Limit(MESSAGE_PAGINATION_SIZE)
-		}).
-		First(&conv)
+	return srv.getConversationWithMessages(sender, receiver, offset, false)
+}
+
+// GetConversationWithArchivedMessages continues into the archived history once the
+// messages still in the database run out
+func (srv *MessagesService) GetConversationWithArchivedMessages(sender, receiver string, offset int) (*models.Conversation, error) {
+	return srv.getConversationWithMessages(sender, receiver, offset, true)
+}
 
-	if errors.Is(query.Error, gorm.ErrRecordNotFound) {
-		conversation := models.Conversation{
-			Participants: participants,
-			Messages:     []models.Message{},
+func (srv *MessagesService) getConversationWithMessages(sender, receiver string, offset int, includeArchived bool) (*models.Conversation, error) {
+	conv, err := srv.GetConversation(sender, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sender is the one asking for the conversation
+	conv.Messages, err = srv.Store.ListMessagesVisibleTo(conv.ID, sender, offset, MESSAGE_PAGINATION_SIZE)
+	if err != nil {
+		log.Printf("error querying messages: %v\n", err)
+		return nil, err
+	}
+
+	if includeArchived && srv.Archive != nil && len(conv.Messages) < MESSAGE_PAGINATION_SIZE {
+		// the offset counts what the sender sees, live and archived alike
+		live, err := srv.Store.CountMessagesVisibleTo(conv.ID, sender)
+		if err != nil {
+			log.Printf("error counting messages: %v\n", err)
+			return nil, err
+		}
+		archiveOffset := offset - int(live)
+		if archiveOffset < 0 {
+			archiveOffset = 0
 		}
-		
-		result := srv.DB.Create(&conversation)
-		if result.Error != nil {
-			log.Printf("error saving new conversation: %v\n", result.Error)
-			return nil, result.Error
+		blocked, err := srv.blockedFor(sender)
+		if err != nil {
+			log.Printf("error loading blocks: %v\n", err)
+			return nil, err
 		}
-		return &conversation, nil 
-	} else if query.Error != nil {
-		log.Printf("error querying conversation: %v\n", query.Error)
-		return nil, query.Error
+		now := time.Now()
+		keep := func(msg *models.Message) bool {
+			return !blocked(msg) && !heldFrom(msg, sender) && !expired(conv, msg.CreatedAt, now)
+		}
+		archived, err := srv.Archive.ReadMessages(conv.ID, archiveOffset, MESSAGE_PAGINATION_SIZE-len(conv.Messages), keep)
+		if err != nil {
+			log.Printf("error reading archived messages: %v\n", err)
+			return nil, err
+		}
+		conv.Messages = append(conv.Messages, archived...)
+	}
+
+	if err := srv.attachReactions(conv.Messages, sender); err != nil {
+		log.Printf("error loading reactions: %v\n", err)
+		return nil, err
+	}
+	if err := srv.attachReplyPreviews(conv.Messages, sender); err != nil {
+		log.Printf("error loading reply previews: %v\n", err)
+		return nil, err
 	}
 
-	return &conv, nil 
+	return conv, nil
 }
 
 func (srv *MessagesService) AddMessage(msg *models.Message) error {
-	err := srv.DB.Create(&msg).Error
+	return srv.AddMessageWithAttachments(msg, nil)
+}
+
+// AddMessageWithAttachments saves the message and links the uploaded attachments to it,
+// only attachments uploaded by the sender that are not linked yet can be claimed.
+// Participants mentioned in the content are notified once the message is saved
+func (srv *MessagesService) AddMessageWithAttachments(msg *models.Message, attachmentIDs []string) error {
+	if err := srv.prepareMessage(msg); err != nil {
+		return err
+	}
+	if err := srv.Store.CreateMessage(msg, attachmentIDs); err != nil {
+		return err
+	}
+
+	if msg.Quarantined {
+		srv.publishQuarantine(msg)
+		return nil
+	}
+	srv.publishMentions(msg)
+	return nil
+}
+
+// prepareMessage runs the checks every new message goes through before it is saved.
+// It fails with ErrBlocked when the only other participant blocked the sender, in
+// groups the message is kept and hidden from the blockers when they read. Replies to
+// messages of other conversations or hidden from the sender fail with ErrInvalidReply.
+// The spam detector can fail with ErrThrottled or hide the message, and moderation can
+// mask the content, quarantine the message or fail with ErrRejected. Quarantined
+// messages mention no one
+func (srv *MessagesService) prepareMessage(msg *models.Message) error {
+	conv, err := srv.Store.GetConversationByID(msg.ConversationID)
 	if err != nil {
 		return err
 	}
 
+	var others []string
+	for _, participant := range conv.Participants {
+		if participant != msg.Sender {
+			others = append(others, participant)
+		}
+	}
+	blockers, err := srv.Store.ListBlockers(msg.Sender, others)
+	if err != nil {
+		return err
+	}
+	if len(others) > 0 && len(blockers) == len(others) {
+		return ErrBlocked
+	}
+	if err := srv.checkReplyTo(msg); err != nil {
+		return err
+	}
+	if err := srv.checkSpam(msg); err != nil {
+		return err
+	}
+	if err := srv.moderate(msg, conv); err != nil {
+		return err
+	}
+
+	if !msg.Quarantined {
+		srv.attachMentions(msg, conv, blockers)
+	}
 	return nil
 }
 
-func (s *MessagesService) UpdateMessage(message *models.Message) (*models.Message, error) {
-    var existingMessage models.Message
-    
-    // Retrieve the existing message by ID
-    result := s.DB.First(&existingMessage, "id = ?", message.ID)
-    if result.Error != nil {
-        if result.Error == gorm.ErrRecordNotFound {
-            // Handle case where the record is not found
-            return nil, nil
-        }
-        return nil, result.Error
-    }
-
-    // Check version
-    if existingMessage.Version != message.Version {
-        // Return an error if versions do not match
-        return nil, errors.New("version conflict")
-    }
-
-    // Update only the fields that need to be updated
-    updateFields := map[string]interface{}{
-        "content":      message.Content,
-        "read":         message.Read,
-        "status":       message.Status,
-        "type":         message.Type,
-        "created_at":   message.CreatedAt,
-        "version":      existingMessage.Version + 1,
-    }
-    
-    // Save the updated message
-    result = s.DB.Model(&existingMessage).Updates(updateFields)
-	if result.Error != nil {
+// checkReplyTo lets replies to parents that are gone through, they are shown as deleted
+func (srv *MessagesService) checkReplyTo(msg *models.Message) error {
+	if msg.ReplyToID == nil {
+		return nil
+	}
+	parent, err := srv.Store.GetMessage(*msg.ReplyToID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if parent.ConversationID != msg.ConversationID {
+		return ErrInvalidReply
+	}
+	visible, err := srv.hideBlocked(hideQuarantined([]models.Message{*parent}, msg.Sender), msg.Sender)
+	if err != nil {
+		return err
+	}
+	if len(visible) == 0 {
+		return ErrInvalidReply
+	}
+	return nil
+}
+
+// PublishRejection tells the sender their message was not saved, failures are only logged
+func (srv *MessagesService) PublishRejection(msg *models.Message, reason string) {
+	if srv.Publisher == nil {
+		return
+	}
+	event := models.MessageRejectedEvent{
+		MessageID: msg.ID,
+		Sender:    msg.Sender,
+		Receiver:  msg.Receiver,
+		Reason:    reason,
+	}
+	if err := srv.Publisher.Publish(constants.MessageEventsExchange, constants.MessageRejectedKey, event); err != nil {
+		log.Printf("error publishing rejection of message %v: %v\n", msg.ID, err)
+	}
+}
+
+// UpdateMessage saves a new status or new content for the message. New content is an
+// edit, it goes through the spam detector and moderation like a new message does.
+// Edits by anyone but the sender fail with ErrNotSender. Redacted messages keep their
+// status updates but fail edits with ErrRedacted
+func (srv *MessagesService) UpdateMessage(message *models.Message) (*models.Message, error) {
+	// Retrieve the existing message by ID
+	existingMessage, err := srv.Store.GetMessage(message.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Check version
+	if existingMessage.Version != message.Version {
+		return nil, repository.ErrVersionConflict
+	}
+
+	updated := *existingMessage
+	updated.Content = message.Content
+	updated.Read = message.Read
+	updated.Status = message.Status
+	updated.Type = message.Type
+	updated.Version = existingMessage.Version + 1
+
+	// Keep the previous content around before it gets overwritten
+	var revision *models.MessageRevision
+	if existingMessage.Content != message.Content {
+		if existingMessage.Redacted {
+			return nil, ErrRedacted
+		}
+		if message.Sender != existingMessage.Sender {
+			return nil, ErrNotSender
+		}
+		if err := srv.checkEdit(&updated); err != nil {
+			return nil, err
+		}
+		editedAt := time.Now()
+		updated.Edited = true
+		updated.EditedAt = &editedAt
+		revision = &models.MessageRevision{
+			MessageID: existingMessage.ID,
+			Version:   existingMessage.Version,
+			Content:   existingMessage.Content,
+			EditedBy:  existingMessage.Sender,
+			EditedAt:  editedAt,
+		}
+	}
+
+	err = srv.Store.UpdateMessage(&updated, existingMessage.Version, revision)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return nil, err
+	} else if err != nil {
+		log.Printf("error updating message %v: %v\n", message.ID, err)
 		return nil, errors.New("failed to update message")
 	}
-    
-    return &existingMessage, nil
+
+	if updated.Quarantined && !existingMessage.Quarantined {
+		srv.publishQuarantine(&updated)
+	}
+	return &updated, nil
 }
 
-func (srv *MessagesService) CreateConversation(sender, receiver string) (*models.Conversation, error) {
-	conv := &models.Conversation{
-		Participants: pq.StringArray{sender, receiver},
-		Messages: []models.Message{},
+// checkEdit runs the new content of an edited message through the spam detector and
+// moderation, only the decisions taken on this edit are left on the message
+func (srv *MessagesService) checkEdit(msg *models.Message) error {
+	conv, err := srv.Store.GetConversationByID(msg.ConversationID)
+	if err != nil {
+		return err
 	}
+	msg.ModerationDecisions = nil
+	if err := srv.checkSpam(msg); err != nil {
+		return err
+	}
+	return srv.moderate(msg, conv)
+}
 
-	result := srv.DB.Model(&models.Conversation{}).Create(&conv)
-	if result.Error != nil {
-		return nil, result.Error
+// RepairDuplicateConversations merges conversations that share the same participants
+// into the oldest one, and returns the groups it found
+func (srv *MessagesService) RepairDuplicateConversations(dryRun bool) ([][]uint, error) {
+	duplicates, err := srv.Store.FindDuplicateConversations()
+	if err != nil || dryRun {
+		return duplicates, err
 	}
 
-	return conv, nil
+	for _, group := range duplicates {
+		if err := srv.Store.MergeConversations(group[0], group[1:]); err != nil {
+			log.Printf("error merging conversations %v: %v\n", group, err)
+			return duplicates, err
+		}
+	}
+	return duplicates, nil
+}
+
+func (srv *MessagesService) CreateConversation(sender, receiver string) (*models.Conversation, error) {
+	return srv.Store.CreateConversation([]string{sender, receiver})
 }
 
 func (srv *MessagesService) GetMessageByID(id string) (*models.Message, error) {
-	var msg *models.Message 
-	err := srv.DB.Where(models.Message{ID: id}).First(&msg).Error
+	return srv.Store.GetMessage(id)
+}
 
+// GetVisibleMessage fails like ensureVisible when the user can not see the message
+func (srv *MessagesService) GetVisibleMessage(id, user string) (*models.Message, error) {
+	msg, err := srv.Store.GetMessage(id)
 	if err != nil {
 		return nil, err
 	}
-
+	if err := srv.ensureVisible(msg, user); err != nil {
+		return nil, err
+	}
 	return msg, nil
 }
 
+func (srv *MessagesService) GetConversationByID(id uint) (*models.Conversation, error) {
+	return srv.Store.GetConversationByID(id)
+}
+
+// GetMessageRevisions fails like ensureVisible when the user can not see the message
+func (srv *MessagesService) GetMessageRevisions(messageID, user string) ([]models.MessageRevision, error) {
+	if _, err := srv.GetVisibleMessage(messageID, user); err != nil {
+		return nil, err
+	}
+	return srv.Store.ListRevisions(messageID)
+}
+
+// AddReaction fails like ensureVisible when the user can not see the message
+func (srv *MessagesService) AddReaction(reaction *models.Reaction) error {
+	msg, err := srv.Store.GetMessage(reaction.MessageID)
+	if err != nil {
+		return err
+	}
+	if err := srv.ensureVisible(msg, reaction.Username); err != nil {
+		return err
+	}
+	return srv.Store.AddReaction(reaction)
+}
+
+func (srv *MessagesService) RemoveReaction(messageID, username, emoji string) error {
+	return srv.Store.RemoveReaction(messageID, username, emoji)
+}
+
+// attachReactions aggregates the reactions of all given messages in a single query
+func (srv *MessagesService) attachReactions(messages []models.Message, username string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+
+	summaries, err := srv.Store.SummarizeReactions(ids, username)
+	if err != nil {
+		return err
+	}
+
+	byMessage := make(map[string][]models.ReactionSummary)
+	for _, summary := range summaries {
+		byMessage[summary.MessageID] = append(byMessage[summary.MessageID], summary)
+	}
+	for i := range messages {
+		messages[i].Reactions = byMessage[messages[i].ID]
+	}
+
+	return nil
+}
+
+// GetReplies lists the replies the viewer can see to a message they can see
+func (srv *MessagesService) GetReplies(messageID, viewer string, offset int) ([]models.Message, error) {
+	parent, err := srv.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.ensureVisible(parent, viewer); err != nil {
+		return nil, err
+	}
+	replies, err := srv.Store.ListReplies(parent.ConversationID, parent.ID, offset, MESSAGE_PAGINATION_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return srv.hideBlocked(hideQuarantined(replies, viewer), viewer)
+}
+
+// attachReplyPreviews loads the parents of all replies in a single query, parents
+// that no longer exist are marked as deleted. Parents from another conversation or
+// hidden from the viewer are marked as unavailable
+func (srv *MessagesService) attachReplyPreviews(messages []models.Message, viewer string) error {
+	var parentIDs []string
+	for _, msg := range messages {
+		if msg.ReplyToID != nil {
+			parentIDs = append(parentIDs, *msg.ReplyToID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	parents, err := srv.Store.GetMessagesByIDs(parentIDs)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(parents))
+	for _, parent := range parents {
+		found[parent.ID] = true
+	}
+	parents, err = srv.hideBlocked(hideQuarantined(parents, viewer), viewer)
+	if err != nil {
+		return err
+	}
 
+	byID := make(map[string]models.Message, len(parents))
+	for _, parent := range parents {
+		byID[parent.ID] = parent
+	}
+	for i := range messages {
+		if messages[i].ReplyToID == nil {
+			continue
+		}
+		parent, visible := byID[*messages[i].ReplyToID]
+		if !found[*messages[i].ReplyToID] {
+			messages[i].ReplyTo = &models.MessagePreview{
+				ID:      *messages[i].ReplyToID,
+				Deleted: true,
+			}
+			continue
+		}
+		if !visible || parent.ConversationID != messages[i].ConversationID {
+			messages[i].ReplyTo = &models.MessagePreview{
+				ID:          *messages[i].ReplyToID,
+				Unavailable: true,
+			}
+			continue
+		}
+		messages[i].ReplyTo = &models.MessagePreview{
+			ID:        parent.ID,
+			Sender:    parent.Sender,
+			Content:   truncate(parent.Content, REPLY_PREVIEW_LENGTH),
+			CreatedAt: parent.CreatedAt,
+		}
+	}
+
+	return nil
+}
+
+// hideBlocked drops what the viewer's blocked users sent after they were blocked,
+// for messages that the store could not filter
+func (srv *MessagesService) hideBlocked(messages []models.Message, viewer string) ([]models.Message, error) {
+	blocked, err := srv.blockedFor(viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := messages[:0]
+	for i := range messages {
+		if blocked(&messages[i]) {
+			continue
+		}
+		visible = append(visible, messages[i])
+	}
+	return visible, nil
+}
+
+// blockedFor loads the viewer's blocks once and tells whether a message is hidden by
+// them, for messages read out of the store one at a time
+func (srv *MessagesService) blockedFor(viewer string) (func(*models.Message) bool, error) {
+	blocks, err := srv.Store.ListBlocks(viewer)
+	if err != nil {
+		return nil, err
+	}
+	blockedSince := make(map[string]time.Time, len(blocks))
+	for _, block := range blocks {
+		blockedSince[block.Blocked] = block.CreatedAt
+	}
+	return func(msg *models.Message) bool {
+		since, blocked := blockedSince[msg.Sender]
+		return blocked && !msg.CreatedAt.Before(since)
+	}, nil
+}
+
+func truncate(content string, length int) string {
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content
+	}
+	return string(runes[:length]) + "…"
+}
+
+// RecordReceipt marks the message as delivered to or read by the user, reading
+// implies delivery and timestamps that are already set are kept
+func (srv *MessagesService) RecordReceipt(messageID, username string, status constants.RoutingKey, at time.Time) error {
+	return srv.Store.RecordReceipt(messageID, username, status, at)
+}
+
+// ClearDraft empties the sender's draft once their message is saved, drafts saved
+// after the message was written are kept
+func (srv *MessagesService) ClearDraft(conversationID uint, username string, sentAt time.Time) error {
+	return srv.Store.ClearDraft(conversationID, username, sentAt)
+}
+
+// GetReceipts fails like ensureVisible when the user can not see the message
+func (srv *MessagesService) GetReceipts(messageID, user string) ([]models.Receipt, error) {
+	if _, err := srv.GetVisibleMessage(messageID, user); err != nil {
+		return nil, err
+	}
+	return srv.Store.ListReceipts(messageID)
+}
@@ -0,0 +1,1258 @@
+package services_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/archive"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/encryption"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/moderation"
+	"github.com/yonraz/gochat_messages/repository"
+	"github.com/yonraz/gochat_messages/services"
+	"github.com/yonraz/gochat_messages/spam"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestService(t *testing.T) *services.MessagesService {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "messages.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	store, err := repository.NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	return services.NewMessagesService(store)
+}
+
+func sendMessage(t *testing.T, srv *services.MessagesService, sender, receiver, content string) *models.Message {
+	conv, err := srv.GetConversation(sender, receiver)
+	require.NoError(t, err)
+
+	msg := &models.Message{
+		ID:             uuid.NewString(),
+		ConversationID: conv.ID,
+		Content:        content,
+		Sender:         sender,
+		Receiver:       receiver,
+		Status:         constants.MessageSentKey,
+		Type:           constants.MessageCreate,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		Version:        1,
+	}
+	require.NoError(t, srv.AddMessage(msg))
+	return msg
+}
+
+func TestGetConversationIsSymmetric(t *testing.T) {
+	srv := newTestService(t)
+
+	first, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+	second, err := srv.GetConversation("bar", "foo")
+	require.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+}
+
+func TestUpdateMessageKeepsRevisions(t *testing.T) {
+	srv := newTestService(t)
+	msg := sendMessage(t, srv, "foo", "bar", "first")
+
+	edit := *msg
+	edit.Content = "second"
+	updated, err := srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+	assert.True(t, updated.Edited)
+	assert.NotNil(t, updated.EditedAt)
+	assert.Equal(t, uint(2), updated.Version)
+
+	// a read update with the same content must not count as an edit
+	read := *updated
+	read.Read = true
+	read.Status = constants.MessageReadKey
+	updated, err = srv.UpdateMessage(&read)
+	require.NoError(t, err)
+	assert.True(t, updated.Edited)
+
+	revisions, err := srv.GetMessageRevisions(msg.ID, "foo")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "first", revisions[0].Content)
+	assert.Equal(t, "foo", revisions[0].EditedBy)
+
+	_, err = srv.UpdateMessage(&edit)
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+
+	forged := *updated
+	forged.Sender = "bar"
+	forged.Content = "not yours"
+	_, err = srv.UpdateMessage(&forged)
+	assert.ErrorIs(t, err, services.ErrNotSender)
+	stored, err := srv.GetMessageByID(msg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "second", stored.Content)
+}
+
+func TestRevisionsFollowMessageVisibility(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "links", "action": "quarantine"}]
+	}`))
+	require.NoError(t, err)
+	msg := sendMessage(t, srv, "foo", "bar", "hello")
+	edit := *msg
+	edit.Content = "see https://evil.test/x"
+	_, err = srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+
+	_, err = srv.GetMessageRevisions(msg.ID, "baz")
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	// the edit put the message on hold, its earlier content stays with it
+	_, err = srv.GetMessageRevisions(msg.ID, "bar")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	revisions, err := srv.GetMessageRevisions(msg.ID, "foo")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "hello", revisions[0].Content)
+}
+
+func TestReceiptsFollowMessageVisibility(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "links", "action": "quarantine"}]
+	}`))
+	require.NoError(t, err)
+	msg := sendMessage(t, srv, "foo", "bar", "hello")
+	held := sendMessage(t, srv, "foo", "bar", "see https://evil.test/x")
+	require.NoError(t, srv.RecordReceipt(msg.ID, "bar", constants.MessageReadKey, time.Now()))
+
+	receipts, err := srv.GetReceipts(msg.ID, "foo")
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	assert.Equal(t, "bar", receipts[0].Username)
+	assert.NotNil(t, receipts[0].ReadAt)
+
+	_, err = srv.GetReceipts(msg.ID, "baz")
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	_, err = srv.GetReceipts(held.ID, "bar")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	receipts, err = srv.GetReceipts(held.ID, "foo")
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+}
+
+func TestEditsAreModerated(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [
+			{"type": "words", "action": "mask", "words": ["darn"]},
+			{"type": "links", "action": "quarantine"},
+			{"type": "maxLength", "action": "reject", "max": 40}
+		]
+	}`))
+	require.NoError(t, err)
+	msg := sendMessage(t, srv, "foo", "bar", "hello")
+
+	edit := *msg
+	edit.Content = "darn it"
+	updated, err := srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+	assert.Equal(t, "**** it", updated.Content)
+
+	edit = *updated
+	edit.Content = "this edit is far too long to get past moderation"
+	_, err = srv.UpdateMessage(&edit)
+	assert.ErrorIs(t, err, services.ErrRejected)
+	stored, err := srv.GetMessageByID(msg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "**** it", stored.Content)
+
+	edit = *stored
+	edit.Content = "see https://evil.test/x"
+	_, err = srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+	visible, err := srv.Store.ListMessagesVisibleTo(msg.ConversationID, "bar", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, visible)
+	decisions, err := srv.Store.ListModerationDecisions(msg.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, decisions)
+}
+
+func TestGetConversationWithMessages(t *testing.T) {
+	srv := newTestService(t)
+	parent := sendMessage(t, srv, "foo", "bar", "parent")
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: parent.ID, Username: "foo", Emoji: "👍"}))
+
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+	deletedParent := uuid.NewString()
+	reply := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "reply", Sender: "bar", Receiver: "foo", ReplyToID: &parent.ID, CreatedAt: time.Now(), Version: 1}
+	orphan := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "orphan", Sender: "foo", Receiver: "bar", ReplyToID: &deletedParent, CreatedAt: time.Now(), Version: 1}
+	require.NoError(t, srv.AddMessage(reply))
+	require.NoError(t, srv.AddMessage(orphan))
+
+	conv, err = srv.GetConversationWithMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	require.Len(t, conv.Messages, 3)
+
+	byID := map[string]models.Message{}
+	for _, msg := range conv.Messages {
+		byID[msg.ID] = msg
+	}
+	require.Len(t, byID[parent.ID].Reactions, 1)
+	assert.True(t, byID[parent.ID].Reactions[0].ReactedByMe)
+	require.NotNil(t, byID[reply.ID].ReplyTo)
+	assert.Equal(t, "parent", byID[reply.ID].ReplyTo.Content)
+	require.NotNil(t, byID[orphan.ID].ReplyTo)
+	assert.True(t, byID[orphan.ID].ReplyTo.Deleted)
+}
+
+// summaryCountingStore counts how often reactions are aggregated
+type summaryCountingStore struct {
+	repository.Store
+	summaries int
+}
+
+func (s *summaryCountingStore) SummarizeReactions(messageIDs []string, username string) ([]models.ReactionSummary, error) {
+	s.summaries++
+	return s.Store.SummarizeReactions(messageIDs, username)
+}
+
+func TestReactionsAreAggregatedPerViewer(t *testing.T) {
+	store := &summaryCountingStore{Store: newTestService(t).Store}
+	srv := services.NewMessagesService(store)
+	first := sendMessage(t, srv, "foo", "bar", "first")
+	second := sendMessage(t, srv, "bar", "foo", "second")
+	sendMessage(t, srv, "foo", "bar", "third")
+
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: first.ID, Username: "foo", Emoji: "👍"}))
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: first.ID, Username: "bar", Emoji: "👍"}))
+	// reacting twice with the same emoji counts once
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: first.ID, Username: "bar", Emoji: "👍"}))
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: first.ID, Username: "bar", Emoji: "❤️"}))
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: second.ID, Username: "foo", Emoji: "😂"}))
+	assert.ErrorIs(t, srv.AddReaction(&models.Reaction{MessageID: first.ID, Username: "baz", Emoji: "👍"}), services.ErrForbidden)
+	require.NoError(t, srv.RemoveReaction(second.ID, "foo", "😂"))
+
+	conv, err := srv.GetConversationWithMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	require.Len(t, conv.Messages, 3)
+	assert.Equal(t, 1, store.summaries)
+
+	reactions := map[string]map[string]models.ReactionSummary{}
+	for _, msg := range conv.Messages {
+		reactions[msg.ID] = map[string]models.ReactionSummary{}
+		for _, summary := range msg.Reactions {
+			reactions[msg.ID][summary.Emoji] = summary
+		}
+	}
+	assert.Equal(t, 2, reactions[first.ID]["👍"].Count)
+	assert.True(t, reactions[first.ID]["👍"].ReactedByMe)
+	assert.Equal(t, 1, reactions[first.ID]["❤️"].Count)
+	assert.False(t, reactions[first.ID]["❤️"].ReactedByMe)
+	assert.Empty(t, reactions[second.ID])
+
+	conv, err = srv.GetConversationWithMessages("bar", "foo", 0)
+	require.NoError(t, err)
+	for _, msg := range conv.Messages {
+		if msg.ID == first.ID {
+			require.Len(t, msg.Reactions, 2)
+			for _, summary := range msg.Reactions {
+				assert.True(t, summary.ReactedByMe)
+			}
+		}
+	}
+}
+
+func TestRepliesStayInTheirConversation(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "links", "action": "quarantine"}]
+	}`))
+	require.NoError(t, err)
+	parent := sendMessage(t, srv, "foo", "bar", "parent")
+	held := sendMessage(t, srv, "foo", "bar", "see https://evil.test/x")
+	require.True(t, held.Quarantined)
+
+	reply := func(sender, receiver string, parentID string) (*models.Message, error) {
+		conv, err := srv.GetConversation(sender, receiver)
+		require.NoError(t, err)
+		msg := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "reply", Sender: sender, Receiver: receiver, ReplyToID: &parentID, CreatedAt: time.Now(), Version: 1}
+		return msg, srv.AddMessage(msg)
+	}
+	_, err = reply("foo", "baz", parent.ID)
+	assert.ErrorIs(t, err, services.ErrInvalidReply)
+	_, err = reply("bar", "foo", held.ID)
+	assert.ErrorIs(t, err, services.ErrInvalidReply)
+	_, err = reply("bar", "foo", parent.ID)
+	require.NoError(t, err)
+	toHeld, err := reply("foo", "bar", held.ID)
+	require.NoError(t, err)
+
+	conv, err := srv.GetConversationWithMessages("bar", "foo", 0)
+	require.NoError(t, err)
+	var preview *models.MessagePreview
+	for _, msg := range conv.Messages {
+		if msg.ID == toHeld.ID {
+			preview = msg.ReplyTo
+		}
+	}
+	require.NotNil(t, preview)
+	assert.True(t, preview.Unavailable)
+	assert.Empty(t, preview.Content)
+
+	replies, err := srv.GetReplies(parent.ID, "foo", 0)
+	require.NoError(t, err)
+	assert.Len(t, replies, 1)
+	_, err = srv.GetReplies(parent.ID, "baz", 0)
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	_, err = srv.GetReplies(held.ID, "bar", 0)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestRepliesArePagedOldestFirst(t *testing.T) {
+	srv := newTestService(t)
+	services.MESSAGE_PAGINATION_SIZE = 2
+	defer func() { services.MESSAGE_PAGINATION_SIZE = 20 }()
+
+	parent := sendMessage(t, srv, "foo", "bar", "parent")
+	start := time.Now()
+	var replies []string
+	for i := 0; i < 3; i++ {
+		msg := &models.Message{ID: uuid.NewString(), ConversationID: parent.ConversationID, Content: fmt.Sprintf("reply %d", i), Sender: "bar", Receiver: "foo", ReplyToID: &parent.ID, CreatedAt: start.Add(time.Duration(i) * time.Minute), Version: 1}
+		require.NoError(t, srv.AddMessage(msg))
+		replies = append(replies, msg.ID)
+	}
+	sendMessage(t, srv, "foo", "bar", "not a reply")
+
+	page, err := srv.GetReplies(parent.ID, "foo", 0)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, replies[0], page[0].ID)
+	assert.Equal(t, replies[1], page[1].ID)
+	page, err = srv.GetReplies(parent.ID, "foo", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, replies[2], page[0].ID)
+
+	_, err = srv.GetReplies(parent.ID, "baz", 0)
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	_, err = srv.GetReplies(uuid.NewString(), "foo", 0)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestMentionsAreParsedOnIngest(t *testing.T) {
+	srv := newTestService(t)
+	msg := sendMessage(t, srv, "foo", "bar", "hey @bar, @foo and @baz, mail me at foo@bar.com @bar.")
+	sendMessage(t, srv, "foo", "bar", "no mentions here")
+
+	mentions, err := srv.GetMentions("bar", 0)
+	require.NoError(t, err)
+	require.Len(t, mentions, 1)
+	assert.Equal(t, msg.ID, mentions[0].MessageID)
+	require.NotNil(t, mentions[0].Message)
+
+	unread, err := srv.CountUnreadMentions("bar")
+	require.NoError(t, err)
+	require.Len(t, unread, 1)
+	assert.Equal(t, int64(1), unread[0].Count)
+
+	require.NoError(t, srv.RecordReceipt(msg.ID, "bar", constants.MessageReadKey, time.Now()))
+	unread, err = srv.CountUnreadMentions("bar")
+	require.NoError(t, err)
+	assert.Empty(t, unread)
+}
+
+type recordingPublisher struct {
+	fail   bool
+	events []interface{}
+}
+
+func (p *recordingPublisher) Publish(exchange constants.Exchange, routingKey constants.RoutingKey, payload interface{}) error {
+	if p.fail {
+		return errors.New("channel closed")
+	}
+	p.events = append(p.events, payload)
+	return nil
+}
+
+func TestScheduledMessagesAreSentOnce(t *testing.T) {
+	srv := newTestService(t)
+	scheduledSrv := services.NewScheduledMessagesService(srv.Store)
+	publisher := &recordingPublisher{fail: true}
+	scheduler := services.NewMessageScheduler(srv, publisher)
+
+	now := time.Now()
+	scheduled := &models.ScheduledMessage{Sender: "foo", Receiver: "bar", Content: "later", SendAt: now.Add(time.Hour)}
+	require.NoError(t, scheduledSrv.Schedule(scheduled))
+	cancelled := &models.ScheduledMessage{Sender: "foo", Receiver: "bar", Content: "never", SendAt: now.Add(time.Hour)}
+	require.NoError(t, scheduledSrv.Schedule(cancelled))
+	require.NoError(t, scheduledSrv.Cancel(cancelled.ID, "foo"))
+	assert.ErrorIs(t, scheduledSrv.Cancel(cancelled.ID, "foo"), repository.ErrNotFound)
+
+	require.NoError(t, scheduler.Tick(now))
+	_, err := srv.GetMessageByID(scheduled.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	// the message is saved even though publishing fails, and is not saved again on retry
+	due := now.Add(2 * time.Hour)
+	require.NoError(t, scheduler.Tick(due))
+	msg, err := srv.GetMessageByID(scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "later", msg.Content)
+	assert.Empty(t, publisher.events)
+
+	publisher.fail = false
+	require.NoError(t, scheduler.Tick(due))
+	assert.Empty(t, publisher.events, "the lease is still held")
+	require.NoError(t, scheduler.Tick(due.Add(2*services.SCHEDULER_LEASE)))
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, scheduled.ID, publisher.events[0].(models.WsMessage).ID)
+	require.NoError(t, scheduler.Tick(due.Add(4*services.SCHEDULER_LEASE)))
+	assert.Len(t, publisher.events, 1)
+
+	pending, err := scheduledSrv.ListPending("foo")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	_, err = srv.GetMessageByID(cancelled.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestThrottledScheduledMessagesFail(t *testing.T) {
+	srv := newTestService(t)
+	config := spam.DefaultConfig()
+	config.Rate = spam.Limit{Max: 1, Window: spam.Duration(time.Hour)}
+	config.Thresholds = map[constants.SpamAction]float64{constants.SpamThrottle: 1}
+	var err error
+	srv.Spam, err = spam.NewDetector(config, spam.NewMemoryCounters())
+	require.NoError(t, err)
+	publisher := &recordingPublisher{}
+	scheduler := services.NewMessageScheduler(srv, publisher)
+
+	sendMessage(t, srv, "foo", "bar", "first")
+	now := time.Now()
+	scheduled := &models.ScheduledMessage{Sender: "foo", Receiver: "bar", Content: "second", SendAt: now.Add(time.Hour)}
+	require.NoError(t, services.NewScheduledMessagesService(srv.Store).Schedule(scheduled))
+	require.NoError(t, scheduler.Tick(now.Add(2*time.Hour)))
+
+	failed, err := srv.Store.GetScheduledMessage(scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, constants.ScheduledFailed, failed.Status)
+	assert.Equal(t, services.ErrThrottled.Error(), failed.Error)
+	_, err = srv.GetMessageByID(scheduled.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Empty(t, publisher.events)
+
+	// failed messages are not claimed again
+	require.NoError(t, scheduler.Tick(now.Add(2*time.Hour+2*services.SCHEDULER_LEASE)))
+	_, err = srv.GetMessageByID(scheduled.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestScheduledMessagesArePublishedAsSaved(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "words", "action": "mask", "words": ["darn"]}]
+	}`))
+	require.NoError(t, err)
+	publisher := &recordingPublisher{}
+	scheduler := services.NewMessageScheduler(srv, publisher)
+
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+	mutedUntil := time.Now().Add(24 * time.Hour)
+	require.NoError(t, srv.Store.SaveParticipantSettings(&models.ParticipantSettings{ConversationID: conv.ID, Username: "bar", MutedUntil: &mutedUntil}))
+
+	now := time.Now()
+	scheduled := &models.ScheduledMessage{Sender: "foo", Receiver: "bar", Content: "darn it", SendAt: now.Add(time.Hour)}
+	require.NoError(t, services.NewScheduledMessagesService(srv.Store).Schedule(scheduled))
+	require.NoError(t, scheduler.Tick(now.Add(2*time.Hour)))
+
+	require.Len(t, publisher.events, 1)
+	event := publisher.events[0].(models.WsMessage)
+	assert.Equal(t, "**** it", event.Content)
+	assert.Equal(t, []string{"bar"}, event.MutedBy)
+}
+
+func TestBlockedSendersAreRejectedAndHidden(t *testing.T) {
+	srv := newTestService(t)
+	blocks := services.NewBlocksService(srv.Store)
+	before := sendMessage(t, srv, "foo", "bar", "before the block")
+	_, err := blocks.Block("bar", "foo")
+	require.NoError(t, err)
+	_, err = blocks.Block("bar", "bar")
+	assert.ErrorIs(t, err, services.ErrInvalidBlock)
+
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+	rejected := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "hi", Sender: "foo", Receiver: "bar", CreatedAt: time.Now(), Version: 1}
+	assert.ErrorIs(t, srv.AddMessage(rejected), services.ErrBlocked)
+
+	// in groups the message goes through but is hidden from the blocker
+	group, err := srv.Store.CreateConversation([]string{"foo", "bar", "baz"})
+	require.NoError(t, err)
+	groupMsg := &models.Message{ID: uuid.NewString(), ConversationID: group.ID, Content: "hi @bar and @baz", Sender: "foo", CreatedAt: time.Now(), Version: 1}
+	require.NoError(t, srv.AddMessage(groupMsg))
+	visible, err := srv.Store.ListMessagesVisibleTo(group.ID, "bar", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, visible)
+	visible, err = srv.Store.ListMessagesVisibleTo(group.ID, "baz", 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, visible, 1)
+	assert.ErrorIs(t, srv.AddReaction(&models.Reaction{MessageID: groupMsg.ID, Username: "bar", Emoji: "👍"}), repository.ErrNotFound)
+	assert.ErrorIs(t, srv.AddReaction(&models.Reaction{MessageID: groupMsg.ID, Username: "qux", Emoji: "👍"}), services.ErrForbidden)
+	assert.NoError(t, srv.AddReaction(&models.Reaction{MessageID: groupMsg.ID, Username: "baz", Emoji: "👍"}))
+	mentions, err := srv.GetMentions("bar", 0)
+	require.NoError(t, err)
+	assert.Empty(t, mentions)
+
+	history, err := srv.GetConversationWithMessages("bar", "foo", 0)
+	require.NoError(t, err)
+	require.Len(t, history.Messages, 1)
+	assert.Equal(t, before.ID, history.Messages[0].ID)
+
+	require.NoError(t, blocks.Unblock("bar", "foo"))
+	assert.NoError(t, srv.AddMessage(rejected))
+}
+
+func TestPinsRequireVisibleMessages(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "links", "action": "quarantine"}]
+	}`))
+	require.NoError(t, err)
+	conversations := services.NewConversationsService(srv.Store, nil)
+	held := sendMessage(t, srv, "foo", "bar", "see https://evil.test/x")
+	visible := sendMessage(t, srv, "foo", "bar", "hello")
+
+	_, err = conversations.PinMessage(held.ConversationID, "bar", held.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	pin, err := conversations.PinMessage(visible.ConversationID, "bar", visible.ID)
+	require.NoError(t, err)
+	assert.Equal(t, visible.ID, pin.MessageID)
+}
+
+func TestPinsOfHiddenMessagesAreLeftOut(t *testing.T) {
+	srv := newTestService(t)
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [{"type": "links", "action": "quarantine"}]
+	}`))
+	require.NoError(t, err)
+	conversations := services.NewConversationsService(srv.Store, nil)
+
+	msg := sendMessage(t, srv, "foo", "bar", "hello")
+	_, err = conversations.PinMessage(msg.ConversationID, "bar", msg.ID)
+	require.NoError(t, err)
+	edit := *msg
+	edit.Content = "see https://evil.test/x"
+	_, err = srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+
+	pins, err := conversations.GetPins(msg.ConversationID, "bar")
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+	pins, err = conversations.GetPins(msg.ConversationID, "foo")
+	require.NoError(t, err)
+	assert.Len(t, pins, 1)
+
+	_, err = services.NewBlocksService(srv.Store).Block("bar", "foo")
+	require.NoError(t, err)
+	group, err := srv.Store.CreateConversation([]string{"foo", "bar", "baz"})
+	require.NoError(t, err)
+	groupMsg := &models.Message{ID: uuid.NewString(), ConversationID: group.ID, Content: "hi", Sender: "foo", CreatedAt: time.Now(), Version: 1}
+	require.NoError(t, srv.AddMessage(groupMsg))
+	_, err = conversations.PinMessage(group.ID, "baz", groupMsg.ID)
+	require.NoError(t, err)
+
+	pins, err = conversations.GetPins(group.ID, "bar")
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+	pins, err = conversations.GetPins(group.ID, "baz")
+	require.NoError(t, err)
+	assert.Len(t, pins, 1)
+}
+
+func TestForwardMessageKeepsProvenance(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	srv.Publisher = publisher
+
+	source := sendMessage(t, srv, "foo", "bar", "look at this")
+	attachment := &models.Attachment{ID: uuid.NewString(), Uploader: "foo", Filename: "cat.png", StorageKey: "blobs/cat.png", CreatedAt: time.Now()}
+	require.NoError(t, srv.Store.CreateAttachment(attachment))
+	withAttachment := &models.Message{ID: uuid.NewString(), ConversationID: source.ConversationID, Content: "pic", Sender: "foo", Receiver: "bar", CreatedAt: time.Now(), Version: 1}
+	require.NoError(t, srv.AddMessageWithAttachments(withAttachment, []string{attachment.ID}))
+
+	target, err := srv.GetConversation("bar", "baz")
+	require.NoError(t, err)
+	forward, err := srv.ForwardMessage(withAttachment.ID, "bar", target.ID)
+	require.NoError(t, err)
+	assert.Equal(t, withAttachment.ID, *forward.ForwardedFromID)
+	assert.Equal(t, "foo", forward.ForwardedFrom)
+	assert.Equal(t, "baz", forward.Receiver)
+	require.Len(t, forward.Attachments, 1)
+	assert.NotEqual(t, attachment.ID, forward.Attachments[0].ID)
+	references, err := srv.Store.CountAttachmentsByStorageKey("blobs/cat.png")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), references)
+	require.Len(t, publisher.events, 1)
+
+	// forwarding the forward still points at the original
+	back, err := srv.GetConversation("baz", "foo")
+	require.NoError(t, err)
+	again, err := srv.ForwardMessage(forward.ID, "baz", back.ID)
+	require.NoError(t, err)
+	assert.Equal(t, withAttachment.ID, *again.ForwardedFromID)
+	original, err := srv.GetMessageByID(withAttachment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, original.ForwardCount)
+
+	_, err = srv.ForwardMessage(source.ID, "baz", back.ID)
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	_, err = srv.ForwardMessage(source.ID, "foo", target.ID)
+	assert.ErrorIs(t, err, services.ErrForbidden)
+
+	services.FREQUENTLY_FORWARDED_THRESHOLD = 3
+	defer func() { services.FREQUENTLY_FORWARDED_THRESHOLD = 5 }()
+	flagged, err := srv.ForwardMessage(withAttachment.ID, "foo", back.ID)
+	require.NoError(t, err)
+	assert.True(t, flagged.FrequentlyForwarded)
+}
+
+func TestContentIsEncryptedAtRest(t *testing.T) {
+	plain := newTestService(t)
+	key := make([]byte, encryption.KeySize)
+	copy(key, "a test master key of 32 bytes!!!")
+	ring, err := encryption.NewKeyRing(key)
+	require.NoError(t, err)
+	srv := services.NewMessagesService(services.NewEncryptingStore(plain.Store, encryption.NewContentCipher(ring, plain.Store)))
+
+	msg := sendMessage(t, srv, "foo", "bar", "secret")
+	assert.Equal(t, "secret", msg.Content)
+	stored, err := plain.Store.GetMessage(msg.ID)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(stored.Content))
+
+	edit := *msg
+	edit.Content = "still secret"
+	_, err = srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+	revisions, err := srv.GetMessageRevisions(msg.ID, "foo")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "secret", revisions[0].Content)
+	storedRevisions, err := plain.Store.ListRevisions(msg.ID)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(storedRevisions[0].Content))
+
+	conv, err := srv.GetConversationWithMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	require.Len(t, conv.Messages, 1)
+	assert.Equal(t, "still secret", conv.Messages[0].Content)
+	assert.NotContains(t, fmt.Sprintf("%v %+v", conv.Messages[0], &conv.Messages[0]), "secret")
+
+	draft := &models.Draft{ConversationID: conv.ID, Username: "foo", Content: "secret draft"}
+	require.NoError(t, srv.Store.SaveDraft(draft, 0))
+	assert.Equal(t, "secret draft", draft.Content)
+	storedDraft, err := plain.Store.GetDraft(conv.ID, "foo")
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(storedDraft.Content))
+	drafts, err := srv.Store.ListDrafts("foo", []uint{conv.ID})
+	require.NoError(t, err)
+	require.Len(t, drafts, 1)
+	assert.Equal(t, "secret draft", drafts[0].Content)
+
+	now := time.Now()
+	scheduled := &models.ScheduledMessage{Sender: "foo", Receiver: "bar", Content: "secret later", SendAt: now.Add(time.Hour)}
+	require.NoError(t, services.NewScheduledMessagesService(srv.Store).Schedule(scheduled))
+	assert.Equal(t, "secret later", scheduled.Content)
+	storedScheduled, err := plain.Store.GetScheduledMessage(scheduled.ID)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(storedScheduled.Content))
+	pending, err := srv.Store.ListScheduledMessages("foo", constants.ScheduledPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "secret later", pending[0].Content)
+
+	publisher := &recordingPublisher{}
+	require.NoError(t, services.NewMessageScheduler(srv, publisher).Tick(now.Add(2*time.Hour)))
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, "secret later", publisher.events[0].(models.WsMessage).Content)
+	fired, err := plain.Store.GetMessage(scheduled.ID)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(fired.Content))
+}
+
+func TestExportConversationPagesThroughHistory(t *testing.T) {
+	srv := newTestService(t)
+	services.EXPORT_PAGE_SIZE = 2
+	defer func() { services.EXPORT_PAGE_SIZE = 200 }()
+
+	var sent []*models.Message
+	for i := 0; i < 5; i++ {
+		sent = append(sent, sendMessage(t, srv, "foo", "bar", fmt.Sprintf("message %d", i)))
+	}
+	edit := *sent[1]
+	edit.Content = "message 1, edited"
+	_, err := srv.UpdateMessage(&edit)
+	require.NoError(t, err)
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: sent[2].ID, Username: "bar", Emoji: "👍", CreatedAt: time.Now()}))
+
+	var out bytes.Buffer
+	require.NoError(t, srv.ExportConversation(sent[0].ConversationID, "foo", services.ExportJSON, &out))
+	var transcript struct {
+		Messages []services.ExportedMessage `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &transcript))
+	require.Len(t, transcript.Messages, 5)
+	for i, msg := range transcript.Messages {
+		assert.Equal(t, sent[i].ID, msg.ID)
+	}
+	assert.Equal(t, "message 1, edited", transcript.Messages[1].Content)
+	require.Len(t, transcript.Messages[1].Revisions, 1)
+	assert.Equal(t, "message 1", transcript.Messages[1].Revisions[0].Content)
+	require.Len(t, transcript.Messages[2].Reactions, 1)
+
+	out.Reset()
+	require.NoError(t, srv.ExportConversation(sent[0].ConversationID, "", services.ExportCSV, &out))
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, rows, 6)
+
+	out.Reset()
+	err = srv.ExportConversation(sent[0].ConversationID, "baz", services.ExportHTML, &out)
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	assert.Zero(t, out.Len())
+}
+
+func TestRetentionAppliesToArchivedMessages(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	conversations := services.NewConversationsService(srv.Store, publisher)
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+
+	archiveDir := t.TempDir()
+	writer, err := archive.NewWriter(archiveDir, "messages_p2019_03")
+	require.NoError(t, err)
+	old := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "old", Sender: "foo", Receiver: "bar", CreatedAt: time.Now().Add(-48 * time.Hour)}
+	require.NoError(t, writer.Write(old))
+	_, err = writer.Commit()
+	require.NoError(t, err)
+	srv.Archive = archive.NewReader(archiveDir)
+
+	notice, err := conversations.SetRetention(conv.ID, "foo", constants.Retention24Hours)
+	require.NoError(t, err)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, notice.ID, publisher.events[0].(models.WsMessage).ID)
+
+	history, err := srv.GetConversationWithArchivedMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	require.Len(t, history.Messages, 1)
+	assert.Equal(t, notice.ID, history.Messages[0].ID)
+
+	var out bytes.Buffer
+	require.NoError(t, srv.ExportConversation(conv.ID, "foo", services.ExportJSON, &out))
+	assert.NotContains(t, out.String(), old.ID)
+
+	_, err = conversations.SetRetention(conv.ID, "foo", constants.RetentionOff)
+	require.NoError(t, err)
+	history, err = srv.GetConversationWithArchivedMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	assert.Len(t, history.Messages, 3)
+}
+
+func TestArchivedHistoryPagesByWhatTheViewerSees(t *testing.T) {
+	srv := newTestService(t)
+	services.MESSAGE_PAGINATION_SIZE = 2
+	defer func() { services.MESSAGE_PAGINATION_SIZE = 20 }()
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+
+	archiveDir := t.TempDir()
+	writer, err := archive.NewWriter(archiveDir, "messages_p2019_03")
+	require.NoError(t, err)
+	var archived []string
+	for i := 0; i < 3; i++ {
+		msg := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "old", Sender: "bar", Receiver: "foo", CreatedAt: time.Date(2019, 3, 10-i, 0, 0, 0, 0, time.UTC)}
+		require.NoError(t, writer.Write(msg))
+		archived = append(archived, msg.ID)
+	}
+	_, err = writer.Commit()
+	require.NoError(t, err)
+	srv.Archive = archive.NewReader(archiveDir)
+
+	// live messages bar does not see must not shift the archived pages
+	_, err = services.NewBlocksService(srv.Store).Block("bar", "foo")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		hidden := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "hidden", Sender: "foo", Receiver: "bar", CreatedAt: time.Now(), Version: 1}
+		require.NoError(t, srv.Store.CreateMessage(hidden, nil))
+	}
+
+	var seen []string
+	for offset := 0; offset < 4; offset += services.MESSAGE_PAGINATION_SIZE {
+		page, err := srv.GetConversationWithArchivedMessages("bar", "foo", offset)
+		require.NoError(t, err)
+		for _, msg := range page.Messages {
+			seen = append(seen, msg.ID)
+		}
+	}
+	assert.Equal(t, archived, seen)
+}
+
+func TestQuarantinedMessagesStayHiddenOnceArchived(t *testing.T) {
+	srv := newTestService(t)
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+
+	archiveDir := t.TempDir()
+	writer, err := archive.NewWriter(archiveDir, "messages_p2019_03")
+	require.NoError(t, err)
+	held := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "held", Sender: "foo", Receiver: "bar", Quarantined: true, CreatedAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, writer.Write(held))
+	_, err = writer.Commit()
+	require.NoError(t, err)
+	srv.Archive = archive.NewReader(archiveDir)
+
+	history, err := srv.GetConversationWithArchivedMessages("bar", "foo", 0)
+	require.NoError(t, err)
+	assert.Empty(t, history.Messages)
+	history, err = srv.GetConversationWithArchivedMessages("foo", "bar", 0)
+	require.NoError(t, err)
+	assert.Len(t, history.Messages, 1)
+
+	live := sendMessage(t, srv, "foo", "bar", "live")
+	require.NoError(t, srv.Store.CreateMessage(&models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "held too", Sender: "foo", Receiver: "bar", Quarantined: true, CreatedAt: time.Now(), Version: 1}, nil))
+	_, err = srv.GetVisibleMessage(live.ID, "bar")
+	require.NoError(t, err)
+	_, err = srv.GetVisibleMessage(live.ID, "baz")
+	assert.ErrorIs(t, err, services.ErrForbidden)
+	quarantined, err := srv.Store.ListQuarantinedMessages(0, 10)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	_, err = srv.GetVisibleMessage(quarantined[0].ID, "bar")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = srv.GetVisibleMessage(quarantined[0].ID, "foo")
+	require.NoError(t, err)
+}
+
+func TestImportIsResumableAndSkipsExistingMessages(t *testing.T) {
+	srv := newTestService(t)
+	services.IMPORT_BATCH_SIZE = 2
+	defer func() { services.IMPORT_BATCH_SIZE = 500 }()
+
+	existing := sendMessage(t, srv, "foo", "bar", "already here")
+	createdAt := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	ids := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+	lines := []string{
+		fmt.Sprintf(`{"id":%q,"sender":"bar","receiver":"foo","content":"legacy 1","createdAt":%q}`, ids[0], createdAt.Format(time.RFC3339)),
+		`{"id":"not-a-uuid","sender":"bar","receiver":"foo","content":"broken","createdAt":"2019-03-01T12:00:00Z"}`,
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"bar","content":"already here","createdAt":%q}`, existing.ID, existing.CreatedAt.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"baz","content":"legacy 2","createdAt":%q,"unknown":true}`, ids[1], createdAt.Format(time.RFC3339)),
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"baz","content":"legacy 3","createdAt":%q}`, ids[2], createdAt.Format(time.RFC3339)),
+	}
+	archive := strings.Join(lines, "\n") + "\n"
+
+	var checkpoints []int
+	report, err := services.NewImporter(srv).Import(strings.NewReader(archive), services.ImportOptions{
+		StartLine:  0,
+		Checkpoint: func(line int) error { checkpoints = append(checkpoints, line); return nil },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 2, report.Rejected)
+	assert.Equal(t, 5, report.LastLine)
+	assert.Equal(t, []int{3, 5}, checkpoints)
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, 2, report.Errors[0].Line)
+	assert.Equal(t, ids[1], report.Errors[1].ID)
+
+	imported, err := srv.GetMessageByID(ids[0])
+	require.NoError(t, err)
+	assert.True(t, imported.CreatedAt.Equal(createdAt))
+	assert.Equal(t, existing.ConversationID, imported.ConversationID)
+
+	// resuming after the checkpoint only reads the lines that follow it
+	report, err = services.NewImporter(srv).Import(strings.NewReader(archive), services.ImportOptions{StartLine: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 1, report.Rejected)
+}
+
+func TestImportDoesNotTrustDerivedFields(t *testing.T) {
+	srv := newTestService(t)
+
+	createdAt := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	original, forward := uuid.NewString(), uuid.NewString()
+	lines := []string{
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"bar","content":"original","createdAt":%q,"forwardCount":99}`, original, createdAt.Format(time.RFC3339)),
+		fmt.Sprintf(`{"id":%q,"sender":"bar","receiver":"baz","content":"original","createdAt":%q,"forwardedFromId":%q,"forwardedFrom":"foo","frequentlyForwarded":true}`, forward, createdAt.Add(time.Hour).Format(time.RFC3339), original),
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"bar","content":"held","createdAt":%q,"quarantined":true}`, uuid.NewString(), createdAt.Format(time.RFC3339)),
+		fmt.Sprintf(`{"id":%q,"sender":"foo","receiver":"bar","content":"gone","createdAt":%q,"redacted":true}`, uuid.NewString(), createdAt.Format(time.RFC3339)),
+	}
+
+	report, err := services.NewImporter(srv).Import(strings.NewReader(strings.Join(lines, "\n")), services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 2, report.Rejected)
+
+	imported, err := srv.GetMessageByID(original)
+	require.NoError(t, err)
+	assert.Equal(t, 1, imported.ForwardCount)
+	imported, err = srv.GetMessageByID(forward)
+	require.NoError(t, err)
+	assert.False(t, imported.FrequentlyForwarded)
+
+	// a forward imported after its original counts on it
+	later := fmt.Sprintf(`{"id":%q,"sender":"baz","receiver":"qux","content":"original","createdAt":%q,"forwardedFromId":%q,"forwardedFrom":"foo"}`, uuid.NewString(), createdAt.Add(2*time.Hour).Format(time.RFC3339), original)
+	_, err = services.NewImporter(srv).Import(strings.NewReader(later), services.ImportOptions{})
+	require.NoError(t, err)
+	imported, err = srv.GetMessageByID(original)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported.ForwardCount)
+}
+
+func TestEraseUserRedactsAndPseudonymizes(t *testing.T) {
+	srv := newTestService(t)
+	archiveDir := t.TempDir()
+	writer, err := archive.NewWriter(archiveDir, "messages_p2019_03")
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(&models.Message{ID: uuid.NewString(), Content: "old", Sender: "foo", Receiver: "bar", CreatedAt: time.Now()}))
+	_, err = writer.Commit()
+	require.NoError(t, err)
+
+	erasure := services.NewErasureService(srv.Store, nil, nil)
+	erasure.ArchiveRewriter = archive.NewReader(archiveDir)
+	erasure.Archive = archive.NewReader(archiveDir)
+
+	sent := sendMessage(t, srv, "foo", "bar", "my secret")
+	received := sendMessage(t, srv, "bar", "foo", "hi foo")
+	require.NoError(t, srv.AddReaction(&models.Reaction{MessageID: received.ID, Username: "foo", Emoji: "👍", CreatedAt: time.Now()}))
+	require.NoError(t, srv.Store.SaveDraft(&models.Draft{ConversationID: sent.ConversationID, Username: "foo", Content: "unsent", Version: 1, UpdatedAt: time.Now()}, 0))
+
+	var before bytes.Buffer
+	require.NoError(t, erasure.ExportUserData("foo", &before))
+	var export struct {
+		Records  repository.UserRecords `json:"records"`
+		Messages []services.ExportedMessage
+	}
+	require.NoError(t, json.Unmarshal(before.Bytes(), &export))
+	assert.Len(t, export.Messages, 3)
+	assert.Len(t, export.Records.Reactions, 1)
+	assert.Len(t, export.Records.Drafts, 1)
+
+	record, err := erasure.EraseUser("foo", services.ErasureSourceAdmin, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, constants.ErasureCompleted, record.Status)
+	assert.Equal(t, models.SubjectHash("foo"), record.SubjectHash)
+	assert.Equal(t, int64(1), record.MessagesRedacted)
+	assert.Equal(t, int64(1), record.MessagesPseudonymized)
+	assert.Equal(t, int64(1), record.ConversationsUpdated)
+	assert.Equal(t, int64(1), record.ArchivedMessages)
+
+	redacted, err := srv.GetMessageByID(sent.ID)
+	require.NoError(t, err)
+	assert.True(t, redacted.Redacted)
+	assert.Empty(t, redacted.Content)
+	assert.Equal(t, record.Pseudonym, redacted.Sender)
+	edit := *redacted
+	edit.Content = "my secret again"
+	_, err = srv.UpdateMessage(&edit)
+	assert.ErrorIs(t, err, services.ErrRedacted)
+	read := *redacted
+	read.Read = true
+	read.Status = constants.MessageReadKey
+	_, err = srv.UpdateMessage(&read)
+	require.NoError(t, err)
+	redacted, err = srv.GetMessageByID(sent.ID)
+	require.NoError(t, err)
+	assert.Empty(t, redacted.Content)
+	assert.True(t, redacted.Read)
+	other, err := srv.GetMessageByID(received.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hi foo", other.Content)
+	assert.Equal(t, record.Pseudonym, other.Receiver)
+
+	conv, err := srv.GetConversationByID(sent.ConversationID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar"}, []string(conv.Participants))
+	// a new account with the same name does not inherit the conversation
+	fresh, err := srv.GetConversation("bar", "foo")
+	require.NoError(t, err)
+	assert.NotEqual(t, conv.ID, fresh.ID)
+
+	var after bytes.Buffer
+	require.NoError(t, erasure.ExportUserData("foo", &after))
+	export.Messages = nil
+	require.NoError(t, json.Unmarshal(after.Bytes(), &export))
+	assert.Empty(t, export.Messages)
+	assert.Empty(t, export.Records.Reactions)
+	assert.Empty(t, export.Records.Drafts)
+
+	stored, err := erasure.GetErasure(record.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.CompletedAt)
+	assert.NotContains(t, fmt.Sprintf("%+v", stored), "foo")
+}
+
+func TestModerationMasksQuarantinesAndRejects(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	srv.Publisher = publisher
+	var err error
+	srv.Moderator, err = moderation.ParseConfig([]byte(`{
+		"direct": [
+			{"type": "words", "action": "mask", "words": ["darn"]},
+			{"type": "links", "action": "quarantine", "allow": ["example.com"]},
+			{"type": "maxLength", "action": "reject", "max": 40}
+		]
+	}`))
+	require.NoError(t, err)
+
+	masked := sendMessage(t, srv, "foo", "bar", "darn it")
+	assert.Equal(t, "**** it", masked.Content)
+	assert.False(t, masked.Quarantined)
+
+	held := sendMessage(t, srv, "foo", "bar", "see https://evil.test/x")
+	assert.True(t, held.Quarantined)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, held.ID, publisher.events[0].(models.MessageQuarantinedEvent).MessageID)
+	visible, err := srv.Store.ListMessagesVisibleTo(held.ConversationID, "bar", 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, visible, 1)
+	visible, err = srv.Store.ListMessagesVisibleTo(held.ConversationID, "foo", 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, visible, 2)
+
+	tooLong := &models.Message{ID: uuid.NewString(), ConversationID: held.ConversationID, Content: strings.Repeat("a", 41), Sender: "foo", Receiver: "bar", CreatedAt: time.Now(), Version: 1}
+	assert.ErrorIs(t, srv.AddMessage(tooLong), services.ErrRejected)
+	_, err = srv.GetMessageByID(tooLong.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	decisions, err := srv.Store.ListModerationDecisions(tooLong.ID)
+	require.NoError(t, err)
+	require.Len(t, decisions, 1)
+	assert.Equal(t, constants.ModerationReject, decisions[0].Action)
+
+	review := services.NewModerationService(srv, nil)
+	queue, err := review.ListQuarantined(0)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	require.Len(t, queue[0].ModerationDecisions, 1)
+	assert.Equal(t, "links", queue[0].ModerationDecisions[0].Filter)
+	released, err := review.Release(held.ID)
+	require.NoError(t, err)
+	assert.False(t, released.Quarantined)
+	assert.Equal(t, held.ID, publisher.events[1].(models.WsMessage).ID)
+	_, err = review.Release(held.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	again := sendMessage(t, srv, "foo", "bar", "www.evil.test")
+	require.NoError(t, review.Reject(again.ID, "mod", "spam"))
+	_, err = srv.GetMessageByID(again.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	decisions, err = review.GetDecisions(again.ID)
+	require.NoError(t, err)
+	assert.Len(t, decisions, 2)
+	assert.Equal(t, "spam", publisher.events[len(publisher.events)-1].(models.MessageRejectedEvent).Reason)
+}
+
+func TestSpamIsThrottledHiddenAndFlagged(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	srv.Publisher = publisher
+	config := spam.DefaultConfig()
+	config.Rate = spam.Limit{Max: 1, Window: spam.Duration(time.Hour)}
+	config.Thresholds = map[constants.SpamAction]float64{
+		constants.SpamThrottle: 1,
+		constants.SpamFlag:     3,
+		constants.SpamHide:     4,
+	}
+	var err error
+	srv.Spam, err = spam.NewDetector(config, spam.NewMemoryCounters())
+	require.NoError(t, err)
+
+	sendMessage(t, srv, "foo", "bar", "first")
+	conv, err := srv.GetConversation("foo", "bar")
+	require.NoError(t, err)
+	throttled := &models.Message{ID: uuid.NewString(), ConversationID: conv.ID, Content: "second", Sender: "foo", Receiver: "bar", CreatedAt: time.Now(), Version: 1}
+	assert.ErrorIs(t, srv.AddMessage(throttled), services.ErrThrottled)
+	assert.Empty(t, publisher.events)
+
+	// the third is three times the limit and flags the sender
+	assert.ErrorIs(t, srv.AddMessage(throttled), services.ErrThrottled)
+	require.Len(t, publisher.events, 1)
+	flagged := publisher.events[0].(models.UserFlaggedEvent)
+	assert.Equal(t, "foo", flagged.Username)
+	assert.Equal(t, 3.0, flagged.Score)
+
+	hidden := sendMessage(t, srv, "foo", "bar", "fourth")
+	assert.True(t, hidden.Quarantined)
+	visible, err := srv.Store.ListMessagesVisibleTo(conv.ID, "bar", 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, visible, 1)
+	decisions, err := srv.Store.ListModerationDecisions(hidden.ID)
+	require.NoError(t, err)
+	require.Len(t, decisions, 1)
+	assert.Equal(t, "spam", decisions[0].Filter)
+}
+
+func TestReportsAreSnapshottedAndResolved(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	srv.Publisher = publisher
+	reports := services.NewReportsService(srv, nil)
+
+	sendMessage(t, srv, "bar", "foo", "hi")
+	offending := sendMessage(t, srv, "foo", "bar", "you are awful")
+	sendMessage(t, srv, "bar", "foo", "excuse me?")
+
+	_, err := reports.Report(offending.ID, "bar", " ")
+	assert.ErrorIs(t, err, services.ErrInvalidReport)
+	_, err = reports.Report(offending.ID, "foo", "myself")
+	assert.ErrorIs(t, err, services.ErrInvalidReport)
+	_, err = reports.Report(offending.ID, "baz", "not mine to see")
+	assert.ErrorIs(t, err, services.ErrForbidden)
+
+	report, err := reports.Report(offending.ID, "bar", "insult")
+	require.NoError(t, err)
+	assert.Equal(t, "you are awful", report.Snapshot.Content)
+	require.Len(t, report.Context, 2)
+	assert.Equal(t, "hi", report.Context[0].Content)
+	assert.Equal(t, "excuse me?", report.Context[1].Content)
+	_, err = reports.Report(offending.ID, "bar", "again")
+	assert.ErrorIs(t, err, repository.ErrDuplicate)
+
+	// the snapshot outlives edits of the message
+	offending.Content = "you are great"
+	_, err = srv.UpdateMessage(offending)
+	require.NoError(t, err)
+	stored, err := reports.GetReport(report.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "you are awful", stored.Snapshot.Content)
+
+	_, err = reports.Resolve(report.ID, "mod1", constants.ReportDeleteMessage, "")
+	assert.ErrorIs(t, err, repository.ErrVersionConflict, "claimed first")
+	_, err = reports.Claim(report.ID, "mod1")
+	require.NoError(t, err)
+	_, err = reports.Claim(report.ID, "mod2")
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+	_, err = reports.Resolve(report.ID, "mod1", "ban", "")
+	assert.ErrorIs(t, err, services.ErrInvalidReportAction)
+
+	resolved, err := reports.Resolve(report.ID, "mod1", constants.ReportDeleteMessage, "removed")
+	require.NoError(t, err)
+	assert.Equal(t, constants.ReportResolved, resolved.Status)
+	_, err = srv.GetMessageByID(offending.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	deleted := publisher.events[len(publisher.events)-1].(models.MessageDeletedEvent)
+	assert.Equal(t, offending.ID, deleted.MessageID)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, deleted.Participants)
+
+	other := sendMessage(t, srv, "foo", "bar", "still awful")
+	report, err = reports.Report(other.ID, "bar", "insult")
+	require.NoError(t, err)
+	_, err = reports.Claim(report.ID, "mod1")
+	require.NoError(t, err)
+	_, err = reports.Resolve(report.ID, "mod1", constants.ReportWarnSender, "be nice")
+	require.NoError(t, err)
+	warned := publisher.events[len(publisher.events)-1].(models.UserWarnedEvent)
+	assert.Equal(t, "foo", warned.Username)
+	assert.Equal(t, "be nice", warned.Note)
+
+	last := sendMessage(t, srv, "foo", "bar", "one more")
+	report, err = reports.Report(last.ID, "bar", "insult")
+	require.NoError(t, err)
+	_, err = reports.Claim(report.ID, "mod1")
+	require.NoError(t, err)
+	_, err = reports.Resolve(report.ID, "mod1", constants.ReportBlockSender, "")
+	require.NoError(t, err)
+	blockers, err := srv.Store.ListBlockers("foo", []string{"bar"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar"}, blockers)
+
+	open, err := reports.ListReports(constants.ReportOpen, 0)
+	require.NoError(t, err)
+	assert.Empty(t, open)
+}
+
+func TestAdminCorrectsStatusAndReemitsEvents(t *testing.T) {
+	srv := newTestService(t)
+	publisher := &recordingPublisher{}
+	srv.Publisher = publisher
+	admin := services.NewAdminService(srv)
+
+	msg := sendMessage(t, srv, "foo", "bar", "hello")
+	publisher.events = nil
+	assert.ErrorIs(t, admin.Reemit(msg.ID, constants.MessageReadKey), services.ErrInvalidEvent, "the message was never read")
+
+	_, _, err := admin.CorrectStatus(msg.ID, "message.lost")
+	assert.ErrorIs(t, err, services.ErrInvalidStatus)
+	corrected, previous, err := admin.CorrectStatus(msg.ID, constants.MessageReadKey)
+	require.NoError(t, err)
+	assert.Equal(t, constants.MessageSentKey, previous)
+	assert.True(t, corrected.Read)
+
+	history, err := admin.GetMessageHistory(msg.ID)
+	require.NoError(t, err)
+	assert.Equal(t, constants.MessageReadKey, history.Message.Status)
+	require.Len(t, history.Receipts, 1)
+	assert.Equal(t, "bar", history.Receipts[0].Username)
+	assert.NotNil(t, history.Receipts[0].ReadAt)
+
+	require.NoError(t, admin.Reemit(msg.ID, constants.MessageReadKey))
+	require.NoError(t, admin.Reemit(msg.ID, constants.MessageSentKey))
+	require.Len(t, publisher.events, 2)
+	assert.Equal(t, constants.MessageUpdate, publisher.events[0].(models.WsMessage).Type)
+	assert.Equal(t, constants.MessageSentKey, publisher.events[1].(models.WsMessage).Status)
+	assert.ErrorIs(t, admin.Reemit(msg.ID, constants.MessageDeletedKey), repository.ErrNotFound)
+
+	_, err = srv.Store.DeleteMessage(msg.ID, "admin", "cleanup")
+	require.NoError(t, err)
+	history, err = admin.GetMessageHistory(msg.ID)
+	require.NoError(t, err)
+	assert.Nil(t, history.Message)
+	assert.Equal(t, "admin", history.Tombstone.DeletedBy)
+	require.NoError(t, admin.Reemit(msg.ID, constants.MessageDeletedKey))
+	assert.ElementsMatch(t, []string{"foo", "bar"}, publisher.events[2].(models.MessageDeletedEvent).Participants)
+	entries, err := admin.ListHistory(msg.ConversationID, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.NotNil(t, entries[0].Tombstone)
+
+	other, err := srv.GetConversation("foo", "baz")
+	require.NoError(t, err)
+	_, err = admin.MergeConversations(msg.ConversationID, []uint{other.ID})
+	assert.ErrorIs(t, err, services.ErrInvalidMerge)
+	_, err = admin.MergeConversations(msg.ConversationID, []uint{msg.ConversationID})
+	assert.ErrorIs(t, err, services.ErrInvalidMerge)
+
+	found, err := admin.FindConversations("foo", 0)
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+}
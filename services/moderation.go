@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var QUARANTINE_PAGINATION_SIZE = 50
+
+type ModerationServiceInterface interface {
+	ListQuarantined(offset int) ([]models.Message, error)
+	GetDecisions(messageID string) ([]models.ModerationDecision, error)
+	Release(messageID string) (*models.Message, error)
+	Reject(messageID, reviewer, reason string) error
+}
+
+// ModerationService is the review queue of the messages the filters quarantined
+type ModerationService struct {
+	Messages *MessagesService
+	Blobs    blobstore.BlobStore
+}
+
+func NewModerationService(messages *MessagesService, blobs blobstore.BlobStore) *ModerationService {
+	return &ModerationService{
+		Messages: messages,
+		Blobs:    blobs,
+	}
+}
+
+// moderate runs the filters of the conversation type on the content. Masking replaces
+// the content, quarantining flags the message and a rejection fails with ErrRejected
+// once the decisions are recorded. Other decisions are saved along with the message,
+// after the ones taken before moderation
+func (srv *MessagesService) moderate(msg *models.Message, conv *models.Conversation) error {
+	if srv.Moderator == nil {
+		return nil
+	}
+	result := srv.Moderator.Check(conversationType(conv), msg.Content)
+	if len(result.Decisions) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	decisions := msg.ModerationDecisions
+	reasons := make([]string, len(result.Decisions))
+	for i, decision := range result.Decisions {
+		decisions = append(decisions, models.ModerationDecision{
+			MessageID: msg.ID,
+			Sender:    msg.Sender,
+			Filter:    decision.Filter,
+			Action:    decision.Action,
+			Reason:    decision.Reason,
+			CreatedAt: now,
+		})
+		reasons[i] = decision.Reason
+	}
+
+	if result.Action == constants.ModerationReject {
+		if err := srv.Store.SaveModerationDecisions(decisions); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrRejected, strings.Join(reasons, ", "))
+	}
+	msg.Content = result.Content
+	msg.Quarantined = msg.Quarantined || result.Action == constants.ModerationQuarantine
+	msg.ModerationDecisions = decisions
+	return nil
+}
+
+// conversationType tells direct conversations from groups, the moderation rules differ
+func conversationType(conv *models.Conversation) constants.ConversationType {
+	if len(conv.Participants) > 2 {
+		return constants.GroupConversation
+	}
+	return constants.DirectConversation
+}
+
+// publishQuarantine tells the websocket service to hold the message back, failures
+// are only logged
+func (srv *MessagesService) publishQuarantine(msg *models.Message) {
+	if srv.Publisher == nil {
+		return
+	}
+	if err := srv.Publisher.Publish(constants.MessageEventsExchange, constants.MessageQuarantinedKey, quarantinedEvent(msg)); err != nil {
+		log.Printf("error publishing quarantine of message %v: %v\n", msg.ID, err)
+	}
+}
+
+func quarantinedEvent(msg *models.Message) models.MessageQuarantinedEvent {
+	return models.MessageQuarantinedEvent{
+		MessageID:      msg.ID,
+		ConversationID: msg.ConversationID,
+		Sender:         msg.Sender,
+		Receiver:       msg.Receiver,
+	}
+}
+
+// heldFrom tells whether the message is quarantined and the viewer did not send it
+func heldFrom(msg *models.Message, viewer string) bool {
+	return msg.Quarantined && msg.Sender != viewer
+}
+
+// hideQuarantined drops the quarantined messages the viewer did not send, for messages
+// that the store could not filter
+func hideQuarantined(messages []models.Message, viewer string) []models.Message {
+	visible := messages[:0]
+	for _, msg := range messages {
+		if heldFrom(&msg, viewer) {
+			continue
+		}
+		visible = append(visible, msg)
+	}
+	return visible
+}
+
+func (srv *ModerationService) ListQuarantined(offset int) ([]models.Message, error) {
+	return srv.Messages.Store.ListQuarantinedMessages(offset, QUARANTINE_PAGINATION_SIZE)
+}
+
+func (srv *ModerationService) GetDecisions(messageID string) ([]models.ModerationDecision, error) {
+	return srv.Messages.Store.ListModerationDecisions(messageID)
+}
+
+// Release delivers the quarantined message like a new one, its mentions are not
+// notified since quarantined messages are saved without them
+func (srv *ModerationService) Release(messageID string) (*models.Message, error) {
+	if err := srv.Messages.Store.ReleaseQuarantinedMessage(messageID); err != nil {
+		return nil, err
+	}
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := srv.Messages.Store.ListAttachments(messageID)
+	if err != nil {
+		return nil, err
+	}
+	attachmentIDs := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		attachmentIDs[i] = attachment.ID
+	}
+
+	srv.Messages.publishSent(msg, attachmentIDs)
+	return msg, nil
+}
+
+// Reject deletes the quarantined message and tells its sender, the decisions that
+// held it back are kept along with the reviewer's
+func (srv *ModerationService) Reject(messageID, reviewer, reason string) error {
+	decisions, err := srv.Messages.Store.ListModerationDecisions(messageID)
+	if err != nil {
+		return err
+	}
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if !msg.Quarantined {
+		// only messages held for review are rejected here
+		return repository.ErrNotFound
+	}
+	msg, err = srv.Messages.Store.DeleteMessage(messageID, reviewer, reason)
+	if err != nil {
+		return err
+	}
+
+	for i := range decisions {
+		decisions[i].ID = 0
+	}
+	decisions = append(decisions, models.ModerationDecision{
+		MessageID: msg.ID,
+		Sender:    msg.Sender,
+		Filter:    "review:" + reviewer,
+		Action:    constants.ModerationReject,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	if err := srv.Messages.Store.SaveModerationDecisions(decisions); err != nil {
+		log.Printf("error saving the review of message %v: %v\n", msg.ID, err)
+	}
+
+	if srv.Blobs != nil {
+		for _, attachment := range msg.Attachments {
+			deleteBlob(context.Background(), srv.Messages.Store, srv.Blobs, attachment.StorageKey)
+		}
+	}
+	if reason == "" {
+		reason = "rejected by a moderator"
+	}
+	srv.Messages.PublishRejection(msg, reason)
+	return nil
+}
@@ -0,0 +1,8 @@
+package services
+
+import "github.com/yonraz/gochat_messages/constants"
+
+// EventPublisher is implemented by publishers.Publisher
+type EventPublisher interface {
+	Publish(exchange constants.Exchange, routingKey constants.RoutingKey, payload interface{}) error
+}
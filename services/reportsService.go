@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var REPORT_CONTEXT_SIZE = 5
+var REPORT_REASON_MAX_LENGTH = 1000
+var REPORTS_PAGINATION_SIZE = 50
+
+type ReportsServiceInterface interface {
+	Report(messageID, reporter, reason string) (*models.Report, error)
+	ListReports(status constants.ReportStatus, offset int) ([]models.Report, error)
+	GetReport(id string) (*models.Report, error)
+	Claim(id, moderator string) (*models.Report, error)
+	Resolve(id, moderator string, action constants.ReportAction, note string) (*models.Report, error)
+}
+
+// ReportsService files the users' reports and takes the moderators through them
+type ReportsService struct {
+	Messages *MessagesService
+	Blobs    blobstore.BlobStore
+}
+
+func NewReportsService(messages *MessagesService, blobs blobstore.BlobStore) *ReportsService {
+	return &ReportsService{
+		Messages: messages,
+		Blobs:    blobs,
+	}
+}
+
+// Report files a report about a message the reporter can see, along with copies of the
+// messages around it as the reporter saw them. A message is reported once per user
+func (srv *ReportsService) Report(messageID, reporter, reason string) (*models.Report, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len([]rune(reason)) > REPORT_REASON_MAX_LENGTH {
+		return nil, ErrInvalidReport
+	}
+	msg, err := srv.Messages.Store.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.Messages.ensureVisible(msg, reporter); err != nil {
+		return nil, err
+	}
+	if msg.Sender == reporter {
+		return nil, ErrInvalidReport
+	}
+
+	context, err := srv.context(msg, reporter)
+	if err != nil {
+		return nil, err
+	}
+	report := &models.Report{
+		ID:             uuid.NewString(),
+		MessageID:      msg.ID,
+		ConversationID: msg.ConversationID,
+		Reporter:       reporter,
+		Sender:         msg.Sender,
+		Reason:         reason,
+		Snapshot:       snapshot(msg),
+		Context:        context,
+		Status:         constants.ReportOpen,
+	}
+	if err := srv.Messages.Store.CreateReport(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// context copies up to REPORT_CONTEXT_SIZE messages on each side of the reported one
+func (srv *ReportsService) context(msg *models.Message, reporter string) ([]models.MessagePreview, error) {
+	before, err := srv.Messages.Store.ListMessagesBefore(msg.ConversationID, msg.CreatedAt, msg.ID, REPORT_CONTEXT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	after, err := srv.Messages.Store.ListMessagesAfter(msg.ConversationID, msg.CreatedAt, msg.ID, REPORT_CONTEXT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	surrounding := make([]models.Message, 0, len(before)+len(after))
+	for i := len(before) - 1; i >= 0; i-- {
+		surrounding = append(surrounding, before[i])
+	}
+	surrounding = append(surrounding, after...)
+	surrounding, err = srv.Messages.hideBlocked(hideQuarantined(surrounding, reporter), reporter)
+	if err != nil {
+		return nil, err
+	}
+
+	context := make([]models.MessagePreview, len(surrounding))
+	for i := range surrounding {
+		context[i] = snapshot(&surrounding[i])
+	}
+	return context, nil
+}
+
+func snapshot(msg *models.Message) models.MessagePreview {
+	return models.MessagePreview{
+		ID:        msg.ID,
+		Sender:    msg.Sender,
+		Content:   msg.Content,
+		CreatedAt: msg.CreatedAt,
+	}
+}
+
+func (srv *ReportsService) ListReports(status constants.ReportStatus, offset int) ([]models.Report, error) {
+	return srv.Messages.Store.ListReports(status, offset, REPORTS_PAGINATION_SIZE)
+}
+
+func (srv *ReportsService) GetReport(id string) (*models.Report, error) {
+	return srv.Messages.Store.GetReport(id)
+}
+
+// Claim assigns the report to the moderator, it fails with ErrVersionConflict when
+// another moderator claimed it first
+func (srv *ReportsService) Claim(id, moderator string) (*models.Report, error) {
+	if err := srv.Messages.Store.ClaimReport(id, moderator, time.Now()); err != nil {
+		return nil, err
+	}
+	return srv.Messages.Store.GetReport(id)
+}
+
+// Resolve takes the action on a report the moderator claimed. Deleting a message that
+// is already gone still resolves the report
+func (srv *ReportsService) Resolve(id, moderator string, action constants.ReportAction, note string) (*models.Report, error) {
+	switch action {
+	case constants.ReportDismiss, constants.ReportDeleteMessage, constants.ReportWarnSender, constants.ReportBlockSender:
+	default:
+		return nil, ErrInvalidReportAction
+	}
+	report, err := srv.Messages.Store.GetReport(id)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != constants.ReportClaimed || report.ClaimedBy != moderator {
+		return nil, repository.ErrVersionConflict
+	}
+
+	switch action {
+	case constants.ReportDeleteMessage:
+		err = srv.deleteMessage(report)
+	case constants.ReportWarnSender:
+		srv.publishWarning(report, note)
+	case constants.ReportBlockSender:
+		err = srv.Messages.Store.BlockUser(&models.Block{
+			Blocker:   report.Reporter,
+			Blocked:   report.Sender,
+			CreatedAt: time.Now(),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedAt := time.Now()
+	report.Status = constants.ReportResolved
+	report.ResolvedBy = moderator
+	report.ResolvedAt = &resolvedAt
+	report.Resolution = action
+	report.Note = note
+	if err := srv.Messages.Store.ResolveReport(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (srv *ReportsService) deleteMessage(report *models.Report) error {
+	msg, err := srv.Messages.Store.DeleteMessage(report.MessageID, report.ClaimedBy, "report:"+report.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if srv.Blobs != nil {
+		for _, attachment := range msg.Attachments {
+			deleteBlob(context.Background(), srv.Messages.Store, srv.Blobs, attachment.StorageKey)
+		}
+	}
+	if srv.Messages.Publisher == nil {
+		return nil
+	}
+	event := deletedEvent(srv.Messages.Store, msg.ConversationID, msg.ID)
+	if err := srv.Messages.Publisher.Publish(constants.MessageEventsExchange, constants.MessageDeletedKey, event); err != nil {
+		log.Printf("error publishing deletion of message %v: %v\n", msg.ID, err)
+	}
+	return nil
+}
+
+// deletedEvent goes to the participants the conversation has now
+func deletedEvent(store repository.Store, conversationID uint, messageID string) models.MessageDeletedEvent {
+	event := models.MessageDeletedEvent{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+	}
+	if conv, err := store.GetConversationByID(conversationID); err == nil {
+		event.Participants = conv.Participants
+	}
+	return event
+}
+
+// publishWarning leaves warning the user to the user service, failures are only logged
+func (srv *ReportsService) publishWarning(report *models.Report, note string) {
+	if srv.Messages.Publisher == nil {
+		return
+	}
+	event := models.UserWarnedEvent{
+		Username:  report.Sender,
+		ReportID:  report.ID,
+		MessageID: report.MessageID,
+		Note:      note,
+	}
+	if err := srv.Messages.Publisher.Publish(constants.UserEventsExchange, constants.UserWarnedKey, event); err != nil {
+		log.Printf("error publishing warning for report %v: %v\n", report.ID, err)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yonraz/gochat_messages/blobstore"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+var RETENTION_SWEEP_BATCH_SIZE = 500
+
+// RetentionSweeper hard deletes the messages that outlived their conversation's
+// retention policy and tells the websocket service to drop them
+type RetentionSweeper struct {
+	Store     repository.Store
+	Blobs     blobstore.BlobStore
+	Publisher EventPublisher
+}
+
+func NewRetentionSweeper(store repository.Store, blobs blobstore.BlobStore, publisher EventPublisher) *RetentionSweeper {
+	return &RetentionSweeper{
+		Store:     store,
+		Blobs:     blobs,
+		Publisher: publisher,
+	}
+}
+
+func (s *RetentionSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sweep(ctx, time.Now()); err != nil {
+			log.Printf("error sweeping expired messages: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *RetentionSweeper) Sweep(ctx context.Context, now time.Time) error {
+	conversations, err := s.Store.ListConversationsWithRetention()
+	if err != nil {
+		return err
+	}
+
+	for _, conv := range conversations {
+		duration, ok := retentionDurations[conv.Retention]
+		if !ok {
+			continue
+		}
+		if err := s.sweepConversation(ctx, &conv, now.Add(-duration)); err != nil {
+			log.Printf("error sweeping conversation %v: %v\n", conv.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *RetentionSweeper) sweepConversation(ctx context.Context, conv *models.Conversation, before time.Time) error {
+	for {
+		expired, err := s.Store.DeleteExpiredMessages(conv.ID, before, RETENTION_SWEEP_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		event := models.MessagesExpiredEvent{
+			ConversationID: conv.ID,
+			Participants:   conv.Participants,
+		}
+		for _, msg := range expired {
+			event.MessageIDs = append(event.MessageIDs, msg.ID)
+			for _, attachment := range msg.Attachments {
+				deleteBlob(ctx, s.Store, s.Blobs, attachment.StorageKey)
+			}
+		}
+		if err := s.Publisher.Publish(constants.MessageEventsExchange, constants.MessageExpiredKey, event); err != nil {
+			log.Printf("error publishing expired messages of conversation %v: %v\n", conv.ID, err)
+		}
+		log.Printf("deleted %d expired messages from conversation %v\n", len(expired), conv.ID)
+
+		if len(expired) < RETENTION_SWEEP_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// deleteBlob keeps blobs that forwarded attachments still point at
+func deleteBlob(ctx context.Context, store repository.Store, blobs blobstore.BlobStore, storageKey string) {
+	references, err := store.CountAttachmentsByStorageKey(storageKey)
+	if err != nil {
+		log.Printf("error counting references to blob %v: %v\n", storageKey, err)
+		return
+	}
+	if references > 0 {
+		return
+	}
+	if err := blobs.Delete(ctx, storageKey); err != nil {
+		log.Printf("error deleting blob %v: %v\n", storageKey, err)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/repository"
+)
+
+type ScheduledMessagesServiceInterface interface {
+	Schedule(scheduled *models.ScheduledMessage) error
+	ListPending(user string) ([]models.ScheduledMessage, error)
+	Get(id, user string) (*models.ScheduledMessage, error)
+	Update(scheduled *models.ScheduledMessage) error
+	Cancel(id, user string) error
+}
+
+type ScheduledMessagesService struct {
+	Store repository.Store
+}
+
+func NewScheduledMessagesService(store repository.Store) *ScheduledMessagesService {
+	return &ScheduledMessagesService{
+		Store: store,
+	}
+}
+
+// Schedule saves a pending message of scheduled.Sender, the ID is generated here
+func (srv *ScheduledMessagesService) Schedule(scheduled *models.ScheduledMessage) error {
+	if err := validateSchedule(scheduled, time.Now()); err != nil {
+		return err
+	}
+	scheduled.ID = uuid.NewString()
+	scheduled.Status = constants.ScheduledPending
+	return srv.Store.CreateScheduledMessage(scheduled)
+}
+
+func (srv *ScheduledMessagesService) ListPending(user string) ([]models.ScheduledMessage, error) {
+	return srv.Store.ListScheduledMessages(user, constants.ScheduledPending)
+}
+
+// Get hides the scheduled messages of other users as not found
+func (srv *ScheduledMessagesService) Get(id, user string) (*models.ScheduledMessage, error) {
+	scheduled, err := srv.Store.GetScheduledMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if scheduled.Sender != user {
+		return nil, repository.ErrNotFound
+	}
+	return scheduled, nil
+}
+
+// Update changes a message that has not been sent yet
+func (srv *ScheduledMessagesService) Update(scheduled *models.ScheduledMessage) error {
+	if err := validateSchedule(scheduled, time.Now()); err != nil {
+		return err
+	}
+	return srv.Store.UpdateScheduledMessage(scheduled)
+}
+
+func (srv *ScheduledMessagesService) Cancel(id, user string) error {
+	return srv.Store.CancelScheduledMessage(id, user)
+}
+
+func validateSchedule(scheduled *models.ScheduledMessage, now time.Time) error {
+	switch {
+	case scheduled.Receiver == "" || scheduled.Receiver == scheduled.Sender:
+		return fmt.Errorf("%w: invalid receiver", ErrInvalidSchedule)
+	case strings.TrimSpace(scheduled.Content) == "" && len(scheduled.Attachments) == 0:
+		return fmt.Errorf("%w: empty message", ErrInvalidSchedule)
+	case !scheduled.SendAt.After(now):
+		return fmt.Errorf("%w: sendAt must be in the future", ErrInvalidSchedule)
+	}
+	return nil
+}
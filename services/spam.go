@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/models"
+	"github.com/yonraz/gochat_messages/spam"
+)
+
+// checkSpam scores the sender on their recent messages. Throttled messages fail with
+// ErrThrottled, hidden ones are quarantined like moderated messages but the sender is
+// not told. The detector fails open, a message is never lost because Redis is down
+func (srv *MessagesService) checkSpam(msg *models.Message) error {
+	if srv.Spam == nil {
+		return nil
+	}
+	verdict, err := srv.Spam.Check(msg.Sender, msg.Content)
+	if err != nil {
+		log.Printf("error checking message %v for spam: %v\n", msg.ID, err)
+		return nil
+	}
+
+	if verdict.Has(constants.SpamFlag) {
+		srv.publishFlag(msg, verdict.Score, verdict.Signals)
+	}
+	if verdict.Has(constants.SpamHide) {
+		msg.Quarantined = true
+		msg.ModerationDecisions = append(msg.ModerationDecisions, models.ModerationDecision{
+			MessageID: msg.ID,
+			Sender:    msg.Sender,
+			Filter:    "spam",
+			Action:    constants.ModerationQuarantine,
+			Reason:    fmt.Sprintf("spam score %.2f", verdict.Score),
+			CreatedAt: time.Now(),
+		})
+		return nil
+	}
+	if verdict.Has(constants.SpamThrottle) {
+		return ErrThrottled
+	}
+	return nil
+}
+
+// publishFlag only logs failures, the detector does not flag the sender again until
+// the cooldown is over
+func (srv *MessagesService) publishFlag(msg *models.Message, score float64, signals []spam.Signal) {
+	if srv.Publisher == nil {
+		return
+	}
+	event := models.UserFlaggedEvent{
+		Username:       msg.Sender,
+		Score:          score,
+		Signals:        signals,
+		MessageID:      msg.ID,
+		ConversationID: msg.ConversationID,
+		FlaggedAt:      time.Now(),
+	}
+	if err := srv.Publisher.Publish(constants.UserEventsExchange, constants.UserFlaggedKey, event); err != nil {
+		log.Printf("error flagging %v: %v\n", msg.Sender, err)
+	}
+}
@@ -0,0 +1,83 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCounters keeps fixed window counters, each window is its own key and expires
+// with the window
+type RedisCounters struct {
+	client *redis.Client
+}
+
+func NewRedisCounters(client *redis.Client) *RedisCounters {
+	return &RedisCounters{
+		client: client,
+	}
+}
+
+func (c *RedisCounters) Incr(key string, at time.Time, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	bucket := fmt.Sprintf("%s:%d", key, at.UnixNano()/int64(window))
+	var incr *redis.IntCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, bucket)
+		pipe.Expire(ctx, bucket, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// FirstSeen keys never expire, they are one small key per sender
+func (c *RedisCounters) FirstSeen(key string, at time.Time) (time.Time, error) {
+	ctx := context.Background()
+	if err := c.client.SetNX(ctx, key, at.UnixNano(), 0).Err(); err != nil {
+		return time.Time{}, err
+	}
+	stored, err := c.client.Get(ctx, key).Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, stored), nil
+}
+
+// MemoryCounters is a single process stand-in for Redis in tests
+type MemoryCounters struct {
+	mu        sync.Mutex
+	counts    map[string]int64
+	firstSeen map[string]time.Time
+}
+
+func NewMemoryCounters() *MemoryCounters {
+	return &MemoryCounters{
+		counts:    make(map[string]int64),
+		firstSeen: make(map[string]time.Time),
+	}
+}
+
+func (c *MemoryCounters) Incr(key string, at time.Time, window time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// old windows are never read again, they are only left behind
+	bucket := fmt.Sprintf("%s:%d", key, at.UnixNano()/int64(window))
+	c.counts[bucket]++
+	return c.counts[bucket], nil
+}
+
+func (c *MemoryCounters) FirstSeen(key string, at time.Time) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seen, ok := c.firstSeen[key]; ok {
+		return seen, nil
+	}
+	c.firstSeen[key] = at
+	return at, nil
+}
@@ -0,0 +1,220 @@
+package spam
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yonraz/gochat_messages/constants"
+)
+
+// Signal is how far one heuristic got, it only adds to the score once Count is over Max
+type Signal struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	Max   int    `json:"max"`
+}
+
+// Verdict is the outcome of a check, Actions are the ones whose threshold the score reached
+type Verdict struct {
+	Score   float64
+	Signals []Signal
+	Actions []constants.SpamAction
+}
+
+func (v Verdict) Has(action constants.SpamAction) bool {
+	for _, taken := range v.Actions {
+		if taken == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Counters keeps the state of the signals, Redis in production so every replica
+// counts the same messages
+type Counters interface {
+	// Incr bumps the counter of the window the time falls in and returns its value
+	Incr(key string, at time.Time, window time.Duration) (int64, error)
+	// FirstSeen records the time for the key unless it has one, and returns the stored time
+	FirstSeen(key string, at time.Time) (time.Time, error)
+}
+
+// Limit is how many messages a signal lets through per window, a zero Max turns the signal off
+type Limit struct {
+	Max    int      `json:"max"`
+	Window Duration `json:"window"`
+}
+
+type Config struct {
+	// Rate counts the messages of the sender
+	Rate Limit `json:"rate"`
+	// Duplicates counts the messages of the sender with the same content, in any conversation
+	Duplicates Limit `json:"duplicates"`
+	// NewAccounts counts the messages of senders first seen less than NewAccountAge ago
+	NewAccounts   Limit    `json:"newAccounts"`
+	NewAccountAge Duration `json:"newAccountAge"`
+	// MinDuplicateLength keeps short replies such as "ok" out of the fingerprinting
+	MinDuplicateLength int `json:"minDuplicateLength"`
+	// Thresholds maps the actions to the score from which they are taken
+	Thresholds map[constants.SpamAction]float64 `json:"thresholds"`
+	// FlagCooldown is how long a flagged sender is not flagged again
+	FlagCooldown Duration `json:"flagCooldown"`
+}
+
+// DefaultConfig throttles senders over any limit and flags the ones far over, nothing
+// is hidden unless configured
+func DefaultConfig() Config {
+	return Config{
+		Rate:               Limit{Max: 30, Window: Duration(time.Minute)},
+		Duplicates:         Limit{Max: 5, Window: Duration(10 * time.Minute)},
+		NewAccounts:        Limit{Max: 10, Window: Duration(time.Minute)},
+		NewAccountAge:      Duration(24 * time.Hour),
+		MinDuplicateLength: 10,
+		Thresholds: map[constants.SpamAction]float64{
+			constants.SpamThrottle: 1,
+			constants.SpamFlag:     3,
+		},
+		FlagCooldown: Duration(time.Hour),
+	}
+}
+
+// Detector scores senders on their recent messages. The score is the sum of count/max
+// over the signals that are over their limit, so 0 means no limit was exceeded
+type Detector struct {
+	config   Config
+	counters Counters
+	// Clock is replaced in tests
+	Clock func() time.Time
+}
+
+func NewDetector(config Config, counters Counters) (*Detector, error) {
+	for action := range config.Thresholds {
+		if action != constants.SpamThrottle && action != constants.SpamHide && action != constants.SpamFlag {
+			return nil, fmt.Errorf("unknown spam action %q", action)
+		}
+	}
+	for name, limit := range map[string]Limit{"rate": config.Rate, "duplicates": config.Duplicates, "newAccounts": config.NewAccounts} {
+		if limit.Max < 0 || (limit.Max > 0 && limit.Window <= 0) {
+			return nil, fmt.Errorf("%v needs a positive max and window", name)
+		}
+	}
+	return &Detector{
+		config:   config,
+		counters: counters,
+		Clock:    time.Now,
+	}, nil
+}
+
+// LoadConfigFile reads a config on top of the defaults, fields left out keep their
+// default. Thresholds are replaced as a whole so actions can be turned off
+func LoadConfigFile(path string) (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	defaults := config.Thresholds
+	config.Thresholds = nil
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid spam config: %w", err)
+	}
+	if config.Thresholds == nil {
+		config.Thresholds = defaults
+	}
+	return config, nil
+}
+
+// Check counts the message against the sender's limits, so it has to be called once per
+// message. The counts are taken when the message arrives rather than when it was written
+func (d *Detector) Check(sender, content string) (Verdict, error) {
+	now := d.Clock()
+	var verdict Verdict
+
+	if d.config.Rate.Max > 0 {
+		count, err := d.counters.Incr("spam:rate:"+sender, now, time.Duration(d.config.Rate.Window))
+		if err != nil {
+			return verdict, err
+		}
+		verdict.add(Signal{Name: "rate", Count: count, Max: d.config.Rate.Max})
+	}
+
+	if d.config.Duplicates.Max > 0 && len([]rune(content)) >= d.config.MinDuplicateLength {
+		count, err := d.counters.Incr("spam:duplicates:"+sender+":"+Fingerprint(content), now, time.Duration(d.config.Duplicates.Window))
+		if err != nil {
+			return verdict, err
+		}
+		verdict.add(Signal{Name: "duplicates", Count: count, Max: d.config.Duplicates.Max})
+	}
+
+	if d.config.NewAccounts.Max > 0 {
+		// accounts are aged from the first message seen here, this service never sees signups
+		firstSeen, err := d.counters.FirstSeen("spam:seen:"+sender, now)
+		if err != nil {
+			return verdict, err
+		}
+		if now.Sub(firstSeen) < time.Duration(d.config.NewAccountAge) {
+			count, err := d.counters.Incr("spam:new:"+sender, now, time.Duration(d.config.NewAccounts.Window))
+			if err != nil {
+				return verdict, err
+			}
+			verdict.add(Signal{Name: "newAccount", Count: count, Max: d.config.NewAccounts.Max})
+		}
+	}
+
+	for _, action := range []constants.SpamAction{constants.SpamThrottle, constants.SpamHide, constants.SpamFlag} {
+		threshold, ok := d.config.Thresholds[action]
+		if !ok || verdict.Score < threshold || verdict.Score == 0 {
+			continue
+		}
+		if action == constants.SpamFlag && d.config.FlagCooldown > 0 {
+			flagged, err := d.counters.Incr("spam:flagged:"+sender, now, time.Duration(d.config.FlagCooldown))
+			if err != nil {
+				return verdict, err
+			}
+			if flagged > 1 {
+				continue
+			}
+		}
+		verdict.Actions = append(verdict.Actions, action)
+	}
+	return verdict, nil
+}
+
+func (v *Verdict) add(signal Signal) {
+	v.Signals = append(v.Signals, signal)
+	if signal.Count > int64(signal.Max) {
+		v.Score += float64(signal.Count) / float64(signal.Max)
+	}
+}
+
+// Fingerprint ignores case and spacing, so trivially varied copies still match
+func Fingerprint(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Duration reads durations such as "10m" from JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,104 @@
+package spam_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yonraz/gochat_messages/constants"
+	"github.com/yonraz/gochat_messages/spam"
+)
+
+func TestDetectorScoresSignalsOverTheirLimit(t *testing.T) {
+	config := spam.DefaultConfig()
+	config.Rate = spam.Limit{Max: 4, Window: spam.Duration(time.Minute)}
+	config.Duplicates = spam.Limit{Max: 2, Window: spam.Duration(time.Minute)}
+	config.NewAccounts = spam.Limit{}
+	config.Thresholds = map[constants.SpamAction]float64{
+		constants.SpamThrottle: 1,
+		constants.SpamFlag:     2,
+	}
+	detector, err := spam.NewDetector(config, spam.NewMemoryCounters())
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	detector.Clock = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		verdict, err := detector.Check("foo", "Buy cheap watches")
+		require.NoError(t, err)
+		assert.Zero(t, verdict.Score)
+		assert.Empty(t, verdict.Actions)
+	}
+
+	// spacing and case do not make a copy different
+	verdict, err := detector.Check("foo", "buy  CHEAP watches")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, verdict.Score)
+	assert.Equal(t, []constants.SpamAction{constants.SpamThrottle}, verdict.Actions)
+
+	// short replies are only rate limited
+	verdict, err = detector.Check("foo", "ok")
+	require.NoError(t, err)
+	assert.Zero(t, verdict.Score)
+	require.Len(t, verdict.Signals, 1)
+
+	verdict, err = detector.Check("foo", "buy cheap watches")
+	require.NoError(t, err)
+	assert.InDelta(t, 2+5.0/4, verdict.Score, 0.001)
+	assert.True(t, verdict.Has(constants.SpamFlag))
+	verdict, err = detector.Check("foo", "buy cheap watches")
+	require.NoError(t, err)
+	assert.True(t, verdict.Has(constants.SpamThrottle))
+	assert.False(t, verdict.Has(constants.SpamFlag), "flagged once per cooldown")
+
+	// other senders and later windows start over
+	verdict, err = detector.Check("bar", "buy cheap watches")
+	require.NoError(t, err)
+	assert.Zero(t, verdict.Score)
+	now = now.Add(time.Minute)
+	verdict, err = detector.Check("foo", "buy cheap watches")
+	require.NoError(t, err)
+	assert.Zero(t, verdict.Score)
+}
+
+func TestNewAccountsHaveTighterLimits(t *testing.T) {
+	config := spam.DefaultConfig()
+	config.NewAccounts = spam.Limit{Max: 1, Window: spam.Duration(time.Minute)}
+	config.NewAccountAge = spam.Duration(time.Hour)
+	detector, err := spam.NewDetector(config, spam.NewMemoryCounters())
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	detector.Clock = func() time.Time { return now }
+
+	verdict, err := detector.Check("foo", "hello")
+	require.NoError(t, err)
+	assert.Empty(t, verdict.Actions)
+	verdict, err = detector.Check("foo", "hello again")
+	require.NoError(t, err)
+	assert.True(t, verdict.Has(constants.SpamThrottle))
+
+	now = now.Add(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		verdict, err = detector.Check("foo", "hello")
+		require.NoError(t, err)
+		assert.Empty(t, verdict.Actions)
+	}
+}
+
+func TestConfigFileReplacesThresholds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spam.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rate": {"max": 5, "window": "30s"}, "thresholds": {"hide": 2}}`), 0o600))
+
+	config, err := spam.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, spam.Limit{Max: 5, Window: spam.Duration(30 * time.Second)}, config.Rate)
+	assert.Equal(t, spam.DefaultConfig().Duplicates, config.Duplicates)
+	assert.Equal(t, map[constants.SpamAction]float64{constants.SpamHide: 2}, config.Thresholds)
+
+	config.Thresholds["delete"] = 1
+	_, err = spam.NewDetector(config, spam.NewMemoryCounters())
+	assert.Error(t, err)
+}